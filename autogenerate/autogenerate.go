@@ -735,6 +735,9 @@ spec:
             deservedGpus:
               format: float
               type: number
+            gpuOverQuotaWeight:
+              format: int64
+              type: integer
             interactiveJobTimeLimitSecs:
               format: int64
               type: integer
@@ -12,25 +12,23 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package util
+package apply
 
-import "strings"
+import (
+	"github.com/run-ai/runai-cli/cmd/noderole"
+	"github.com/spf13/cobra"
+)
 
-func IsNeedWaitError(err error) bool {
-	// estr := err.Error()
-	// return strings.Contains(estr, "Need waited.")
-	return checkError(err, "Need waited.")
-}
-
-func checkError(err error, content string) bool {
-	estr := err.Error()
-	return strings.Contains(estr, content)
-}
+func Command() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "apply",
+		Short: "Apply resources from a file.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
 
-func IsConnectionRefusedError(err error) bool {
-	return checkError(err, "connection refused")
-}
+	command.AddCommand(noderole.Apply())
 
-func IsUnexpectedEOFError(err error) bool {
-	return checkError(err, "unexpected EOF")
+	return command
 }
@@ -0,0 +1,383 @@
+package clusterconfig
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ghodss/yaml"
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/scheduling"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	clusterConfigName  = "cluster-config"
+	clusterConfigLabel = "runai/cluster-config"
+	// configKey is the key of the ConfigMap data that holds the cluster configuration as YAML
+	configKey = "config"
+)
+
+// configKeySchema is a known key of the cluster configuration. A policy key returns for a container of a running
+// workload why the container would break the policy when it is enforced, or an empty string. It also returns whether
+// the container may break the policy but it cannot be told from its spec, e.g. a container that runs as the user of
+// its image, which is only counted
+type configKeySchema struct {
+	description string
+	violation   func(pod v1.Pod, container v1.Container) (string, bool)
+}
+
+var knownKeys = map[string]configKeySchema{
+	"enforceRunAsUser": {
+		description: "Researcher jobs run with the user ID of the researcher that submitted them, and not as root.",
+		violation:   runsAsRoot,
+	},
+	"enforcePreventPrivilegeEscalation": {
+		description: "Researcher jobs cannot gain more privileges than their process, e.g. with sudo.",
+		violation:   allowsPrivilegeEscalation,
+	},
+}
+
+func Command() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "cluster-config",
+		Short: "Manage the cluster configuration that the researcher CLI enforces.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	command.AddCommand(getCommand())
+	command.AddCommand(setCommand())
+	command.AddCommand(unsetCommand())
+
+	return command
+}
+
+func getCommand() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "get [KEY]",
+		Short: "Show the cluster configuration, or the value of a single key",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			configMap, err := getClusterConfigMap(client)
+			if err != nil {
+				return err
+			}
+			config := map[string]interface{}{}
+			if configMap != nil {
+				if config, err = parseConfig(configMap); err != nil {
+					return err
+				}
+			}
+
+			if len(args) == 1 {
+				if err := validateKey(args[0]); err != nil {
+					return err
+				}
+				fmt.Println(configValue(config, args[0]))
+				return nil
+			}
+
+			if configMap == nil {
+				log.Infof("The %s ConfigMap does not exist in namespace %s, no policy is enforced", clusterConfigName, common.RunaiNamespace)
+			}
+			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(writer, "KEY\tVALUE\tDESCRIPTION")
+			for _, key := range sortedKnownKeys() {
+				fmt.Fprintf(writer, "%s\t%s\t%s\n", key, configValue(config, key), knownKeys[key].description)
+			}
+			writer.Flush()
+			for _, key := range sortedKeys(config) {
+				if _, found := knownKeys[key]; !found {
+					log.Warnf("Unknown key in the cluster configuration: %s: %v", key, config[key])
+				}
+			}
+			return nil
+		},
+	}
+	return command
+}
+
+func setCommand() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "set KEY=VALUE...",
+		Short: "Set keys of the cluster configuration, e.g. enforceRunAsUser=true",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			values := map[string]interface{}{}
+			for _, arg := range args {
+				parts := strings.SplitN(arg, "=", 2)
+				if len(parts) != 2 {
+					return commandUtil.Validationf("Invalid argument: %v, expected KEY=VALUE", arg)
+				}
+				if err := validateKey(parts[0]); err != nil {
+					return err
+				}
+				value, err := strconv.ParseBool(parts[1])
+				if err != nil {
+					return commandUtil.Validationf("Invalid value of %v: %v, expected true or false", parts[0], parts[1])
+				}
+				values[parts[0]] = value
+			}
+
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			var enforced []string
+			err = updateClusterConfig(client, func(config map[string]interface{}) {
+				enforced = nil
+				for key, value := range values {
+					if value == true && config[key] != true {
+						enforced = append(enforced, key)
+					}
+					config[key] = value
+				}
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println("Successfully updated the cluster configuration")
+
+			sort.Strings(enforced)
+			if err := reportViolations(client, enforced); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+	return command
+}
+
+func unsetCommand() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "unset KEY...",
+		Short: "Remove keys from the cluster configuration, so that they are not enforced",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			err = updateClusterConfig(client, func(config map[string]interface{}) {
+				for _, key := range args {
+					if _, found := config[key]; !found {
+						log.Infof("Key: %v is not set", key)
+					}
+					delete(config, key)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println("Successfully updated the cluster configuration")
+			return nil
+		},
+	}
+	return command
+}
+
+// getClusterConfigMap returns the cluster-config ConfigMap, or nil if it does not exist
+func getClusterConfigMap(client *client.Client) (*v1.ConfigMap, error) {
+	configMap, err := client.GetClientset().CoreV1().ConfigMaps(common.RunaiNamespace).Get(clusterConfigName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the %s ConfigMap, error: %w", clusterConfigName, err)
+	}
+	return configMap, nil
+}
+
+func parseConfig(configMap *v1.ConfigMap) (map[string]interface{}, error) {
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(configMap.Data[configKey]), &config); err != nil {
+		return nil, fmt.Errorf("Failed to parse the %s ConfigMap, error: %w", clusterConfigName, err)
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	return config, nil
+}
+
+// updateClusterConfig changes the cluster configuration, and creates its ConfigMap if it does not exist
+func updateClusterConfig(client *client.Client, mutate func(config map[string]interface{})) error {
+	configMaps := client.GetClientset().CoreV1().ConfigMaps(common.RunaiNamespace)
+	return retry.Mutation(fmt.Sprintf("update the %s ConfigMap", clusterConfigName), func() error {
+		configMap, err := getClusterConfigMap(client)
+		if err != nil {
+			return err
+		}
+		create := configMap == nil
+		if create {
+			configMap = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: clusterConfigName, Namespace: common.RunaiNamespace}}
+		}
+		config, err := parseConfig(configMap)
+		if err != nil {
+			return err
+		}
+		mutate(config)
+
+		data, err := yaml.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("Failed to format the cluster configuration, error: %w", err)
+		}
+		if configMap.Labels == nil {
+			configMap.Labels = map[string]string{}
+		}
+		configMap.Labels[clusterConfigLabel] = "true"
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[configKey] = string(data)
+
+		if create {
+			_, err = configMaps.Create(configMap)
+			if errors.IsAlreadyExists(err) {
+				// created concurrently, the next attempt updates it
+				err = errors.NewConflict(v1.Resource("configmaps"), clusterConfigName, err)
+			}
+		} else {
+			_, err = configMaps.Update(configMap)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to update the %s ConfigMap, error: %w", clusterConfigName, err)
+		}
+		return nil
+	})
+}
+
+// reportViolations prints the running researcher workloads that would break the newly enforced policies. The
+// policies apply to jobs submitted from now on, so these workloads keep running until they are submitted again
+func reportViolations(client *client.Client, enforcedKeys []string) error {
+	if len(enforcedKeys) == 0 {
+		return nil
+	}
+	pods, err := client.GetClientset().CoreV1().Pods("").List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to list pods, error: %w", err)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	violations := 0
+	unknownViolations := map[string]int{}
+	for _, pod := range pods.Items {
+		if scheduling.PodGroupName(pod) == "" || (pod.Status.Phase != v1.PodRunning && pod.Status.Phase != v1.PodPending) {
+			continue
+		}
+		for _, key := range enforcedKeys {
+			for _, container := range pod.Spec.Containers {
+				reason, unknown := knownKeys[key].violation(pod, container)
+				if unknown {
+					unknownViolations[key]++
+				}
+				if reason != "" {
+					if violations == 0 {
+						fmt.Println("\nThe following running workloads would break the newly enforced policies when they are submitted again:")
+						fmt.Fprintln(writer, "NAMESPACE\tPOD\tCONTAINER\tPOLICY\tREASON")
+					}
+					violations++
+					fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", pod.Namespace, pod.Name, container.Name, key, reason)
+				}
+			}
+		}
+	}
+	writer.Flush()
+	if violations == 0 {
+		fmt.Printf("No running workloads break the newly enforced policies: %s\n", strings.Join(enforcedKeys, ", "))
+	}
+	for _, key := range enforcedKeys {
+		if unknownViolations[key] > 0 {
+			fmt.Printf("%d more containers of running workloads may break the %s policy, which cannot be told from their spec\n", unknownViolations[key], key)
+		}
+	}
+	return nil
+}
+
+// runsAsRoot reports the containers that run as root. A container that runs as the user of its image may run as root,
+// which cannot be told from its spec
+func runsAsRoot(pod v1.Pod, container v1.Container) (string, bool) {
+	runAsUser, runAsNonRoot := (*int64)(nil), (*bool)(nil)
+	if pod.Spec.SecurityContext != nil {
+		runAsUser, runAsNonRoot = pod.Spec.SecurityContext.RunAsUser, pod.Spec.SecurityContext.RunAsNonRoot
+	}
+	if container.SecurityContext != nil {
+		if container.SecurityContext.RunAsUser != nil {
+			runAsUser = container.SecurityContext.RunAsUser
+		}
+		if container.SecurityContext.RunAsNonRoot != nil {
+			runAsNonRoot = container.SecurityContext.RunAsNonRoot
+		}
+	}
+	switch {
+	case runAsUser != nil && *runAsUser == 0:
+		return "runs as root", false
+	case runAsUser == nil && (runAsNonRoot == nil || !*runAsNonRoot):
+		return "", true
+	}
+	return "", false
+}
+
+func allowsPrivilegeEscalation(pod v1.Pod, container v1.Container) (string, bool) {
+	if container.SecurityContext == nil {
+		return "allowPrivilegeEscalation is not set", false
+	}
+	if container.SecurityContext.Privileged != nil && *container.SecurityContext.Privileged {
+		return "runs privileged", false
+	}
+	if container.SecurityContext.AllowPrivilegeEscalation == nil {
+		return "allowPrivilegeEscalation is not set", false
+	}
+	if *container.SecurityContext.AllowPrivilegeEscalation {
+		return "allowPrivilegeEscalation is true", false
+	}
+	return "", false
+}
+
+func validateKey(key string) error {
+	if _, found := knownKeys[key]; !found {
+		return commandUtil.Validationf("Unknown key: %v, the known keys are: %s", key, strings.Join(sortedKnownKeys(), ", "))
+	}
+	return nil
+}
+
+func configValue(config map[string]interface{}, key string) string {
+	value, found := config[key]
+	if !found {
+		return "-"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func sortedKnownKeys() []string {
+	var keys []string
+	for key := range knownKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys(config map[string]interface{}) []string {
+	var keys []string
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
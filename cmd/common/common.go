@@ -1,50 +1,124 @@
 package common
 
 import (
-	"os"
+	"errors"
+	"fmt"
+	"strconv"
 
 	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/patch"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
 	log "github.com/sirupsen/logrus"
 	appsv1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 const (
-	NumberOfRetiresForApiServer        = 3
 	RunaiNamespace                     = "runai"
 	RunaiBackendNamespace              = "runai-backend"
 	RunaiOperatorDeploymentName        = "runai-operator"
 	RunaiBackendOperatorDeploymentName = "helm-operator"
+	RunaiConfigName                    = "runai"
+
+	// OriginalReplicasAnnotation keeps the replica count of an operator while it is scaled down
+	OriginalReplicasAnnotation = "runai/original-replicas"
+	defaultOperatorReplicas    = 1
+)
+
+var (
+	RunaiConfigResource = schema.GroupVersionResource{Group: "run.ai", Version: "v1", Resource: "runaiconfigs"}
+	DeploymentResource  = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
 )
 
-func ScaleRunaiOperator(client *client.Client, replicas int32) {
-	scaleDeployment(client, RunaiNamespace, RunaiOperatorDeploymentName, replicas)
+// GetRunaiConfig returns the RunaiConfig of the cluster. A missing RunaiConfig, or a missing RunaiConfig CRD, means
+// that Run:AI is not installed, while any other error is returned as is, e.g. when the API server is unreachable
+func GetRunaiConfig(client *client.Client) (*unstructured.Unstructured, error) {
+	runaiConfig, err := client.GetDynamicClient().Resource(RunaiConfigResource).Namespace(RunaiNamespace).Get(RunaiConfigName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, commandUtil.NotInstalled(errors.New("Failed to get RunaiConfig, Run:AI is not installed on the cluster"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get RunaiConfig, error: %w", err)
+	}
+	return runaiConfig, nil
+}
+
+// ScaleDownRunaiOperator scales the Run:AI operator to 0 replicas and saves its replica count so it can be restored
+func ScaleDownRunaiOperator(client *client.Client) error {
+	return scaleDownDeployment(client, RunaiNamespace, RunaiOperatorDeploymentName)
 }
 
-func ScaleRunaiBackendOperator(client *client.Client, replicas int32) {
-	scaleDeployment(client, RunaiBackendNamespace, RunaiBackendOperatorDeploymentName, replicas)
+// RestoreRunaiOperator scales the Run:AI operator back to the replica count it had before it was scaled down
+func RestoreRunaiOperator(client *client.Client) error {
+	return restoreDeployment(client, RunaiNamespace, RunaiOperatorDeploymentName)
 }
 
-func scaleDeployment(client *client.Client, namespace, deploymentName string, replicas int32) {
-	var err error
-	var deployment *appsv1.Deployment
-	for i := 0; i < NumberOfRetiresForApiServer; i++ {
-		deployment, err = client.GetClientset().AppsV1().Deployments(namespace).Get(deploymentName, metav1.GetOptions{})
-		if err != nil {
-			log.Infof("Failed to get %s, error: %v", deploymentName, err)
-			os.Exit(1)
+func ScaleDownRunaiBackendOperator(client *client.Client) error {
+	return scaleDownDeployment(client, RunaiBackendNamespace, RunaiBackendOperatorDeploymentName)
+}
+
+func RestoreRunaiBackendOperator(client *client.Client) error {
+	return restoreDeployment(client, RunaiBackendNamespace, RunaiBackendOperatorDeploymentName)
+}
+
+func scaleDownDeployment(client *client.Client, namespace, deploymentName string) error {
+	return updateDeployment(client, namespace, deploymentName, func(deployment *appsv1.Deployment) {
+		// a previous run that was interrupted may have left the original count, which must not be overridden by 0
+		if _, found := deployment.Annotations[OriginalReplicasAnnotation]; !found {
+			replicas := int32(defaultOperatorReplicas)
+			if deployment.Spec.Replicas != nil {
+				replicas = *deployment.Spec.Replicas
+			}
+			if deployment.Annotations == nil {
+				deployment.Annotations = map[string]string{}
+			}
+			deployment.Annotations[OriginalReplicasAnnotation] = strconv.Itoa(int(replicas))
 		}
+		replicas := int32(0)
 		deployment.Spec.Replicas = &replicas
-		deployment, err = client.GetClientset().AppsV1().Deployments(namespace).Update(deployment)
+	})
+}
+
+func restoreDeployment(client *client.Client, namespace, deploymentName string) error {
+	return updateDeployment(client, namespace, deploymentName, func(deployment *appsv1.Deployment) {
+		replicas := int32(defaultOperatorReplicas)
+		if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas > 0 {
+			// already restored, e.g. when rolling back a flow that has scaled the deployment up
+			replicas = *deployment.Spec.Replicas
+		}
+		if originalReplicas, found := deployment.Annotations[OriginalReplicasAnnotation]; found {
+			parsedReplicas, err := strconv.Atoi(originalReplicas)
+			if err != nil {
+				log.Warnf("Ignoring invalid %s annotation on %s: %v", OriginalReplicasAnnotation, deploymentName, originalReplicas)
+			} else {
+				replicas = int32(parsedReplicas)
+			}
+		}
+		delete(deployment.Annotations, OriginalReplicasAnnotation)
+		deployment.Spec.Replicas = &replicas
+	})
+}
+
+// updateDeployment patches the replicas of the deployment and the annotation that keeps its original replica count
+func updateDeployment(client *client.Client, namespace, deploymentName string, mutate func(deployment *appsv1.Deployment)) error {
+	var deployment *appsv1.Deployment
+	err := retry.Mutation(fmt.Sprintf("update %s", deploymentName), func() error {
+		current, err := client.GetClientset().AppsV1().Deployments(namespace).Get(deploymentName, metav1.GetOptions{})
 		if err != nil {
-			log.Debugf("Failed to update %s, attempt: %v, error: %v", deploymentName, i, err)
-			continue
+			return err
 		}
-		break
-	}
+		deployment = current.DeepCopy()
+		mutate(deployment)
+		return patch.Update(client.GetDynamicClient().Resource(DeploymentResource).Namespace(namespace), current, deployment,
+			[]string{"spec", "replicas"}, []string{"metadata", "annotations", OriginalReplicasAnnotation})
+	})
 	if err != nil {
-		log.Infof("Failed to update %s, error: %v", deploymentName, err)
-		os.Exit(1)
+		return fmt.Errorf("Failed to update %s, error: %w", deploymentName, err)
 	}
-	log.Infof("Scaled %s to: %v", deploymentName, replicas)
+	log.Infof("Scaled %s to: %v", deploymentName, *deployment.Spec.Replicas)
+	return nil
 }
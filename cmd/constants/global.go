@@ -1,5 +0,0 @@
-package constants
-
-const (
-	NumberOfRetiresForApiServer = 3
-)
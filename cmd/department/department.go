@@ -0,0 +1,243 @@
+package department
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/scheduling"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func Command() *cobra.Command {
+	var command = &cobra.Command{
+		Use:     "department",
+		Aliases: []string{"departments"},
+		Short:   "Manage Run:AI departments.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	command.AddCommand(createCommand())
+	command.AddCommand(listCommand())
+	command.AddCommand(updateCommand())
+	command.AddCommand(deleteCommand())
+
+	return command
+}
+
+func createCommand() *cobra.Command {
+	deservedGpus := float64(0)
+	force := false
+	var command = &cobra.Command{
+		Use:   "create DEPARTMENT_NAME",
+		Short: "Create a department",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if messages := validation.IsDNS1123Subdomain(args[0]); len(messages) > 0 {
+				return commandUtil.Validationf("Invalid department name: %v, %s", args[0], strings.Join(messages, ", "))
+			}
+			if deservedGpus < 0 {
+				return commandUtil.Validationf("--deserved-gpus cannot be negative")
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			department := &unstructured.Unstructured{Object: map[string]interface{}{}}
+			department.SetAPIVersion(scheduling.DepartmentResource.GroupVersion().String())
+			department.SetKind("Department")
+			department.SetName(args[0])
+			unstructured.SetNestedField(department.Object, deservedGpus, "spec", "deservedGpus")
+			if err := scheduling.CheckQuotaHierarchy(client, func(hierarchy *scheduling.QuotaHierarchy) { hierarchy.SetDepartment(*department) }, force); err != nil {
+				return err
+			}
+
+			err = retry.Mutation(fmt.Sprintf("create department %v", args[0]), func() error {
+				_, err := client.GetDynamicClient().Resource(scheduling.DepartmentResource).Create(department, metav1.CreateOptions{})
+				return err
+			})
+			if errors.IsAlreadyExists(err) {
+				return commandUtil.Conflict(fmt.Errorf("Department: %v already exists, use 'department update' to change it", args[0]))
+			}
+			if err != nil {
+				return fmt.Errorf("Failed to create department: %v, error: %w", args[0], err)
+			}
+			fmt.Printf("Successfully created department: %v\n", args[0])
+			return nil
+		},
+	}
+
+	command.Flags().Float64Var(&deservedGpus, "deserved-gpus", 0, "GPU quota that the projects of the department are guaranteed to get together.")
+	addForceFlag(command, &force)
+	return command
+}
+
+func updateCommand() *cobra.Command {
+	deservedGpus := float64(0)
+	force := false
+	var command = &cobra.Command{
+		Use:   "update DEPARTMENT_NAME",
+		Short: "Update the GPU quota of a department",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !cmd.Flags().Changed("deserved-gpus") {
+				cmd.HelpFunc()(cmd, args)
+				return commandUtil.Validationf("No flags were provided")
+			}
+			if deservedGpus < 0 {
+				return commandUtil.Validationf("--deserved-gpus cannot be negative")
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			err = updateDepartment(client, args[0], func(department *unstructured.Unstructured) error {
+				unstructured.SetNestedField(department.Object, deservedGpus, "spec", "deservedGpus")
+				return scheduling.CheckQuotaHierarchy(client, func(hierarchy *scheduling.QuotaHierarchy) { hierarchy.SetDepartment(*department) }, force)
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Successfully updated department: %v\n", args[0])
+			return nil
+		},
+	}
+
+	command.Flags().Float64Var(&deservedGpus, "deserved-gpus", 0, "GPU quota that the projects of the department are guaranteed to get together.")
+	addForceFlag(command, &force)
+	return command
+}
+
+func updateDepartment(client *client.Client, name string, mutate func(department *unstructured.Unstructured) error) error {
+	err := retry.Mutation(fmt.Sprintf("update department %v", name), func() error {
+		department, err := client.GetDynamicClient().Resource(scheduling.DepartmentResource).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("Department: %v does not exist", name)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to get department: %v, error: %w", name, err)
+		}
+		if err := mutate(department); err != nil {
+			return err
+		}
+		_, err = client.GetDynamicClient().Resource(scheduling.DepartmentResource).Update(department, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to update department: %v, error: %w", name, err)
+	}
+	return nil
+}
+
+func listCommand() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "list",
+		Short: "List the departments and the GPU quotas of their projects",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			hierarchy, err := scheduling.GetQuotaHierarchy(client)
+			if err != nil {
+				return err
+			}
+			allocated, err := scheduling.AllocatedGpusByQueue(client)
+			if err != nil {
+				return err
+			}
+
+			departmentProjects := hierarchy.DepartmentProjects()
+			sort.Slice(hierarchy.Departments, func(i, j int) bool { return hierarchy.Departments[i].GetName() < hierarchy.Departments[j].GetName() })
+			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(writer, "NAME\tDESERVED GPUs\tPROJECTS\tPROJECTS DESERVED GPUs\tALLOCATED GPUs")
+			totalDeserved := float64(0)
+			for _, department := range hierarchy.Departments {
+				deserved := scheduling.NestedFloat(department.Object, "spec", "deservedGpus")
+				totalDeserved += deserved
+				projectsDeserved, projectsAllocated := float64(0), float64(0)
+				for _, project := range departmentProjects[department.GetName()] {
+					projectsDeserved += scheduling.NestedFloat(project.Object, "spec", "deservedGpus")
+					projectsAllocated += allocated[project.GetName()]
+				}
+				fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%s\n", department.GetName(), scheduling.FormatGpus(deserved),
+					len(departmentProjects[department.GetName()]), scheduling.FormatGpus(projectsDeserved), scheduling.FormatGpus(projectsAllocated))
+			}
+			writer.Flush()
+			fmt.Printf("\nThe departments are guaranteed %s of the %s GPUs in the cluster\n", scheduling.FormatGpus(totalDeserved), scheduling.FormatGpus(hierarchy.ClusterGpus))
+			if err := hierarchy.Validate(); err != nil {
+				fmt.Println(err)
+			}
+			return nil
+		},
+	}
+	return command
+}
+
+func deleteCommand() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "delete DEPARTMENT_NAME...",
+		Short: "Delete departments that have no projects",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			hierarchy, err := scheduling.GetQuotaHierarchy(client)
+			if err != nil {
+				return err
+			}
+
+			departmentProjects := hierarchy.DepartmentProjects()
+			failed := 0
+			for _, name := range args {
+				if projects := departmentProjects[name]; len(projects) > 0 {
+					var projectNames []string
+					for _, project := range projects {
+						projectNames = append(projectNames, project.GetName())
+					}
+					sort.Strings(projectNames)
+					fmt.Printf("Department: %v has projects: %s, move them to another department with 'project move' first\n", name, strings.Join(projectNames, ", "))
+					failed++
+					continue
+				}
+				err := retry.Mutation(fmt.Sprintf("delete department %v", name), func() error {
+					return client.GetDynamicClient().Resource(scheduling.DepartmentResource).Delete(name, &metav1.DeleteOptions{})
+				})
+				if errors.IsNotFound(err) {
+					log.Infof("Department: %v does not exist", name)
+					continue
+				}
+				if err != nil {
+					fmt.Printf("Failed to delete department: %v, error: %v\n", name, err)
+					failed++
+					continue
+				}
+				fmt.Printf("Successfully deleted department: %v\n", name)
+			}
+			if failed > 0 {
+				return fmt.Errorf("Failed to delete %d of %d departments", failed, len(args))
+			}
+			return nil
+		},
+	}
+	return command
+}
+
+func addForceFlag(command *cobra.Command, force *bool) {
+	command.Flags().BoolVar(force, "force", false, "Apply the change even if the GPU quotas exceed their limits.")
+}
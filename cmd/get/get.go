@@ -15,6 +15,7 @@
 package get
 
 import (
+	"github.com/run-ai/runai-cli/cmd/queue"
 	"github.com/run-ai/runai-cli/cmd/version"
 	"github.com/spf13/cobra"
 )
@@ -23,12 +24,14 @@ func Command() *cobra.Command {
 	var command = &cobra.Command{
 		Use:   "get",
 		Short: "Get resources.",
-		Run: func(cmd *cobra.Command, args []string) {
-			cmd.HelpFunc()(cmd, args)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
 		},
 	}
 
 	command.AddCommand(version.GetVersion())
+	command.AddCommand(queue.GetQueues())
+	command.AddCommand(queue.GetPodGroups())
 
 	return command
 }
@@ -17,6 +17,7 @@ package install
 import (
 	"fmt"
 
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
 	"github.com/run-ai/runai-cli/pkg/util/kubectl"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -32,19 +33,23 @@ func Command() *cobra.Command {
 		Use:   "install",
 		Short: "Install a Run:AI cluster.",
 		Args:  cobra.ExactArgs(0),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if cmd.Flags().NFlag() == 0 {
-				fmt.Println("No flags were provided")
 				cmd.HelpFunc()(cmd, args)
-				return
+				return commandUtil.Validationf("No flags were provided")
 			}
 
 			log.Infof("Installing from file: %v", upgradeFlags.filePath)
+			var err error
 			for i := 0; i < 2; i++ {
-				kubectl.Apply(upgradeFlags.filePath) // need to remove the crds from this file
+				err = kubectl.Apply(upgradeFlags.filePath) // need to remove the crds from this file
+			}
+			if err != nil {
+				return fmt.Errorf("Failed to apply %v, error: %w", upgradeFlags.filePath, err)
 			}
 
 			log.Println("Successfully installed Run:AI Cluster")
+			return nil
 		},
 	}
 
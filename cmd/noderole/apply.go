@@ -0,0 +1,346 @@
+package noderole
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	runaiSystemRole = "runai-system"
+	gpuWorkerRole   = "gpu-worker"
+	cpuWorkerRole   = "cpu-worker"
+)
+
+var (
+	roleNameToLabel = map[string]string{
+		runaiSystemRole: systemWorkerLabel,
+		gpuWorkerRole:   gpuWorkerLabel,
+		cpuWorkerRole:   cpuWorkerLabel,
+	}
+)
+
+// nodeRolesDocument is the declarative assignment of node roles, e.g.
+//
+//	roles:
+//	  runai-system:
+//	    nodes: [node-1, node-2]
+//	  gpu-worker:
+//	    selector: nvidia.com/gpu.present=true
+//	  cpu-worker:
+//	    selector: "!nvidia.com/gpu.present"
+//
+// The document is authoritative: a role that is missing from the document is removed from all nodes
+type nodeRolesDocument struct {
+	Roles map[string]roleAssignment `json:"roles"`
+}
+
+type roleAssignment struct {
+	Nodes    []string `json:"nodes,omitempty"`
+	Selector string   `json:"selector,omitempty"`
+}
+
+// nodeRolesChange is the role labels to add and remove on a single node
+type nodeRolesChange struct {
+	NodeName string
+	Add      []string
+	Remove   []string
+}
+
+func Apply() *cobra.Command {
+	filePath := ""
+	dryRun := false
+	evictionOptions := evictionOptions{}
+	safetyOptions := safetyOptions{}
+	withBackend := false
+	parallelism := defaultParallelism
+	var command = &cobra.Command{
+		Use:     "node-roles -f FILE",
+		Aliases: []string{"node-role"},
+		Short:   "Apply node roles from a file",
+		Long:    "Reconcile the node roles of the cluster to match a file that maps each role (runai-system, gpu-worker, cpu-worker) to node names and label selectors. Roles that are missing from the file are removed from all nodes. Only the configurations of the roles that change are updated, and when no role changes the RunaiConfig node affinity is reconciled with the roles of the nodes.",
+		Args:    cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if filePath == "" {
+				cmd.HelpFunc()(cmd, args)
+				return commandUtil.Validationf("No file was provided")
+			}
+			if err := validateParallelism(parallelism); err != nil {
+				return err
+			}
+			document, err := readNodeRolesDocument(filePath)
+			if err != nil {
+				return err
+			}
+
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			nodeList, err := client.GetClientset().CoreV1().Nodes().List(metav1.ListOptions{})
+			if err != nil {
+				return fmt.Errorf("Failed to list nodes in cluster, error: %w", err)
+			}
+			if len(nodeList.Items) == 0 {
+				return fmt.Errorf("Failed to list nodes in cluster, the cluster has no nodes")
+			}
+			desiredRoles, err := document.desiredRoleLabels(nodeList.Items)
+			if err != nil {
+				return err
+			}
+
+			changes := planNodeRolesChanges(nodeList.Items, desiredRoles)
+			printNodeRolesPlan(changes)
+			if dryRun {
+				return nil
+			}
+			if len(changes) == 0 {
+				return reconcileRunaiConfigNodeAffinity(client, nodeList.Items)
+			}
+
+			flags := changedRoleTypes(changes)
+			resultingNodes := applyNodeRolesChanges(nodeList.Items, changes)
+			if err := refuseUnlessForced(validateResultingNodes(client, resultingNodes, flags, safetyOptions), safetyOptions); err != nil {
+				return err
+			}
+
+			err = runNodeRolesFlow(client, flags, withBackend, evictionOptions, func(originalNodeRoles map[string]nodeRoles) (map[string]v1.Node, error) {
+				return applyNodeRolesChangesToCluster(client, changes, parallelism, originalNodeRoles)
+			})
+			if err != nil {
+				return err
+			}
+			log.Info("Successfully applied node roles")
+			return nil
+		},
+	}
+
+	command.Flags().StringVarP(&filePath, "file", "f", "", "Path of a node roles .yaml file")
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "Only print the plan, without applying it.")
+	command.Flags().BoolVar(&withBackend, "with-backend", false, "Update backend pods (In Air-gapped environment). The backend must be installed by a Flux HelmRelease, a plain Helm release is refused before any node is changed.")
+	addParallelismFlag(command, &parallelism)
+	addEvictionFlags(command, &evictionOptions)
+	addSafetyFlags(command, &safetyOptions)
+	return command
+}
+
+func readNodeRolesDocument(filePath string) (*nodeRolesDocument, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read file: %v, error: %w", filePath, err)
+	}
+
+	document := &nodeRolesDocument{}
+	if err := yaml.Unmarshal(content, document); err != nil {
+		return nil, commandUtil.Validation(fmt.Errorf("Failed to parse file: %v, error: %w", filePath, err))
+	}
+	for roleName := range document.Roles {
+		if _, found := roleNameToLabel[roleName]; !found {
+			return nil, commandUtil.Validationf("Unknown role: %v, must be one of: %s, %s, %s", roleName, runaiSystemRole, gpuWorkerRole, cpuWorkerRole)
+		}
+	}
+	return document, nil
+}
+
+// desiredRoleLabels returns the role labels that each node should have according to the document
+func (document *nodeRolesDocument) desiredRoleLabels(nodes []v1.Node) (map[string]map[string]bool, error) {
+	desiredRoles := map[string]map[string]bool{}
+	nodesByName := map[string]v1.Node{}
+	for _, node := range nodes {
+		desiredRoles[node.Name] = map[string]bool{}
+		nodesByName[node.Name] = node
+	}
+
+	var missingNodes []string
+	for roleName, assignment := range document.Roles {
+		label := roleNameToLabel[roleName]
+		for _, nodeName := range assignment.Nodes {
+			if _, found := nodesByName[nodeName]; !found {
+				missingNodes = append(missingNodes, nodeName)
+				continue
+			}
+			desiredRoles[nodeName][label] = true
+		}
+
+		if assignment.Selector == "" {
+			continue
+		}
+		selector, err := labels.Parse(assignment.Selector)
+		if err != nil {
+			return nil, commandUtil.Validation(fmt.Errorf("Invalid selector of role: %v, error: %w", roleName, err))
+		}
+		for _, node := range nodes {
+			if selector.Matches(labels.Set(node.Labels)) {
+				desiredRoles[node.Name][label] = true
+			}
+		}
+	}
+
+	if len(missingNodes) > 0 {
+		sort.Strings(missingNodes)
+		return nil, commandUtil.Validationf("The following nodes were not found in cluster: %s", strings.Join(missingNodes, ", "))
+	}
+	return desiredRoles, nil
+}
+
+func planNodeRolesChanges(nodes []v1.Node, desiredRoles map[string]map[string]bool) []nodeRolesChange {
+	var changes []nodeRolesChange
+	for _, node := range nodes {
+		change := nodeRolesChange{NodeName: node.Name}
+		for _, label := range allRoleLabels {
+			_, hasLabel := node.Labels[label]
+			shouldHaveLabel := desiredRoles[node.Name][label]
+			if shouldHaveLabel && !hasLabel {
+				change.Add = append(change.Add, label)
+			} else if !shouldHaveLabel && hasLabel {
+				change.Remove = append(change.Remove, label)
+			}
+		}
+		if len(change.Add) > 0 || len(change.Remove) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].NodeName < changes[j].NodeName })
+	return changes
+}
+
+func printNodeRolesPlan(changes []nodeRolesChange) {
+	if len(changes) == 0 {
+		fmt.Println("Node roles are up to date, nothing to apply")
+		return
+	}
+
+	labelToRoleName := map[string]string{}
+	for roleName, label := range roleNameToLabel {
+		labelToRoleName[label] = roleName
+	}
+
+	fmt.Printf("Plan: %d nodes to update\n", len(changes))
+	for _, change := range changes {
+		var roles []string
+		for _, label := range change.Add {
+			roles = append(roles, "+"+labelToRoleName[label])
+		}
+		for _, label := range change.Remove {
+			roles = append(roles, "-"+labelToRoleName[label])
+		}
+		fmt.Printf("  %s: %s\n", change.NodeName, strings.Join(roles, " "))
+	}
+	fmt.Println("The RunaiConfig node affinity will be updated and the Run:AI operator restarted once")
+}
+
+// changedRoleTypes returns the roles that the changes add to or remove from any node, so that only the configurations
+// of these roles are updated
+func changedRoleTypes(changes []nodeRolesChange) nodeRoleTypes {
+	flags := nodeRoleTypes{}
+	for _, change := range changes {
+		for _, label := range append(append([]string{}, change.Add...), change.Remove...) {
+			switch label {
+			case cpuWorkerLabel:
+				flags.CpuWorker = true
+			case gpuWorkerLabel:
+				flags.GpuWorker = true
+			case systemWorkerLabel:
+				flags.RunaiSystemWorker = true
+			}
+		}
+	}
+	return flags
+}
+
+// reconcileRunaiConfigNodeAffinity updates the node affinity restrictions of the RunaiConfig to match the roles of
+// the nodes, e.g. when the RunaiConfig was changed after the roles were applied. The operators are not restarted
+func reconcileRunaiConfigNodeAffinity(client *client.Client, nodes []v1.Node) error {
+	state := &nodeRolesState{nodesInCluster: map[string]v1.Node{}}
+	for _, node := range nodes {
+		state.nodesInCluster[node.Name] = node
+	}
+	state.updateRestrictions()
+
+	flags := nodeRoleTypes{CpuWorker: true, GpuWorker: true, RunaiSystemWorker: true}
+	_, updated, err := updateRunaiConfigIfNeeded(client, flags, state.nodeWithRestrictSchedulingExist, state.nodeWithRestrictRunaiSystemExist)
+	if err != nil {
+		return err
+	}
+	if updated {
+		log.Infof("Updated the RunaiConfig node affinity to match the node roles, restrictScheduling: %v, restrictRunaiSystem: %v", state.nodeWithRestrictSchedulingExist, state.nodeWithRestrictRunaiSystemExist)
+	}
+	return nil
+}
+
+func setNodeRolesChange(labels map[string]string, change nodeRolesChange) {
+	for _, label := range change.Add {
+		labels[label] = ""
+	}
+	for _, label := range change.Remove {
+		delete(labels, label)
+	}
+}
+
+// applyNodeRolesChanges returns the nodes as they would be labeled after the changes
+func applyNodeRolesChanges(nodes []v1.Node, changes []nodeRolesChange) []v1.Node {
+	changesByNode := map[string]nodeRolesChange{}
+	for _, change := range changes {
+		changesByNode[change.NodeName] = change
+	}
+
+	resultingNodes := make([]v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		node = *node.DeepCopy()
+		if change, found := changesByNode[node.Name]; found {
+			if node.Labels == nil {
+				node.Labels = map[string]string{}
+			}
+			setNodeRolesChange(node.Labels, change)
+		}
+		resultingNodes = append(resultingNodes, node)
+	}
+	return resultingNodes
+}
+
+func applyNodeRolesChangesToCluster(client *client.Client, changes []nodeRolesChange, parallelism int, originalNodeRoles map[string]nodeRoles) (map[string]v1.Node, error) {
+	log.Info("Updating nodes with roles")
+	changesByNode := map[string]nodeRolesChange{}
+	for _, change := range changes {
+		changesByNode[change.NodeName] = change
+	}
+
+	nodeList, err := client.GetClientset().CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list nodes in cluster, error: %w", err)
+	}
+
+	nodesInCluster := map[string]v1.Node{}
+	var nodesToUpdate []v1.Node
+	for _, nodeInfo := range nodeList.Items {
+		nodesInCluster[nodeInfo.Name] = nodeInfo
+		if _, found := changesByNode[nodeInfo.Name]; found {
+			originalNodeRoles[nodeInfo.Name] = getNodeRoles(nodeInfo)
+			nodesToUpdate = append(nodesToUpdate, nodeInfo)
+		}
+	}
+
+	updatedNodes, err := updateNodesInParallel(client, nodesToUpdate, parallelism, func(nodeInfo *v1.Node) error {
+		change := changesByNode[nodeInfo.Name]
+		return updateNode(client, nodeInfo, func(nodeInfo *v1.Node) {
+			setNodeRolesChange(nodeInfo.Labels, change)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for nodeName, nodeInfo := range updatedNodes {
+		nodesInCluster[nodeName] = nodeInfo
+	}
+	return nodesInCluster, nil
+}
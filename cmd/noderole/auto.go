@@ -0,0 +1,279 @@
+package noderole
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ghodss/yaml"
+	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// autoRolesRules classifies nodes into GPU workers and CPU workers, e.g.
+//
+//	gpuResources: [nvidia.com/gpu]
+//	gpuLabels:
+//	  nvidia.com/gpu.present: "true"
+//	gpuTaints: [nvidia.com/gpu]
+//	excludeLabels: [node-role.kubernetes.io/master, node-role.kubernetes.io/control-plane]
+//	excludeTaints: [node-role.kubernetes.io/master, node-role.kubernetes.io/control-plane]
+//
+// A node is excluded when it has any of the exclude labels or taints, otherwise it is a GPU worker when it has
+// allocatable GPU resources, any of the GPU labels (an empty value matches any value) or any of the GPU taints,
+// and a CPU worker when it has none. Fields that are missing from the rules file keep their default
+type autoRolesRules struct {
+	GpuResources  []string          `json:"gpuResources"`
+	GpuLabels     map[string]string `json:"gpuLabels"`
+	GpuTaints     []string          `json:"gpuTaints"`
+	ExcludeLabels []string          `json:"excludeLabels"`
+	ExcludeTaints []string          `json:"excludeTaints"`
+}
+
+// autoRoleAssignment is the role proposed for a single node and the reason it was chosen
+type autoRoleAssignment struct {
+	NodeName string
+	Role     string
+	Reason   string
+}
+
+// autoRolesLabeling is a single call of labelNodesWithRolesAndGetNodesInCluster
+type autoRolesLabeling struct {
+	flags     nodeRoleTypes
+	nodeNames []string
+	enable    bool
+}
+
+func defaultAutoRolesRules() autoRolesRules {
+	controlPlaneKeys := []string{"node-role.kubernetes.io/master", "node-role.kubernetes.io/control-plane"}
+	return autoRolesRules{
+		GpuResources:  []string{string(gpuResourceName)},
+		GpuLabels:     map[string]string{"nvidia.com/gpu.present": "true"},
+		GpuTaints:     []string{string(gpuResourceName)},
+		ExcludeLabels: controlPlaneKeys,
+		ExcludeTaints: controlPlaneKeys,
+	}
+}
+
+func readAutoRolesRules(filePath string) (autoRolesRules, error) {
+	defaults := defaultAutoRolesRules()
+	if filePath == "" {
+		return defaults, nil
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return autoRolesRules{}, fmt.Errorf("Failed to read rules file: %v, error: %w", filePath, err)
+	}
+	rules := autoRolesRules{}
+	if err := yaml.Unmarshal(content, &rules); err != nil {
+		return autoRolesRules{}, commandUtil.Validation(fmt.Errorf("Failed to parse rules file: %v, error: %w", filePath, err))
+	}
+	if rules.GpuResources == nil {
+		rules.GpuResources = defaults.GpuResources
+	}
+	if rules.GpuLabels == nil {
+		rules.GpuLabels = defaults.GpuLabels
+	}
+	if rules.GpuTaints == nil {
+		rules.GpuTaints = defaults.GpuTaints
+	}
+	if rules.ExcludeLabels == nil {
+		rules.ExcludeLabels = defaults.ExcludeLabels
+	}
+	if rules.ExcludeTaints == nil {
+		rules.ExcludeTaints = defaults.ExcludeTaints
+	}
+	return rules, nil
+}
+
+func (rules autoRolesRules) classify(node v1.Node) autoRoleAssignment {
+	assignment := autoRoleAssignment{NodeName: node.Name}
+	for _, label := range rules.ExcludeLabels {
+		if _, found := node.Labels[label]; found {
+			assignment.Reason = fmt.Sprintf("excluded by label %s", label)
+			return assignment
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		for _, key := range rules.ExcludeTaints {
+			if taint.Key == key {
+				assignment.Reason = fmt.Sprintf("excluded by taint %s", key)
+				return assignment
+			}
+		}
+	}
+
+	assignment.Role = gpuWorkerRole
+	for _, resourceName := range rules.GpuResources {
+		quantity := node.Status.Allocatable[v1.ResourceName(resourceName)]
+		if !quantity.IsZero() {
+			assignment.Reason = fmt.Sprintf("allocatable %s: %s", resourceName, quantity.String())
+			return assignment
+		}
+	}
+	for label, value := range rules.GpuLabels {
+		if nodeValue, found := node.Labels[label]; found && (value == "" || nodeValue == value) {
+			assignment.Reason = fmt.Sprintf("label %s=%s", label, nodeValue)
+			return assignment
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		for _, key := range rules.GpuTaints {
+			if taint.Key == key {
+				assignment.Reason = fmt.Sprintf("taint %s", key)
+				return assignment
+			}
+		}
+	}
+
+	assignment.Role = cpuWorkerRole
+	assignment.Reason = "no GPUs detected"
+	return assignment
+}
+
+// proposeAutoRoles classifies the given nodes, or all the nodes in the cluster when no nodes are given
+func proposeAutoRoles(nodes []v1.Node, args []string, rules autoRolesRules) ([]autoRoleAssignment, error) {
+	selectedNodes := map[string]bool{}
+	for _, nodeName := range args {
+		selectedNodes[nodeName] = true
+	}
+
+	var assignments []autoRoleAssignment
+	for _, node := range nodes {
+		if len(args) > 0 && !selectedNodes[node.Name] {
+			continue
+		}
+		delete(selectedNodes, node.Name)
+		assignments = append(assignments, rules.classify(node))
+	}
+	if len(selectedNodes) > 0 {
+		var missingNodes []string
+		for nodeName := range selectedNodes {
+			missingNodes = append(missingNodes, nodeName)
+		}
+		sort.Strings(missingNodes)
+		return nil, commandUtil.Validationf("The following nodes were not found in cluster: %v", missingNodes)
+	}
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].NodeName < assignments[j].NodeName })
+	return assignments, nil
+}
+
+func printAutoRoles(assignments []autoRoleAssignment) {
+	fmt.Println("Proposed node roles:")
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "NODE\tROLE\tREASON")
+	for _, assignment := range assignments {
+		role := assignment.Role
+		if role == "" {
+			role = "-"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", assignment.NodeName, role, assignment.Reason)
+	}
+	writer.Flush()
+}
+
+func autoRoleNodes(assignments []autoRoleAssignment, role string) []string {
+	var nodeNames []string
+	for _, assignment := range assignments {
+		if assignment.Role == role {
+			nodeNames = append(nodeNames, assignment.NodeName)
+		}
+	}
+	return nodeNames
+}
+
+// autoRolesLabelings returns the labelings that apply the proposal: the GPU workers get the GPU Worker role
+// and lose the CPU Worker role, and the CPU workers get the CPU Worker role and lose the GPU Worker role
+func autoRolesLabelings(assignments []autoRoleAssignment, taint bool) []autoRolesLabeling {
+	gpuNodes := autoRoleNodes(assignments, gpuWorkerRole)
+	cpuNodes := autoRoleNodes(assignments, cpuWorkerRole)
+	labelings := []autoRolesLabeling{
+		{flags: nodeRoleTypes{GpuWorker: true, Taint: taint}, nodeNames: gpuNodes, enable: true},
+		{flags: nodeRoleTypes{CpuWorker: true, Taint: taint}, nodeNames: gpuNodes, enable: false},
+		{flags: nodeRoleTypes{CpuWorker: true, Taint: taint}, nodeNames: cpuNodes, enable: true},
+		{flags: nodeRoleTypes{GpuWorker: true, Taint: taint}, nodeNames: cpuNodes, enable: false},
+	}
+
+	var result []autoRolesLabeling
+	for _, labeling := range labelings {
+		if len(labeling.nodeNames) > 0 {
+			result = append(result, labeling)
+		}
+	}
+	return result
+}
+
+func simulateAutoRoles(nodes []v1.Node, labelings []autoRolesLabeling) []v1.Node {
+	for _, labeling := range labelings {
+		nodes = simulateRoleLabels(nodes, labeling.flags, labeling.nodeNames, labeling.enable)
+	}
+	return nodes
+}
+
+// applyAutoRoles labels the nodes through labelNodesWithRolesAndGetNodesInCluster, keeping the roles each
+// node had before the first labeling so that the flow can restore them
+func applyAutoRoles(client *client.Client, labelings []autoRolesLabeling, originalNodeRoles map[string]nodeRoles) (map[string]v1.Node, error) {
+	var nodesInCluster map[string]v1.Node
+	for _, labeling := range labelings {
+		labelingNodeRoles := map[string]nodeRoles{}
+		var err error
+		nodesInCluster, err = labelNodesWithRolesAndGetNodesInCluster(client, labeling.flags, labeling.nodeNames, labeling.enable, labelingNodeRoles)
+		for nodeName, roles := range labelingNodeRoles {
+			if _, found := originalNodeRoles[nodeName]; !found {
+				originalNodeRoles[nodeName] = roles
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nodesInCluster, nil
+}
+
+// setAutoNodeRoles proposes node roles from the rules, prints the proposal and applies it unless dryRun is set
+func setAutoNodeRoles(client *client.Client, args []string, rulesFile string, dryRun, taint bool, parallelism int, withBackend bool, evictionOptions evictionOptions, safetyOptions safetyOptions) error {
+	rules, err := readAutoRolesRules(rulesFile)
+	if err != nil {
+		return err
+	}
+	nodeList, err := client.GetClientset().CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to list nodes in cluster, error: %w", err)
+	}
+	if len(nodeList.Items) == 0 {
+		return fmt.Errorf("Failed to list nodes in cluster, the cluster has no nodes")
+	}
+	assignments, err := proposeAutoRoles(nodeList.Items, args, rules)
+	if err != nil {
+		return err
+	}
+
+	printAutoRoles(assignments)
+	labelings := autoRolesLabelings(assignments, taint)
+	for i := range labelings {
+		labelings[i].flags.Parallelism = parallelism
+	}
+	if len(labelings) == 0 {
+		log.Info("No nodes to assign roles to")
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+
+	flags := nodeRoleTypes{CpuWorker: true, GpuWorker: true, Taint: taint}
+	resultingNodes := simulateAutoRoles(nodeList.Items, labelings)
+	if err := refuseUnlessForced(validateResultingNodes(client, resultingNodes, flags, safetyOptions), safetyOptions); err != nil {
+		return err
+	}
+
+	return runNodeRolesFlow(client, flags, withBackend, evictionOptions, func(originalNodeRoles map[string]nodeRoles) (map[string]v1.Node, error) {
+		return applyAutoRoles(client, labelings, originalNodeRoles)
+	})
+}
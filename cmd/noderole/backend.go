@@ -0,0 +1,252 @@
+package noderole
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	runaiBackendReleaseName = "runai-backend"
+)
+
+var (
+	fluxV1HelmReleaseResource = schema.GroupVersionResource{Group: "helm.fluxcd.io", Version: "v1", Resource: "helmreleases"}
+	// the Flux v2 versions, from the newest, that are tried in order
+	fluxV2HelmReleaseResources = []schema.GroupVersionResource{
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2", Resource: "helmreleases"},
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2beta2", Resource: "helmreleases"},
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2beta1", Resource: "helmreleases"},
+	}
+)
+
+// backendRelease is the release that installs the Run:AI backend. The node affinity is set in the
+// global.nodeAffinity value of the release
+type backendRelease interface {
+	String() string
+	// setNodeAffinity replaces the global.nodeAffinity value with the one returned by newNodeAffinity, and returns
+	// the value it replaced and whether the release was updated
+	setNodeAffinity(client *client.Client, newNodeAffinity func(map[string]interface{}) map[string]interface{}) (map[string]interface{}, bool, error)
+}
+
+// detectBackendRelease finds the release of the Run:AI backend, which is either a Flux v2 HelmRelease,
+// a Flux v1 HelmRelease or a plain Helm 3 release
+func detectBackendRelease(client *client.Client) (backendRelease, error) {
+	for _, resource := range fluxV2HelmReleaseResources {
+		release, err := findFluxHelmRelease(client, resource, "Flux v2")
+		if err != nil || release != nil {
+			return release, err
+		}
+	}
+	release, err := findFluxHelmRelease(client, fluxV1HelmReleaseResource, "Flux v1")
+	if err != nil || release != nil {
+		return release, err
+	}
+
+	helmRelease, err := findHelmReleaseSecret(client)
+	if err != nil || helmRelease != nil {
+		return helmRelease, err
+	}
+	return nil, commandUtil.NotInstalled(fmt.Errorf("Failed to find the release of the Run:AI backend, Run:AI Backend is not installed on the cluster"))
+}
+
+// fluxHelmRelease is a HelmRelease of Flux v1 or Flux v2, both keep the values in spec.values
+type fluxHelmRelease struct {
+	resource  schema.GroupVersionResource
+	kind      string
+	namespace string
+	name      string
+}
+
+// findFluxHelmRelease returns the HelmRelease of the Run:AI backend, or nil when the resource or the release does
+// not exist. A Flux v2 HelmRelease may live in any namespace (e.g. flux-system), so the release is searched in all
+func findFluxHelmRelease(client *client.Client, resource schema.GroupVersionResource, kind string) (*fluxHelmRelease, error) {
+	releases, err := client.GetDynamicClient().Resource(resource).List(metav1.ListOptions{FieldSelector: "metadata.name=" + runaiBackendReleaseName})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list %s HelmReleases, error: %w", kind, err)
+	}
+
+	var release *fluxHelmRelease
+	for _, item := range releases.Items {
+		candidate := &fluxHelmRelease{resource: resource, kind: kind, namespace: item.GetNamespace(), name: item.GetName()}
+		if release == nil || item.GetNamespace() == common.RunaiBackendNamespace {
+			release = candidate
+		}
+	}
+	if release != nil {
+		log.Debugf("Found the Run:AI backend release: %v", release)
+	}
+	return release, nil
+}
+
+func (release *fluxHelmRelease) String() string {
+	return fmt.Sprintf("%s HelmRelease %s/%s", release.kind, release.namespace, release.name)
+}
+
+func (release *fluxHelmRelease) setNodeAffinity(client *client.Client, newNodeAffinity func(map[string]interface{}) map[string]interface{}) (map[string]interface{}, bool, error) {
+	var nodeAffinityMapOldValues map[string]interface{}
+	updated := false
+	err := retry.Mutation(fmt.Sprintf("update %v", release), func() error {
+		helmRelease, err := client.GetDynamicClient().Resource(release.resource).Namespace(release.namespace).Get(release.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		nodeAffinityMapOldValues, _, err = unstructured.NestedMap(helmRelease.Object, "spec", "values", "global", "nodeAffinity")
+		if err != nil {
+			return fmt.Errorf("Failed to get nodeAffinityMap from %v, error: %w", release, err)
+		}
+		log.Debugf("HelmRelease old values of nodeAffinityMap: %v", nodeAffinityMapOldValues)
+
+		nodeAffinityMap := newNodeAffinity(nodeAffinityMapOldValues)
+		if reflect.DeepEqual(nodeAffinityMap, nodeAffinityMapOldValues) {
+			return nil
+		}
+		log.Debugf("Updating HelmRelease with nodeAffinityMap: %v", nodeAffinityMap)
+		setOrRemoveNestedMap(helmRelease.Object, nodeAffinityMap, "spec", "values", "global", "nodeAffinity")
+		if _, err := client.GetDynamicClient().Resource(release.resource).Namespace(release.namespace).Update(helmRelease, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to update %v, error: %w", release, err)
+	}
+	return nodeAffinityMapOldValues, updated, nil
+}
+
+// helmReleaseSecret is a plain Helm 3 release, which is kept in a Secret named sh.helm.release.v1.<name>.v<revision>.
+// The Secret is owned by Helm and is never written, a change of the values is refused with the helm upgrade command
+// that makes it
+type helmReleaseSecret struct {
+	namespace  string
+	secretName string
+}
+
+func findHelmReleaseSecret(client *client.Client) (*helmReleaseSecret, error) {
+	secrets, err := client.GetClientset().CoreV1().Secrets(common.RunaiBackendNamespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("owner=helm,name=%s,status=deployed", runaiBackendReleaseName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list Helm releases in the %s namespace, error: %w", common.RunaiBackendNamespace, err)
+	}
+
+	var release *helmReleaseSecret
+	latestRevision := -1
+	for _, secret := range secrets.Items {
+		revision, err := strconv.Atoi(secret.Labels["version"])
+		if err != nil {
+			continue
+		}
+		if revision > latestRevision {
+			latestRevision = revision
+			release = &helmReleaseSecret{namespace: secret.Namespace, secretName: secret.Name}
+		}
+	}
+	if release != nil {
+		log.Debugf("Found the Run:AI backend release: %v", release)
+	}
+	return release, nil
+}
+
+func (release *helmReleaseSecret) String() string {
+	return fmt.Sprintf("Helm release %s/%s (Secret %s)", release.namespace, runaiBackendReleaseName, release.secretName)
+}
+
+// setNodeAffinity only reads the values of the deployed revision. When the node affinity has to change, it returns a
+// validation error with the helm upgrade command that changes it. The node roles flow refuses a Helm backend before it
+// changes any node, so this only guards the other users of a backendRelease
+func (release *helmReleaseSecret) setNodeAffinity(client *client.Client, newNodeAffinity func(map[string]interface{}) map[string]interface{}) (map[string]interface{}, bool, error) {
+	secret, err := client.GetClientset().CoreV1().Secrets(release.namespace).Get(release.secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to get %v, error: %w", release, err)
+	}
+	helmRelease, err := decodeHelmRelease(secret.Data["release"])
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to decode %v, error: %w", release, err)
+	}
+	nodeAffinityMapOldValues, _, err := unstructured.NestedMap(helmRelease, "config", "global", "nodeAffinity")
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to get nodeAffinityMap from %v, error: %w", release, err)
+	}
+	log.Debugf("Helm release old values of nodeAffinityMap: %v", nodeAffinityMapOldValues)
+
+	nodeAffinityMap := newNodeAffinity(nodeAffinityMapOldValues)
+	if reflect.DeepEqual(nodeAffinityMap, nodeAffinityMapOldValues) {
+		return nodeAffinityMapOldValues, false, nil
+	}
+	return nil, false, commandUtil.Validation(fmt.Errorf("The node affinity of the %v has to change, and runai-adm does not change the releases of Helm. Change it with Helm, then run the command again:\n  %s",
+		release, helmUpgradeCommand(release.namespace, helmRelease, nodeAffinityMapOldValues, nodeAffinityMap)))
+}
+
+// helmUpgradeCommand returns the helm upgrade command that changes the node affinity values of the release, e.g.
+// helm upgrade runai-backend <repository>/runai-backend --namespace runai-backend --version 1.0.0 --reuse-values
+// --set global.nodeAffinity.restrictRunaiSystem=true
+func helmUpgradeCommand(namespace string, helmRelease map[string]interface{}, oldValues, newValues map[string]interface{}) string {
+	chart, _, _ := unstructured.NestedString(helmRelease, "chart", "metadata", "name")
+	if chart == "" {
+		chart = "<chart>"
+	}
+	command := fmt.Sprintf("helm upgrade %s <repository>/%s --namespace %s", runaiBackendReleaseName, chart, namespace)
+	if version, _, _ := unstructured.NestedString(helmRelease, "chart", "metadata", "version"); version != "" {
+		command += fmt.Sprintf(" --version %s", version)
+	}
+	command += " --reuse-values"
+
+	var keys []string
+	for key := range newValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if oldValue, found := oldValues[key]; !found || !reflect.DeepEqual(oldValue, newValues[key]) {
+			command += fmt.Sprintf(" --set global.nodeAffinity.%s=%v", key, newValues[key])
+		}
+	}
+	return command
+}
+
+// decodeHelmRelease decodes the release of a Helm 3 Secret, which is gzipped JSON encoded in base64
+func decodeHelmRelease(data []byte) (map[string]interface{}, error) {
+	compressed, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers are kept as json.Number so that the values are printed as Helm has them
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	helmRelease := map[string]interface{}{}
+	if err := decoder.Decode(&helmRelease); err != nil {
+		return nil, err
+	}
+	return helmRelease, nil
+}
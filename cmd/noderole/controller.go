@@ -0,0 +1,370 @@
+package noderole
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/util/lock"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// restrictionsKey is queued to re-sync the RunaiConfig restrictions, e.g. after a node was deleted
+	restrictionsKey = ""
+
+	// lockCommand is the command that the admin lock describes while the controller holds it
+	lockCommand = "runai-adm controller node-roles"
+)
+
+type controllerOptions struct {
+	RulesFile      string
+	MetricsAddress string
+	ResyncPeriod   time.Duration
+	LabelExisting  bool
+	Taint          bool
+}
+
+type controllerMetrics struct {
+	registry           *prometheus.Registry
+	nodesLabeled       *prometheus.CounterVec
+	nodesExcluded      prometheus.Counter
+	runaiConfigUpdates *prometheus.CounterVec
+	errors             *prometheus.CounterVec
+	roleNodes          *prometheus.GaugeVec
+}
+
+// nodeRolesController sets roles on new nodes according to the auto rules, and keeps the node affinity
+// restrictions of the RunaiConfig enabled only while nodes with the matching roles exist
+type nodeRolesController struct {
+	client       *client.Client
+	rules        autoRolesRules
+	options      controllerOptions
+	startTime    time.Time
+	nodeLister   corelisters.NodeLister
+	queue        workqueue.RateLimitingInterface
+	metrics      *controllerMetrics
+	restrictions *nodeAffinityRestrictions
+	// tolerations is the RunaiConfig tolerations of the role taints, which are synced when --taint is set
+	tolerations       []v1.Toleration
+	tolerationsSynced bool
+	// excludedNodes is the nodes whose exclusion was already reported, so that the re-sync of an excluded node
+	// is not counted again. The queue is processed by a single worker, so the state is not locked
+	excludedNodes map[string]bool
+}
+
+// nodeAffinityRestrictions is whether nodes with roles that restrict the scheduling exist
+type nodeAffinityRestrictions struct {
+	RestrictScheduling  bool
+	RestrictRunaiSystem bool
+}
+
+func Controller() *cobra.Command {
+	options := controllerOptions{}
+	var command = &cobra.Command{
+		Use:     "node-roles",
+		Aliases: []string{"node-role"},
+		Short:   "Run a controller that sets roles on new nodes",
+		Long:    "Watch the nodes of the cluster and set the GPU Worker or CPU Worker role on new nodes according to the --auto rules of 'set node-role'. The RunaiConfig node affinity restrictions are enabled when the first node with a role joins the cluster and disabled when the last one leaves. Meant to run inside the cluster as a Deployment.",
+		Args:    cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rules, err := readAutoRolesRules(options.RulesFile)
+			if err != nil {
+				return err
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			if err := runNodeRolesController(client, rules, options); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&options.RulesFile, "rules", "", "Path of a .yaml file with the rules used to detect GPU nodes and excluded nodes.")
+	command.Flags().StringVar(&options.MetricsAddress, "metrics-address", ":8080", "Address to serve the Prometheus metrics on.")
+	command.Flags().DurationVar(&options.ResyncPeriod, "resync-period", 10*time.Minute, "Period of the full re-sync of the nodes.")
+	command.Flags().BoolVar(&options.LabelExisting, "label-existing", false, "Also set roles on nodes that existed before the controller started and have no role.")
+	command.Flags().BoolVar(&options.Taint, "taint", false, "Also add a NoSchedule taint that matches the role of each new node, and the tolerations of the role taints to the RunaiConfig.")
+	return command
+}
+
+func newControllerMetrics() *controllerMetrics {
+	metrics := &controllerMetrics{
+		registry: prometheus.NewRegistry(),
+		nodesLabeled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "runai_node_roles_controller_nodes_labeled_total",
+			Help: "Number of nodes the controller has set with a role.",
+		}, []string{"role"}),
+		nodesExcluded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "runai_node_roles_controller_nodes_excluded_total",
+			Help: "Number of new nodes the controller has not set with a role because the rules exclude them.",
+		}),
+		runaiConfigUpdates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "runai_node_roles_controller_runaiconfig_updates_total",
+			Help: "Number of updates of the RunaiConfig node affinity restrictions.",
+		}, []string{"restrictScheduling", "restrictRunaiSystem"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "runai_node_roles_controller_errors_total",
+			Help: "Number of failed controller actions.",
+		}, []string{"action"}),
+		roleNodes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "runai_node_roles_controller_role_nodes",
+			Help: "Number of nodes with each role.",
+		}, []string{"role"}),
+	}
+	metrics.registry.MustRegister(metrics.nodesLabeled, metrics.nodesExcluded, metrics.runaiConfigUpdates, metrics.errors, metrics.roleNodes)
+	return metrics
+}
+
+func runNodeRolesController(client *client.Client, rules autoRolesRules, options controllerOptions) error {
+	stopCh := make(chan struct{})
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-interrupts
+		log.Infof("Received %v, stopping the controller", sig)
+		close(stopCh)
+	}()
+
+	factory := informers.NewSharedInformerFactory(client.GetClientset(), options.ResyncPeriod)
+	nodeInformer := factory.Core().V1().Nodes()
+	controller := &nodeRolesController{
+		client:        client,
+		rules:         rules,
+		options:       options,
+		startTime:     time.Now(),
+		nodeLister:    nodeInformer.Lister(),
+		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "node-roles"),
+		metrics:       newControllerMetrics(),
+		excludedNodes: map[string]bool{},
+	}
+	defer controller.queue.ShutDown()
+
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueNode,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			controller.enqueueNode(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			controller.queue.Add(restrictionsKey)
+		},
+	})
+
+	go controller.serveMetrics(stopCh)
+	factory.Start(stopCh)
+	log.Info("Waiting for the nodes informer to sync")
+	if !cache.WaitForCacheSync(stopCh, nodeInformer.Informer().HasSynced) {
+		return fmt.Errorf("Failed to sync the nodes informer")
+	}
+
+	log.Info("Started the node roles controller")
+	go wait.Until(controller.runWorker, time.Second, stopCh)
+	<-stopCh
+	return nil
+}
+
+func (controller *nodeRolesController) serveMetrics(stopCh <-chan struct{}) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(controller.metrics.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	})
+	server := &http.Server{Addr: controller.options.MetricsAddress, Handler: mux}
+	go func() {
+		<-stopCh
+		server.Close()
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Errorf("Failed to serve metrics on: %v, error: %v", controller.options.MetricsAddress, err)
+	}
+}
+
+func (controller *nodeRolesController) enqueueNode(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Warnf("Failed to get the key of node, error: %v", err)
+		return
+	}
+	controller.queue.Add(key)
+}
+
+func (controller *nodeRolesController) runWorker() {
+	for controller.processNextItem() {
+	}
+}
+
+func (controller *nodeRolesController) processNextItem() bool {
+	key, quit := controller.queue.Get()
+	if quit {
+		return false
+	}
+	defer controller.queue.Done(key)
+
+	if err := controller.sync(key.(string)); err != nil {
+		log.Warnf("Failed to sync node roles of: %q, error: %v", key, err)
+		controller.queue.AddRateLimited(key)
+		return true
+	}
+	controller.queue.Forget(key)
+	return true
+}
+
+func (controller *nodeRolesController) sync(nodeName string) error {
+	if nodeName != restrictionsKey {
+		if err := controller.syncNode(nodeName); err != nil {
+			controller.metrics.errors.WithLabelValues("label-node").Inc()
+			return err
+		}
+	}
+	if err := controller.syncRestrictions(); err != nil {
+		controller.metrics.errors.WithLabelValues("update-runaiconfig").Inc()
+		return err
+	}
+	return nil
+}
+
+// syncNode sets a role on a new node that has none, nodes that already have a role are left as the admin set them
+func (controller *nodeRolesController) syncNode(nodeName string) error {
+	node, err := controller.nodeLister.Get(nodeName)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(getNodeRoles(*node).Labels) > 0 {
+		return nil
+	}
+	if !controller.options.LabelExisting && node.CreationTimestamp.Time.Before(controller.startTime) {
+		return nil
+	}
+
+	assignment := controller.rules.classify(*node)
+	if assignment.Role == "" {
+		if !controller.excludedNodes[node.Name] {
+			log.Infof("Node: %v is %s, not setting a role", node.Name, assignment.Reason)
+			controller.metrics.nodesExcluded.Inc()
+			controller.excludedNodes[node.Name] = true
+		}
+		return nil
+	}
+
+	flags := nodeRoleTypes{GpuWorker: assignment.Role == gpuWorkerRole, CpuWorker: assignment.Role == cpuWorkerRole, Taint: controller.options.Taint}
+	err = controller.withAdminLock(func() error {
+		return updateLabelsSingleNode(node.DeepCopy(), flags, controller.client, true)
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Set node: %v with role: %v (%s)", node.Name, assignment.Role, assignment.Reason)
+	controller.metrics.nodesLabeled.WithLabelValues(assignment.Role).Inc()
+	return nil
+}
+
+// syncRestrictions updates the RunaiConfig when the set of nodes with roles changes from empty to non-empty or back
+func (controller *nodeRolesController) syncRestrictions() error {
+	nodes, err := controller.nodeLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	nodesInCluster := map[string]v1.Node{}
+	for _, node := range nodes {
+		nodesInCluster[node.Name] = *node
+	}
+	for roleName, label := range roleNameToLabel {
+		count := 0
+		for _, node := range nodes {
+			if _, found := node.Labels[label]; found {
+				count++
+			}
+		}
+		controller.metrics.roleNodes.WithLabelValues(roleName).Set(float64(count))
+	}
+
+	state := &nodeRolesState{nodesInCluster: nodesInCluster}
+	state.updateRestrictions()
+	restrictions := &nodeAffinityRestrictions{
+		RestrictScheduling:  state.nodeWithRestrictSchedulingExist,
+		RestrictRunaiSystem: state.nodeWithRestrictRunaiSystemExist,
+	}
+	if controller.options.Taint {
+		if err := controller.syncTolerations(state.tolerations); err != nil {
+			return err
+		}
+	}
+	if controller.restrictions != nil && *controller.restrictions == *restrictions {
+		return nil
+	}
+
+	flags := nodeRoleTypes{CpuWorker: true, GpuWorker: true, RunaiSystemWorker: true}
+	var updated bool
+	err = controller.withAdminLock(func() error {
+		_, updated, err = updateRunaiConfigIfNeeded(controller.client, flags, restrictions.RestrictScheduling, restrictions.RestrictRunaiSystem)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if updated {
+		log.Infof("Updated RunaiConfig node affinity, restrictScheduling: %v, restrictRunaiSystem: %v", restrictions.RestrictScheduling, restrictions.RestrictRunaiSystem)
+		controller.metrics.runaiConfigUpdates.WithLabelValues(fmt.Sprint(restrictions.RestrictScheduling), fmt.Sprint(restrictions.RestrictRunaiSystem)).Inc()
+	}
+	controller.restrictions = restrictions
+	return nil
+}
+
+// syncTolerations updates the RunaiConfig tolerations of the role taints when the set of tainted roles changes, as
+// set node-role --taint does, so that the Run:AI pods are still scheduled on the nodes that the controller tainted
+func (controller *nodeRolesController) syncTolerations(tolerations []v1.Toleration) error {
+	if controller.tolerationsSynced && reflect.DeepEqual(controller.tolerations, tolerations) {
+		return nil
+	}
+
+	flags := nodeRoleTypes{Taint: true}
+	var updated bool
+	err := controller.withAdminLock(func() (err error) {
+		_, updated, err = updateRunaiConfigTolerationsIfNeeded(controller.client, flags, tolerations)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if updated {
+		log.Infof("Updated RunaiConfig tolerations of the role taints: %v", tolerations)
+	}
+	controller.tolerations = tolerations
+	controller.tolerationsSynced = true
+	return nil
+}
+
+// withAdminLock runs a change of a node or of the RunaiConfig while holding the admin lock, so that it does not
+// interleave with a runai-adm command of an admin. While a command holds the lock the change fails and the key is
+// queued again. When the lock is stolen the controller is stopped and restarted by its Deployment
+func (controller *nodeRolesController) withAdminLock(change func() error) error {
+	if err := lock.Acquire(controller.client, common.RunaiNamespace, lockCommand, false); err != nil {
+		return err
+	}
+	defer lock.Release()
+	return change()
+}
@@ -0,0 +1,203 @@
+package noderole
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	evictionRetryInterval = 5 * time.Second
+	podsReadyPollInterval = 5 * time.Second
+)
+
+type evictionOptions struct {
+	GracePeriodSeconds int
+	Timeout            time.Duration
+
+	// deadline bounds all the evictions and waits of a command together, it is set when the command starts them
+	deadline time.Time
+}
+
+func addEvictionFlags(command *cobra.Command, options *evictionOptions) {
+	command.Flags().IntVar(&options.GracePeriodSeconds, "grace-period", -1, "Period of time in seconds given to each evicted Run:AI pod to terminate gracefully. If negative, the default value specified in the pod will be used.")
+	command.Flags().DurationVar(&options.Timeout, "timeout", 5*time.Minute, "The length of time to wait for all the evictions and for the replacement pods to become ready, together.")
+}
+
+// withDeadline returns the options with a deadline of the timeout from now, which every eviction and wait that uses
+// them shares
+func (options evictionOptions) withDeadline() evictionOptions {
+	options.deadline = time.Now().Add(options.Timeout)
+	return options
+}
+
+// remaining returns the time that is left until the deadline. When the deadline has passed it returns a short timeout
+// rather than 0, which polls forever, so that an eviction or a wait still makes a single attempt
+func (options evictionOptions) remaining() time.Duration {
+	if options.deadline.IsZero() {
+		return options.Timeout
+	}
+	if remaining := time.Until(options.deadline); remaining > 0 {
+		return remaining
+	}
+	return time.Millisecond
+}
+
+// evictPod evicts the pod through the Eviction API so PodDisruptionBudgets are respected,
+// retrying while a budget blocks the eviction until the deadline of the options
+func evictPod(client *client.Client, pod v1.Pod, options evictionOptions) error {
+	eviction := &policyv1beta1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+		DeleteOptions: &metav1.DeleteOptions{},
+	}
+	if options.GracePeriodSeconds >= 0 {
+		gracePeriodSeconds := int64(options.GracePeriodSeconds)
+		eviction.DeleteOptions.GracePeriodSeconds = &gracePeriodSeconds
+	}
+
+	err := wait.PollImmediate(evictionRetryInterval, options.remaining(), func() (bool, error) {
+		err := client.GetClientset().PolicyV1beta1().Evictions(pod.Namespace).Evict(eviction)
+		switch {
+		case err == nil:
+			log.Debugf("Evicted Run:AI pod: %v", pod.Name)
+			return true, nil
+		case errors.IsNotFound(err):
+			return true, nil
+		case errors.IsTooManyRequests(err):
+			log.Debugf("Eviction of pod %v is blocked by a PodDisruptionBudget, retrying in %v", pod.Name, evictionRetryInterval)
+			return false, nil
+		default:
+			return false, err
+		}
+	})
+	if err == wait.ErrWaitTimeout {
+		return commandUtil.Timeout(fmt.Errorf("the eviction is still blocked by a PodDisruptionBudget when the timeout of %v expired", options.Timeout))
+	}
+	return err
+}
+
+// evictionFailuresError returns an error of the pods that failed to be evicted, by pod name, with the exit code of the
+// failure of the first pod, e.g. a timeout when a PodDisruptionBudget blocked its eviction
+func evictionFailuresError(failures map[string]error, namespace string) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	var names []string
+	for name := range failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("Failed to evict %d Run:AI pods in the %s namespace from nodes without their role: %s, error: %w", len(names), namespace, strings.Join(names, ", "), failures[names[0]])
+}
+
+// waitForPodsOnRoleNodes waits for the Run:AI pods that are restricted by a node role to become
+// ready on nodes which satisfy their affinity, and returns a timeout error of the pods that stayed pending
+func waitForPodsOnRoleNodes(client *client.Client, flags nodeRoleTypes, nodesInCluster map[string]v1.Node, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist bool, namespace string, options evictionOptions) error {
+	if !flags.RunaiSystemWorker && !flags.CpuWorker && !flags.GpuWorker {
+		return nil
+	}
+	log.Infof("Waiting for Run:AI pods in the %s namespace to be ready on their nodes", namespace)
+
+	var notReadyPods []v1.Pod
+	err := wait.PollImmediate(podsReadyPollInterval, options.remaining(), func() (bool, error) {
+		pods, err := client.GetClientset().CoreV1().Pods(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			log.Debugf("Failed to list pods in the %s namespace, error: %v", namespace, err)
+			return false, nil
+		}
+
+		notReadyPods = nil
+		for _, pod := range pods.Items {
+			if pod.DeletionTimestamp != nil || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+				continue
+			}
+			requiredLabels := requiredRoleLabels(pod, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist)
+			if len(requiredLabels) == 0 {
+				continue
+			}
+			if !isPodReady(pod) || !nodeHasLabels(nodesInCluster[pod.Spec.NodeName], requiredLabels) {
+				notReadyPods = append(notReadyPods, pod)
+			}
+		}
+		return len(notReadyPods) == 0, nil
+	})
+
+	if err == nil {
+		log.Infof("All Run:AI pods in the %s namespace are ready", namespace)
+		return nil
+	}
+
+	var names []string
+	for _, pod := range notReadyPods {
+		log.Warnf("Pod %v is still %v when the timeout of %v expired%v", pod.Name, pod.Status.Phase, options.Timeout, podPendingReason(pod))
+		names = append(names, pod.Name)
+	}
+	if len(names) == 0 {
+		return commandUtil.Timeout(fmt.Errorf("Failed to list the Run:AI pods in the %s namespace before the timeout of %v expired", namespace, options.Timeout))
+	}
+	return commandUtil.Timeout(fmt.Errorf("%d Run:AI pods in the %s namespace are not ready on nodes with their role after %v: %s", len(names), namespace, options.Timeout, strings.Join(names, ", ")))
+}
+
+func requiredRoleLabels(pod v1.Pod, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist bool) []string {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return nil
+	}
+
+	var labels []string
+	for _, nodeSelectorTerms := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, matchExpressions := range nodeSelectorTerms.MatchExpressions {
+			switch matchExpressions.Key {
+			case systemWorkerLabel:
+				if nodeWithRestrictRunaiSystemExist {
+					labels = append(labels, matchExpressions.Key)
+				}
+			case cpuWorkerLabel, gpuWorkerLabel:
+				if nodeWithRestrictSchedulingExist {
+					labels = append(labels, matchExpressions.Key)
+				}
+			}
+		}
+	}
+	return labels
+}
+
+func nodeHasLabels(node v1.Node, labels []string) bool {
+	for _, label := range labels {
+		if _, found := node.Labels[label]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+func isPodReady(pod v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func podPendingReason(pod v1.Pod) string {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse && condition.Message != "" {
+			return fmt.Sprintf(": %s", condition.Message)
+		}
+	}
+	return ""
+}
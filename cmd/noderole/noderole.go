@@ -2,15 +2,18 @@ package noderole
 
 import (
 	"fmt"
-	"os"
 	"reflect"
 
 	"github.com/run-ai/runai-cli/cmd/common"
 	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/patch"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	"github.com/run-ai/runai-cli/pkg/util/transaction"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -21,6 +24,14 @@ type nodeRoleTypes struct {
 	AllNodes          bool
 	GpuWorker         bool
 	RunaiSystemWorker bool
+	Taint             bool
+	Parallelism       int
+}
+
+// nodeRoles is the role labels and role taints of a node
+type nodeRoles struct {
+	Labels map[string]string
+	Taints []v1.Taint
 }
 
 const (
@@ -29,241 +40,514 @@ const (
 	systemWorkerLabel = "node-role.kubernetes.io/runai-system"
 )
 
+var (
+	allRoleLabels = []string{gpuWorkerLabel, cpuWorkerLabel, systemWorkerLabel}
+
+	nodesResource = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+)
+
 func Set() *cobra.Command {
 	flags := nodeRoleTypes{}
+	evictionOptions := evictionOptions{}
+	safetyOptions := safetyOptions{}
 	withBackend := false
+	auto := false
+	rulesFile := ""
+	dryRun := false
 	var command = &cobra.Command{
 		Use:     "node-role NODE_NAME",
 		Aliases: []string{"node-roles"},
 		Short:   "Set node with roles",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateParallelism(flags.Parallelism); err != nil {
+				return err
+			}
+			if auto {
+				if flags.AllNodes || flags.CpuWorker || flags.GpuWorker || flags.RunaiSystemWorker {
+					return commandUtil.Validationf("--auto cannot be used together with --all or the role flags")
+				}
+				client, err := client.GetClient()
+				if err != nil {
+					return err
+				}
+				if err := setAutoNodeRoles(client, args, rulesFile, dryRun, flags.Taint, flags.Parallelism, withBackend, evictionOptions, safetyOptions); err != nil {
+					return err
+				}
+				if !dryRun {
+					log.Info("Successfully updated nodes and set configurations")
+				}
+				return nil
+			}
 			if len(args) == 0 && !flags.AllNodes {
-				fmt.Println("No nodes were selected")
 				cmd.HelpFunc()(cmd, args)
-				os.Exit(1)
+				return commandUtil.Validationf("No nodes were selected")
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			if err := checkNodeRolesSafety(client, flags, args, true, safetyOptions); err != nil {
+				return err
+			}
+			if err := updateNodeRoles(client, flags, args, true, withBackend, evictionOptions); err != nil {
+				return err
 			}
-			client := client.GetClient()
-			nodesInCluster := labelNodesWithRolesAndGetNodesInCluster(client, flags, args, true)
-			updateRunaiConfigurations(client, flags, nodesInCluster, withBackend)
 
 			log.Info("Successfully updated nodes and set configurations")
+			return nil
 		},
 	}
 
-	command.Flags().BoolVar(&withBackend, "with-backend", false, "Update backend pods (In Air-gapped environment)")
+	command.Flags().BoolVar(&withBackend, "with-backend", false, "Update backend pods (In Air-gapped environment). The backend must be installed by a Flux HelmRelease, a plain Helm release is refused before any node is changed.")
 	command.Flags().BoolVar(&flags.AllNodes, "all", false, "Set all nodes.")
 	command.Flags().BoolVar(&flags.CpuWorker, "cpu-worker", false, "Set nodes with node-role of CPU Worker.")
 	command.Flags().BoolVar(&flags.GpuWorker, "gpu-worker", false, "Set nodes with node-role of GPU Worker.")
 	command.Flags().BoolVar(&flags.RunaiSystemWorker, "runai-system-worker", false, "Set nodes with node-role of Run:AI System Worker.")
+	command.Flags().BoolVar(&flags.Taint, "taint", false, "Also add a NoSchedule taint that matches each role, so only Run:AI workloads are scheduled on the nodes.")
+	command.Flags().BoolVar(&auto, "auto", false, "Set the GPU Worker and CPU Worker roles of the given nodes, or of all nodes, by detecting their GPUs. Control-plane nodes are excluded.")
+	command.Flags().StringVar(&rulesFile, "rules", "", "Path of a .yaml file with the rules that --auto uses to detect GPU nodes and excluded nodes.")
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "Only print the roles proposed by --auto, without applying them.")
+	addParallelismFlag(command, &flags.Parallelism)
+	addEvictionFlags(command, &evictionOptions)
+	addSafetyFlags(command, &safetyOptions)
 	return command
 }
 
-func deletePodsIfNeeded(flags nodeRoleTypes, client *client.Client, nodesInCluster map[string]v1.Node, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist bool, namespace string) {
+func evictPodsIfNeeded(flags nodeRoleTypes, client *client.Client, nodesInCluster map[string]v1.Node, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist bool, namespace string, options evictionOptions) error {
 	if !flags.RunaiSystemWorker && !flags.CpuWorker && !flags.GpuWorker {
-		return
+		return nil
 	}
 	runaiPods, err := client.GetClientset().CoreV1().Pods(namespace).List(metav1.ListOptions{})
 	if err != nil {
-		fmt.Println("Failed to list pods from the runai namespace")
-		os.Exit(1)
+		return fmt.Errorf("Failed to list pods from the %s namespace, error: %w", namespace, err)
 	}
 
+	failures := map[string]error{}
 	for _, pod := range runaiPods.Items {
-		deletePodIfNeeded(pod, nodesInCluster, client, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist, namespace)
+		if err := evictPodIfNeeded(pod, nodesInCluster, client, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist, options); err != nil {
+			failures[pod.Name] = err
+		}
 	}
+	return evictionFailuresError(failures, namespace)
 }
 
-func deletePodIfNeeded(pod v1.Pod, nodesInCluster map[string]v1.Node, client *client.Client, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist bool, namespace string) {
+func evictPodIfNeeded(pod v1.Pod, nodesInCluster map[string]v1.Node, client *client.Client, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist bool, options evictionOptions) error {
 	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms == nil {
-		return
+		return nil
 	}
 	for _, nodeSelectorTerms := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
 		for _, matchExpressions := range nodeSelectorTerms.MatchExpressions {
-			if nodeWithRestrictRunaiSystemExist && checkIfLabelIsNotStatisfiedAndDeleteIfNeeded(pod, nodesInCluster, client, matchExpressions, systemWorkerLabel, namespace) {
-				return
+			if nodeWithRestrictRunaiSystemExist {
+				if satisfied, err := checkIfLabelIsNotStatisfiedAndEvictIfNeeded(pod, nodesInCluster, client, matchExpressions, systemWorkerLabel, options); satisfied || err != nil {
+					return err
+				}
 			}
-			if nodeWithRestrictSchedulingExist && checkIfLabelIsNotStatisfiedAndDeleteIfNeeded(pod, nodesInCluster, client, matchExpressions, cpuWorkerLabel, namespace) {
-				return
-			}
-			if nodeWithRestrictSchedulingExist && checkIfLabelIsNotStatisfiedAndDeleteIfNeeded(pod, nodesInCluster, client, matchExpressions, gpuWorkerLabel, namespace) {
-				return
+			if nodeWithRestrictSchedulingExist {
+				if satisfied, err := checkIfLabelIsNotStatisfiedAndEvictIfNeeded(pod, nodesInCluster, client, matchExpressions, cpuWorkerLabel, options); satisfied || err != nil {
+					return err
+				}
+				if satisfied, err := checkIfLabelIsNotStatisfiedAndEvictIfNeeded(pod, nodesInCluster, client, matchExpressions, gpuWorkerLabel, options); satisfied || err != nil {
+					return err
+				}
 			}
 		}
 	}
+	return nil
 }
 
-func checkIfLabelIsNotStatisfiedAndDeleteIfNeeded(pod v1.Pod, nodesInCluster map[string]v1.Node, client *client.Client, matchExpressions v1.NodeSelectorRequirement, labelToCheck, namespace string) bool {
+// checkIfLabelIsNotStatisfiedAndEvictIfNeeded returns whether the pod is placed according to the label, and evicts
+// it when it runs on a node without the label. It returns the error of a failed eviction
+func checkIfLabelIsNotStatisfiedAndEvictIfNeeded(pod v1.Pod, nodesInCluster map[string]v1.Node, client *client.Client, matchExpressions v1.NodeSelectorRequirement, labelToCheck string, options evictionOptions) (bool, error) {
 	if matchExpressions.Key == labelToCheck {
 		if len(pod.Spec.NodeName) == 0 {
-			return true
+			return true, nil
 		}
 		_, found := nodesInCluster[pod.Spec.NodeName].Labels[labelToCheck]
 		if found {
-			return true
+			return true, nil
+		}
+		if err := evictPod(client, pod, options); err != nil {
+			log.Warnf("Failed to evict Run:AI pod: %v, error: %v", pod.Name, err)
+			return false, err
 		}
-		client.GetClientset().CoreV1().Pods(namespace).Delete(pod.Name, &metav1.DeleteOptions{})
-		log.Debugf("Deleted Run:AI pod: %v", pod.Name)
 	}
-	return false
+	return false, nil
 }
 
-func deleteResourcesIfNeeded(flags nodeRoleTypes, client *client.Client, nodesInCluster map[string]v1.Node, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist, deleteStsAndPvc bool, namespace string) {
+func deleteResourcesIfNeeded(flags nodeRoleTypes, client *client.Client, nodesInCluster map[string]v1.Node, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist, deleteStsAndPvc bool, namespace string, options evictionOptions) error {
 	log.Info("Deleting old Run:AI resources")
 	if deleteStsAndPvc {
-		deletePVCAndStsIfNeeded(flags, client, nodesInCluster, nodeWithRestrictRunaiSystemExist, namespace)
+		if err := deletePVCAndStsIfNeeded(flags, client, nodesInCluster, nodeWithRestrictRunaiSystemExist, namespace); err != nil {
+			return err
+		}
+	}
+	if err := deleteJobsIfNeeded(client, namespace); err != nil {
+		return err
 	}
-	deleteJobsIfNeeded(client, namespace)
-	deletePodsIfNeeded(flags, client, nodesInCluster, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist, namespace)
+	return evictPodsIfNeeded(flags, client, nodesInCluster, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist, namespace, options)
 }
 
-func deleteJobsIfNeeded(client *client.Client, namespace string) {
+func deleteJobsIfNeeded(client *client.Client, namespace string) error {
 	jobs, err := client.GetClientset().BatchV1().Jobs(namespace).List(metav1.ListOptions{})
 	if err != nil {
-		fmt.Printf("Failed to list jobs, error: %v", err)
-		os.Exit(1)
+		return fmt.Errorf("Failed to list jobs, error: %w", err)
 	}
+	failed := 0
 	for _, job := range jobs.Items {
-		client.GetClientset().BatchV1().Jobs(namespace).Delete(job.Name, &metav1.DeleteOptions{})
+		err := retry.Deletion(fmt.Sprintf("delete job %v", job.Name), func() error {
+			return client.GetClientset().BatchV1().Jobs(namespace).Delete(job.Name, &metav1.DeleteOptions{})
+		})
+		if err != nil {
+			log.Warnf("Failed to delete job: %v, error: %v", job.Name, err)
+			failed++
+			continue
+		}
 		log.Debugf("Deleted Job: %v", job.Name)
 	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to delete %d of %d jobs in the %s namespace", failed, len(jobs.Items), namespace)
+	}
+	return nil
 }
 
-func deletePVCAndStsIfNeeded(flags nodeRoleTypes, client *client.Client, nodesInCluster map[string]v1.Node, nodeWithRestrictRunaiSystemExist bool, namespace string) {
+func deletePVCAndStsIfNeeded(flags nodeRoleTypes, client *client.Client, nodesInCluster map[string]v1.Node, nodeWithRestrictRunaiSystemExist bool, namespace string) error {
 	if !flags.RunaiSystemWorker || !nodeWithRestrictRunaiSystemExist {
-		return
+		return nil
 	}
 
 	pvc, err := client.GetClientset().CoreV1().PersistentVolumeClaims(namespace).Get("data-runai-db-0", metav1.GetOptions{})
-	pvcNode, found := pvc.Annotations["volume.kubernetes.io/selected-node"]
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("Failed to get PVC data-runai-db-0, error: %w", err)
+	}
+	pvcNode, found := "", false
+	if err == nil {
+		pvcNode, found = pvc.Annotations["volume.kubernetes.io/selected-node"]
+	}
 	if found {
 		nodeInfo, found := nodesInCluster[pvcNode]
 		if !found {
-			fmt.Printf("Failed to find PVC node in cluster, node: %v\n", pvcNode)
-			os.Exit(1)
+			return fmt.Errorf("Failed to find PVC node in cluster, node: %v", pvcNode)
 		}
 
 		if _, found := nodeInfo.Labels[systemWorkerLabel]; found { // no need to delete the pvc - already on a system node
-			return
+			return nil
 		}
 
-		client.GetClientset().CoreV1().PersistentVolumeClaims(namespace).Delete("data-runai-db-0", &metav1.DeleteOptions{})
+		err := retry.Deletion("delete PVC data-runai-db-0", func() error {
+			return client.GetClientset().CoreV1().PersistentVolumeClaims(namespace).Delete("data-runai-db-0", &metav1.DeleteOptions{})
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to delete PVC data-runai-db-0, error: %w", err)
+		}
 		log.Debugf("Deleted PVC data-runai-db-0")
 	}
 
 	stsList, err := client.GetClientset().AppsV1().StatefulSets(namespace).List(metav1.ListOptions{})
 	if err != nil {
-		log.Debugf("Failed to list statefulsets in the %s namespace", namespace)
-		return
+		return fmt.Errorf("Failed to list statefulsets in the %s namespace, error: %w", namespace, err)
 	}
 
+	failed := 0
 	for _, sts := range stsList.Items {
-		client.GetClientset().AppsV1().StatefulSets(namespace).Delete(sts.Name, &metav1.DeleteOptions{})
+		err := retry.Deletion(fmt.Sprintf("delete statefulset %v", sts.Name), func() error {
+			return client.GetClientset().AppsV1().StatefulSets(namespace).Delete(sts.Name, &metav1.DeleteOptions{})
+		})
+		if err != nil {
+			log.Warnf("Failed to delete statefulset: %v, error: %v", sts.Name, err)
+			failed++
+			continue
+		}
 		log.Debugf("Deleted Statefulset: %v", sts.Name)
 	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to delete %d of %d statefulsets in the %s namespace", failed, len(stsList.Items), namespace)
+	}
+	return nil
+}
+
+// nodeRolesState is shared between the steps of a node roles flow
+type nodeRolesState struct {
+	nodesInCluster                   map[string]v1.Node
+	originalNodeRoles                map[string]nodeRoles
+	tolerations                      []v1.Toleration
+	nodeWithRestrictSchedulingExist  bool
+	nodeWithRestrictRunaiSystemExist bool
 }
 
-func updateRunaiConfigurations(client *client.Client, flags nodeRoleTypes, nodesInCluster map[string]v1.Node, withBackend bool) {
-	log.Info("Updating Run:AI configurations")
-	nodeWithRestrictSchedulingExist := false
-	nodeWithRestrictRunaiSystemExist := false
-	for _, nodeInfo := range nodesInCluster {
+func (state *nodeRolesState) updateRestrictions() {
+	state.nodeWithRestrictSchedulingExist = false
+	state.nodeWithRestrictRunaiSystemExist = false
+	for _, nodeInfo := range state.nodesInCluster {
 		_, foundCpu := nodeInfo.Labels[cpuWorkerLabel]
 		_, foundGpu := nodeInfo.Labels[gpuWorkerLabel]
 		_, foundSystem := nodeInfo.Labels[systemWorkerLabel]
 		if foundCpu || foundGpu {
-			nodeWithRestrictSchedulingExist = foundCpu || foundGpu
+			state.nodeWithRestrictSchedulingExist = foundCpu || foundGpu
 		}
 		if foundSystem {
-			nodeWithRestrictRunaiSystemExist = foundSystem
+			state.nodeWithRestrictRunaiSystemExist = foundSystem
 		}
 	}
-	log.Debugf("Nodes with cpu or gpu workers already exist: %v", nodeWithRestrictSchedulingExist)
-	log.Debugf("Nodes with runai system workers already exist: %v", nodeWithRestrictRunaiSystemExist)
-	common.ScaleRunaiOperator(client, 0)
-	updateDeploymentWithAffinity(client, flags, common.RunaiNamespace, common.RunaiOperatorDeploymentName, nodeWithRestrictRunaiSystemExist)
-	updateRunaiConfigIfNeeded(client, flags, nodeWithRestrictSchedulingExist, nodeWithRestrictRunaiSystemExist)
-	deleteResourcesIfNeeded(flags, client, nodesInCluster, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist, true, common.RunaiNamespace)
-	common.ScaleRunaiOperator(client, 1)
+	log.Debugf("Nodes with cpu or gpu workers already exist: %v", state.nodeWithRestrictSchedulingExist)
+	log.Debugf("Nodes with runai system workers already exist: %v", state.nodeWithRestrictRunaiSystemExist)
+	state.tolerations = roleTolerations(state.nodesInCluster)
+}
+
+// updateNodeRoles labels the nodes and updates the Run:AI configurations as a single flow,
+// which rolls back the nodes labels and restores the operators on failure or interrupt
+func updateNodeRoles(client *client.Client, flags nodeRoleTypes, args []string, shouldEnableLabel, withBackend bool, options evictionOptions) error {
+	return runNodeRolesFlow(client, flags, withBackend, options, func(originalNodeRoles map[string]nodeRoles) (map[string]v1.Node, error) {
+		return labelNodesWithRolesAndGetNodesInCluster(client, flags, args, shouldEnableLabel, originalNodeRoles)
+	})
+}
 
+// runNodeRolesFlow runs labelNodes, which records the original roles of every node it updates and returns
+// all the nodes in the cluster, followed by the update of the Run:AI configurations for the given roles. The timeout
+// of the options bounds all the evictions of the flow and the wait for the pods that follows it
+func runNodeRolesFlow(client *client.Client, flags nodeRoleTypes, withBackend bool, options evictionOptions, labelNodes func(originalNodeRoles map[string]nodeRoles) (map[string]v1.Node, error)) error {
+	var release backendRelease
 	if withBackend {
-		common.ScaleRunaiBackendOperator(client, 0)
-		updateDeploymentWithAffinity(client, flags, common.RunaiBackendNamespace, common.RunaiBackendOperatorDeploymentName, nodeWithRestrictRunaiSystemExist)
-		updateHelmReleaseIfNeeded(client, flags, nodeWithRestrictRunaiSystemExist)
-		deleteResourcesIfNeeded(flags, client, nodesInCluster, nodeWithRestrictRunaiSystemExist, nodeWithRestrictSchedulingExist, false, common.RunaiBackendNamespace)
-		common.ScaleRunaiBackendOperator(client, 1)
+		var err error
+		if release, err = detectBackendRelease(client); err != nil {
+			return err
+		}
+		// a plain Helm release is not changed by runai-adm, so it is refused before any node is changed
+		if _, isHelmRelease := release.(*helmReleaseSecret); isHelmRelease {
+			return commandUtil.Validationf("The Run:AI backend is installed by the %v, and runai-adm does not change the releases of Helm. Run the command without --with-backend, then change the global.nodeAffinity values of the release with helm upgrade", release)
+		}
+	}
+
+	options = options.withDeadline()
+	state := &nodeRolesState{originalNodeRoles: map[string]nodeRoles{}}
+	steps := []transaction.Step{
+		{
+			Name: "Update nodes with roles",
+			Do: func() error {
+				nodesInCluster, err := labelNodes(state.originalNodeRoles)
+				if err != nil {
+					return err
+				}
+				state.nodesInCluster = nodesInCluster
+				state.updateRestrictions()
+				return nil
+			},
+			Rollback: func() error {
+				return restoreNodesRoles(client, state.originalNodeRoles)
+			},
+		},
+	}
+	steps = append(steps, runaiConfigurationsSteps(client, flags, state, release, options)...)
+
+	if err := transaction.Run(steps); err != nil {
+		return err
+	}
+
+	if err := waitForPodsOnRoleNodes(client, flags, state.nodesInCluster, state.nodeWithRestrictRunaiSystemExist, state.nodeWithRestrictSchedulingExist, common.RunaiNamespace, options); err != nil {
+		return err
+	}
+	if withBackend {
+		return waitForPodsOnRoleNodes(client, flags, state.nodesInCluster, state.nodeWithRestrictRunaiSystemExist, state.nodeWithRestrictSchedulingExist, common.RunaiBackendNamespace, options)
 	}
+	return nil
 }
 
-func updateDeploymentWithAffinity(client *client.Client, flags nodeRoleTypes, namespace, deploymentName string, nodeWithRestrictRunaiSystemExist bool) {
-	if !flags.RunaiSystemWorker {
-		return
+func runaiConfigurationsSteps(client *client.Client, flags nodeRoleTypes, state *nodeRolesState, release backendRelease, options evictionOptions) []transaction.Step {
+	var previousOperatorScheduling, previousBackendOperatorScheduling podScheduling
+	var operatorAffinityUpdated, backendOperatorAffinityUpdated bool
+	var previousRunaiConfigAffinity, previousBackendReleaseAffinity map[string]interface{}
+	var runaiConfigUpdated, backendReleaseUpdated bool
+	var previousRunaiConfigTolerations []interface{}
+	var runaiConfigTolerationsUpdated bool
+
+	steps := []transaction.Step{
+		{
+			Name:     "Scale down the Run:AI operator",
+			Do:       func() error { return common.ScaleDownRunaiOperator(client) },
+			Rollback: func() error { return common.RestoreRunaiOperator(client) },
+		},
+		{
+			Name: "Update the Run:AI operator node affinity",
+			Do: func() (err error) {
+				previousOperatorScheduling, operatorAffinityUpdated, err = updateDeploymentWithAffinity(client, flags, common.RunaiNamespace, common.RunaiOperatorDeploymentName, state.nodeWithRestrictRunaiSystemExist, state.tolerations)
+				return err
+			},
+			Rollback: func() error {
+				if !operatorAffinityUpdated {
+					return nil
+				}
+				_, err := setDeploymentScheduling(client, common.RunaiNamespace, common.RunaiOperatorDeploymentName, func(podScheduling) podScheduling { return previousOperatorScheduling })
+				return err
+			},
+		},
+		{
+			Name: "Update the RunaiConfig node affinity",
+			Do: func() (err error) {
+				previousRunaiConfigAffinity, runaiConfigUpdated, err = updateRunaiConfigIfNeeded(client, flags, state.nodeWithRestrictSchedulingExist, state.nodeWithRestrictRunaiSystemExist)
+				return err
+			},
+			Rollback: func() error {
+				if !runaiConfigUpdated {
+					return nil
+				}
+				_, _, err := setRunaiConfigNodeAffinity(client, func(map[string]interface{}) map[string]interface{} { return previousRunaiConfigAffinity })
+				return err
+			},
+		},
+		{
+			Name: "Update the RunaiConfig tolerations",
+			Do: func() (err error) {
+				previousRunaiConfigTolerations, runaiConfigTolerationsUpdated, err = updateRunaiConfigTolerationsIfNeeded(client, flags, state.tolerations)
+				return err
+			},
+			Rollback: func() error {
+				if !runaiConfigTolerationsUpdated {
+					return nil
+				}
+				return restoreRunaiConfigTolerations(client, previousRunaiConfigTolerations)
+			},
+		},
+		{
+			Name: "Delete old Run:AI resources",
+			Do: func() error {
+				return deleteResourcesIfNeeded(flags, client, state.nodesInCluster, state.nodeWithRestrictRunaiSystemExist, state.nodeWithRestrictSchedulingExist, true, common.RunaiNamespace, options)
+			},
+		},
+		{
+			Name: "Scale up the Run:AI operator",
+			Do:   func() error { return common.RestoreRunaiOperator(client) },
+		},
+	}
+
+	if release == nil {
+		return steps
 	}
 
-	var err error
-	var deployment *appsv1.Deployment
+	return append(steps, []transaction.Step{
+		{
+			Name:     "Scale down the Run:AI backend operator",
+			Do:       func() error { return common.ScaleDownRunaiBackendOperator(client) },
+			Rollback: func() error { return common.RestoreRunaiBackendOperator(client) },
+		},
+		{
+			Name: "Update the Run:AI backend operator node affinity",
+			Do: func() (err error) {
+				previousBackendOperatorScheduling, backendOperatorAffinityUpdated, err = updateDeploymentWithAffinity(client, flags, common.RunaiBackendNamespace, common.RunaiBackendOperatorDeploymentName, state.nodeWithRestrictRunaiSystemExist, state.tolerations)
+				return err
+			},
+			Rollback: func() error {
+				if !backendOperatorAffinityUpdated {
+					return nil
+				}
+				_, err := setDeploymentScheduling(client, common.RunaiBackendNamespace, common.RunaiBackendOperatorDeploymentName, func(podScheduling) podScheduling { return previousBackendOperatorScheduling })
+				return err
+			},
+		},
+		{
+			Name: "Update the Run:AI backend release node affinity",
+			Do: func() (err error) {
+				log.Infof("Updating the node affinity of the Run:AI backend %v", release)
+				previousBackendReleaseAffinity, backendReleaseUpdated, err = updateBackendReleaseIfNeeded(client, release, flags, state.nodeWithRestrictRunaiSystemExist)
+				return err
+			},
+			Rollback: func() error {
+				if !backendReleaseUpdated {
+					return nil
+				}
+				_, _, err := release.setNodeAffinity(client, func(map[string]interface{}) map[string]interface{} { return previousBackendReleaseAffinity })
+				return err
+			},
+		},
+		{
+			Name: "Delete old Run:AI backend resources",
+			Do: func() error {
+				return deleteResourcesIfNeeded(flags, client, state.nodesInCluster, state.nodeWithRestrictRunaiSystemExist, state.nodeWithRestrictSchedulingExist, false, common.RunaiBackendNamespace, options)
+			},
+		},
+		{
+			Name: "Scale up the Run:AI backend operator",
+			Do:   func() error { return common.RestoreRunaiBackendOperator(client) },
+		},
+	}...)
+}
 
-	for i := 0; i < common.NumberOfRetiresForApiServer; i++ {
-		deployment, err = client.GetClientset().AppsV1().Deployments(namespace).Get(deploymentName, metav1.GetOptions{})
-		if err != nil {
-			log.Infof("Failed to get runai-operator, error: %v", err)
-			os.Exit(1)
-		}
-		if nodeWithRestrictRunaiSystemExist {
-			deployment.Spec.Template.Spec.Affinity = &v1.Affinity{
-				NodeAffinity: &v1.NodeAffinity{
-					RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
-						NodeSelectorTerms: []v1.NodeSelectorTerm{
-							{
-								MatchExpressions: []v1.NodeSelectorRequirement{
-									{
-										Key:      systemWorkerLabel,
-										Operator: v1.NodeSelectorOpExists,
-									},
+// podScheduling is the scheduling constraints of the pods of a deployment
+type podScheduling struct {
+	Affinity    *v1.Affinity
+	Tolerations []v1.Toleration
+}
+
+func updateDeploymentWithAffinity(client *client.Client, flags nodeRoleTypes, namespace, deploymentName string, nodeWithRestrictRunaiSystemExist bool, tolerations []v1.Toleration) (podScheduling, bool, error) {
+	if !flags.RunaiSystemWorker && !flags.Taint {
+		return podScheduling{}, false, nil
+	}
+
+	var affinity *v1.Affinity
+	if nodeWithRestrictRunaiSystemExist {
+		affinity = &v1.Affinity{
+			NodeAffinity: &v1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+					NodeSelectorTerms: []v1.NodeSelectorTerm{
+						{
+							MatchExpressions: []v1.NodeSelectorRequirement{
+								{
+									Key:      systemWorkerLabel,
+									Operator: v1.NodeSelectorOpExists,
 								},
 							},
 						},
 					},
 				},
-			}
-		} else {
-			deployment.Spec.Template.Spec.Affinity = nil
+			},
 		}
-		_, err = client.GetClientset().AppsV1().Deployments(namespace).Update(deployment)
-		if err != nil {
-			log.Debugf("Failed to update the %s, attempt: %v error: %v", deploymentName, i, err)
-			continue
-		}
-		break
 	}
+
+	previousScheduling, err := setDeploymentScheduling(client, namespace, deploymentName, func(current podScheduling) podScheduling {
+		if flags.RunaiSystemWorker {
+			current.Affinity = affinity
+		}
+		if flags.Taint {
+			current.Tolerations = mergeRoleTolerations(current.Tolerations, tolerations)
+		}
+		return current
+	})
 	if err != nil {
-		log.Infof("Failed to update the %s, error: %v", deploymentName, err)
-		os.Exit(1)
+		return podScheduling{}, false, err
 	}
-
 	log.Debugf("Updated %s to have node affinity and scaled to 0 replicas", deploymentName)
+	return previousScheduling, true, nil
 }
 
-func updateRunaiConfigIfNeeded(client *client.Client, flags nodeRoleTypes, nodeWithRestrictSchedulingExist, nodeWithRestrictRunaiSystemExist bool) {
-	runaiconfigResource := schema.GroupVersionResource{Group: "run.ai", Version: "v1", Resource: "runaiconfigs"}
-	var error error
-	var runaiConfig *unstructured.Unstructured
-	for i := 0; i < common.NumberOfRetiresForApiServer; i++ {
-		runaiConfig, error = client.GetDynamicClient().Resource(runaiconfigResource).Namespace(common.RunaiNamespace).Get("runai", metav1.GetOptions{})
-		if error != nil {
-			fmt.Println("Failed to get RunaiConfig, Run:AI is not installed on the cluster")
-			os.Exit(1)
-		}
-		nodeAffinityMapOldValues, _, err := unstructured.NestedMap(runaiConfig.Object, "spec", "global", "nodeAffinity")
-		log.Debugf("RunaiConfig old values of nodeAffinityMap: %v", nodeAffinityMapOldValues)
+// setDeploymentScheduling sets the affinity and tolerations of the deployment pods and returns the ones it replaced
+func setDeploymentScheduling(client *client.Client, namespace, deploymentName string, newScheduling func(podScheduling) podScheduling) (podScheduling, error) {
+	var previousScheduling podScheduling
+	err := retry.Mutation(fmt.Sprintf("update %s", deploymentName), func() error {
+		current, err := client.GetClientset().AppsV1().Deployments(namespace).Get(deploymentName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		previousScheduling = podScheduling{
+			Affinity:    current.Spec.Template.Spec.Affinity,
+			Tolerations: current.Spec.Template.Spec.Tolerations,
+		}
+		scheduling := newScheduling(previousScheduling)
+		deployment := current.DeepCopy()
+		deployment.Spec.Template.Spec.Affinity = scheduling.Affinity
+		deployment.Spec.Template.Spec.Tolerations = scheduling.Tolerations
+		return patch.Update(client.GetDynamicClient().Resource(common.DeploymentResource).Namespace(namespace), current, deployment,
+			[]string{"spec", "template", "spec", "affinity"}, []string{"spec", "template", "spec", "tolerations"})
+	})
+	if err != nil {
+		return podScheduling{}, fmt.Errorf("Failed to update the %s, error: %w", deploymentName, err)
+	}
+	return previousScheduling, nil
+}
 
+func updateRunaiConfigIfNeeded(client *client.Client, flags nodeRoleTypes, nodeWithRestrictSchedulingExist, nodeWithRestrictRunaiSystemExist bool) (map[string]interface{}, bool, error) {
+	return setRunaiConfigNodeAffinity(client, func(nodeAffinityMapOldValues map[string]interface{}) map[string]interface{} {
 		nodeAffinityMap := map[string]interface{}{}
 		for key, val := range nodeAffinityMapOldValues {
 			nodeAffinityMap[key] = val
 		}
-		if err != nil {
-			fmt.Printf("Failed to get nodeAffinityMap from runaiConfig, error: %v", err)
-			os.Exit(1)
-		}
 
 		if flags.CpuWorker || flags.GpuWorker {
 			nodeAffinityMap["restrictScheduling"] = nodeWithRestrictSchedulingExist
@@ -272,185 +556,261 @@ func updateRunaiConfigIfNeeded(client *client.Client, flags nodeRoleTypes, nodeW
 		if flags.RunaiSystemWorker {
 			nodeAffinityMap["restrictRunaiSystem"] = nodeWithRestrictRunaiSystemExist
 		}
+		return nodeAffinityMap
+	})
+}
 
-		if !reflect.DeepEqual(nodeAffinityMap, nodeAffinityMapOldValues) {
-			log.Debugf("Updating RunaiConfig with nodeAffinityMap: %v", nodeAffinityMap)
-			err = unstructured.SetNestedMap(runaiConfig.Object, nodeAffinityMap, "spec", "global", "nodeAffinity")
-			_, error = client.GetDynamicClient().Resource(runaiconfigResource).Namespace(common.RunaiNamespace).Update(runaiConfig, metav1.UpdateOptions{})
-			if error != nil {
-				log.Debugf("Failed to update runaiconfig, attempt: %v, error: %v", i, error)
-				continue
-			}
+// setRunaiConfigNodeAffinity replaces the nodeAffinity values of the RunaiConfig with the values returned by
+// newNodeAffinity, and returns the values it replaced and whether the RunaiConfig was updated
+func setRunaiConfigNodeAffinity(client *client.Client, newNodeAffinity func(map[string]interface{}) map[string]interface{}) (map[string]interface{}, bool, error) {
+	var nodeAffinityMapOldValues map[string]interface{}
+	updated := false
+	err := retry.Mutation("update runaiconfig", func() error {
+		runaiConfig, err := common.GetRunaiConfig(client)
+		if err != nil {
+			return err
 		}
-		break
-	}
+		nodeAffinityMapOldValues, _, err = unstructured.NestedMap(runaiConfig.Object, "spec", "global", "nodeAffinity")
+		if err != nil {
+			return fmt.Errorf("Failed to get nodeAffinityMap from runaiConfig, error: %w", err)
+		}
+		log.Debugf("RunaiConfig old values of nodeAffinityMap: %v", nodeAffinityMapOldValues)
 
-	if error != nil {
-		log.Infof("Failed to update runaiconfig, error: %v", error)
-		os.Exit(1)
+		nodeAffinityMap := newNodeAffinity(nodeAffinityMapOldValues)
+		if reflect.DeepEqual(nodeAffinityMap, nodeAffinityMapOldValues) {
+			return nil
+		}
+		log.Debugf("Updating RunaiConfig with nodeAffinityMap: %v", nodeAffinityMap)
+		setOrRemoveNestedMap(runaiConfig.Object, nodeAffinityMap, "spec", "global", "nodeAffinity")
+		_, err = client.GetDynamicClient().Resource(common.RunaiConfigResource).Namespace(common.RunaiNamespace).Update(runaiConfig, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to update runaiconfig, error: %w", err)
+		}
+		updated = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
 	}
+	return nodeAffinityMapOldValues, updated, nil
 }
 
-func updateHelmReleaseIfNeeded(client *client.Client, flags nodeRoleTypes, nodeWithRestrictRunaiSystemExist bool) {
-	helmReleaseResource := schema.GroupVersionResource{Group: "helm.fluxcd.io", Version: "v1", Resource: "HelmRelease"}
-	var error error
-	var runaiBackendHelmRelease *unstructured.Unstructured
-	for i := 0; i < common.NumberOfRetiresForApiServer; i++ {
-		runaiBackendHelmRelease, error = client.GetDynamicClient().Resource(helmReleaseResource).Namespace(common.RunaiBackendNamespace).Get("runai-backend", metav1.GetOptions{})
-		if error != nil {
-			fmt.Println("Failed to get HelmRelease, Run:AI Backend is not installed on the cluster")
-			os.Exit(1)
-		}
-		nodeAffinityMapOldValues, _, err := unstructured.NestedMap(runaiBackendHelmRelease.Object, "spec", "global", "nodeAffinity")
-		log.Debugf("HelmRelease old values of nodeAffinityMap: %v", nodeAffinityMapOldValues)
-
+func updateBackendReleaseIfNeeded(client *client.Client, release backendRelease, flags nodeRoleTypes, nodeWithRestrictRunaiSystemExist bool) (map[string]interface{}, bool, error) {
+	return release.setNodeAffinity(client, func(nodeAffinityMapOldValues map[string]interface{}) map[string]interface{} {
 		nodeAffinityMap := map[string]interface{}{}
 		for key, val := range nodeAffinityMapOldValues {
 			nodeAffinityMap[key] = val
 		}
-		if err != nil {
-			fmt.Printf("Failed to get nodeAffinityMap from runaiBackendHelmRelease, error: %v", err)
-			os.Exit(1)
-		}
 
 		if flags.RunaiSystemWorker {
 			nodeAffinityMap["restrictRunaiSystem"] = nodeWithRestrictRunaiSystemExist
 		}
+		return nodeAffinityMap
+	})
+}
 
-		if !reflect.DeepEqual(nodeAffinityMap, nodeAffinityMapOldValues) {
-			log.Debugf("Updating HelmRelease with nodeAffinityMap: %v", nodeAffinityMap)
-			err = unstructured.SetNestedMap(runaiBackendHelmRelease.Object, nodeAffinityMap, "spec", "global", "nodeAffinity")
-			_, error = client.GetDynamicClient().Resource(helmReleaseResource).Namespace(common.RunaiNamespace).Update(runaiBackendHelmRelease, metav1.UpdateOptions{})
-			if error != nil {
-				log.Debugf("Failed to update HelmRelease, attempt: %v, error: %v", i, error)
-				continue
-			}
-		}
-		break
-	}
-
-	if error != nil {
-		log.Infof("Failed to update HelmRelease, error: %v", error)
-		os.Exit(1)
+// setOrRemoveNestedMap removes the field when the value is nil, so a rollback restores a field that did not exist
+func setOrRemoveNestedMap(obj map[string]interface{}, value map[string]interface{}, fields ...string) {
+	if value == nil {
+		unstructured.RemoveNestedField(obj, fields...)
+		return
 	}
+	unstructured.SetNestedMap(obj, value, fields...)
 }
 
-func labelNodesWithRolesAndGetNodesInCluster(client *client.Client, flags nodeRoleTypes, args []string, shouldEnableLabel bool) map[string]v1.Node {
+func labelNodesWithRolesAndGetNodesInCluster(client *client.Client, flags nodeRoleTypes, args []string, shouldEnableLabel bool, originalNodeRoles map[string]nodeRoles) (map[string]v1.Node, error) {
 	log.Info("Updating nodes with roles")
 
 	allNodeClusters := map[string]v1.Node{}
 	nodesInCluster, err := client.GetClientset().CoreV1().Nodes().List(metav1.ListOptions{})
-	if err != nil || len(nodesInCluster.Items) == 0 {
-		fmt.Println("Failed to list nodes in cluster")
-		os.Exit(1)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list nodes in cluster, error: %w", err)
 	}
-
-	wasAnyNodeUpdated := false
-	if flags.AllNodes {
-		for _, nodeInfo := range nodesInCluster.Items {
-			updateLabelsSingleNode(&nodeInfo, flags, client, shouldEnableLabel)
-			allNodeClusters[nodeInfo.Name] = nodeInfo
-			wasAnyNodeUpdated = true
-		}
-		log.Debugf("Successfully updated all nodes with roles")
-		return allNodeClusters
+	if len(nodesInCluster.Items) == 0 {
+		return nil, fmt.Errorf("Failed to list nodes in cluster, the cluster has no nodes")
 	}
 
+	var nodesToUpdate []v1.Node
 	nodesToUpdateMap := map[string]bool{}
-	wasNodeUpdated := map[string]bool{}
 	for _, nodeToLabel := range args {
 		nodesToUpdateMap[nodeToLabel] = true
-		wasNodeUpdated[nodeToLabel] = false
 	}
 	for _, nodeInfo := range nodesInCluster.Items {
-		if nodesToUpdateMap[nodeInfo.Name] {
-			updateLabelsSingleNode(&nodeInfo, flags, client, shouldEnableLabel)
-			wasAnyNodeUpdated = true
-			wasNodeUpdated[nodeInfo.Name] = true
-			allNodeClusters[nodeInfo.Name] = nodeInfo
-		}
 		allNodeClusters[nodeInfo.Name] = nodeInfo
+		if flags.AllNodes || nodesToUpdateMap[nodeInfo.Name] {
+			originalNodeRoles[nodeInfo.Name] = getNodeRoles(nodeInfo)
+			nodesToUpdate = append(nodesToUpdate, nodeInfo)
+			delete(nodesToUpdateMap, nodeInfo.Name)
+		}
 	}
 
-	for nodeName := range wasNodeUpdated {
-		if !wasNodeUpdated[nodeName] {
-			log.Infof("Node: %v was not found in cluster", nodeName)
-		}
+	for nodeName := range nodesToUpdateMap {
+		log.Infof("Node: %v was not found in cluster", nodeName)
 	}
 
-	if !wasAnyNodeUpdated {
-		log.Infof("All nodes are already updated")
-		os.Exit(1)
+	if len(nodesToUpdate) == 0 {
+		return nil, fmt.Errorf("All nodes are already updated")
 	}
 
-	return allNodeClusters
+	updatedNodes, err := updateNodesInParallel(client, nodesToUpdate, flags.Parallelism, func(nodeInfo *v1.Node) error {
+		return updateLabelsSingleNode(nodeInfo, flags, client, shouldEnableLabel)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for nodeName, nodeInfo := range updatedNodes {
+		allNodeClusters[nodeName] = nodeInfo
+	}
+	return allNodeClusters, nil
 }
 
-func updateLabelsSingleNode(nodeInfo *v1.Node, flags nodeRoleTypes, client *client.Client, shouldEnableLabel bool) {
-	var err error
-	for i := 0; i < common.NumberOfRetiresForApiServer; i++ {
-		if nodeInfo.Labels == nil {
-			nodeInfo.Labels = map[string]string{}
-		}
-		if flags.GpuWorker {
-			if shouldEnableLabel {
-				nodeInfo.Labels[gpuWorkerLabel] = ""
-			} else {
-				delete(nodeInfo.Labels, gpuWorkerLabel)
-			}
+func updateLabelsSingleNode(nodeInfo *v1.Node, flags nodeRoleTypes, client *client.Client, shouldEnableLabel bool) error {
+	return updateNode(client, nodeInfo, func(nodeInfo *v1.Node) {
+		setRoleLabels(nodeInfo.Labels, flags, shouldEnableLabel)
+		if flags.Taint {
+			setRoleTaints(nodeInfo, flags, shouldEnableLabel)
 		}
-		if flags.CpuWorker {
-			if shouldEnableLabel {
-				nodeInfo.Labels[cpuWorkerLabel] = ""
-			} else {
-				delete(nodeInfo.Labels, cpuWorkerLabel)
+	})
+}
+
+// updateNode applies the mutation to the node, and on a retry to the latest version of the node. Only the role labels
+// and the taints are patched, so the concurrent changes of kubelet to the node are kept. A change of the taints fails
+// with a conflict, and is retried, when the node was changed concurrently
+func updateNode(client *client.Client, nodeInfo *v1.Node, mutate func(nodeInfo *v1.Node)) error {
+	attempt := 0
+	err := retry.Mutation(fmt.Sprintf("update node %s", nodeInfo.Name), func() error {
+		attempt++
+		if attempt > 1 {
+			latestNodeInfo, err := client.GetClientset().CoreV1().Nodes().Get(nodeInfo.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
 			}
+			*nodeInfo = *latestNodeInfo
 		}
-		if flags.RunaiSystemWorker {
-			if shouldEnableLabel {
-				nodeInfo.Labels[systemWorkerLabel] = ""
-			} else {
-				delete(nodeInfo.Labels, systemWorkerLabel)
-			}
+		modified := nodeInfo.DeepCopy()
+		if modified.Labels == nil {
+			modified.Labels = map[string]string{}
+		}
+		mutate(modified)
+		update := patch.Update
+		if !reflect.DeepEqual(nodeInfo.Spec.Taints, modified.Spec.Taints) {
+			// the taints are patched as a whole list, which must not overwrite the taints that the node lifecycle
+			// controller sets concurrently, e.g. not-ready
+			update = patch.UpdateIfUnchanged
+		}
+		if err := update(client.GetDynamicClient().Resource(nodesResource), nodeInfo, modified, nodeRoleFields()...); err != nil {
+			return err
 		}
-		_, err = client.GetClientset().CoreV1().Nodes().Update(nodeInfo)
+		*nodeInfo = *modified
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to update node: %v, error: %w", nodeInfo.Name, err)
+	}
+	return nil
+}
+
+// restoreNodesRoles sets the role labels and role taints of the nodes back to the ones they had before the flow
+func restoreNodesRoles(client *client.Client, originalNodeRoles map[string]nodeRoles) error {
+	var failedNodes []string
+	for nodeName, originalRoles := range originalNodeRoles {
+		nodeInfo, err := client.GetClientset().CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
 		if err == nil {
-			break
+			err = updateNode(client, nodeInfo, func(nodeInfo *v1.Node) {
+				for _, label := range allRoleLabels {
+					if value, found := originalRoles.Labels[label]; found {
+						nodeInfo.Labels[label] = value
+					} else {
+						delete(nodeInfo.Labels, label)
+					}
+					nodeInfo.Spec.Taints = removeRoleTaint(nodeInfo.Spec.Taints, label)
+				}
+				nodeInfo.Spec.Taints = append(nodeInfo.Spec.Taints, originalRoles.Taints...)
+			})
+		}
+		if err != nil {
+			log.Debugf("Failed to restore roles of node: %v, error: %v", nodeName, err)
+			failedNodes = append(failedNodes, nodeName)
 		}
-		nodeInfo, _ = client.GetClientset().CoreV1().Nodes().Get(nodeInfo.Name, metav1.GetOptions{})
+	}
+	if len(failedNodes) > 0 {
+		return fmt.Errorf("failed to restore the roles of nodes: %v", failedNodes)
+	}
+	return nil
+}
 
-		log.Debugf("Failed to update node, attempt: %v, error: %v", i, err)
+// nodeRoleFields are the fields of a node that the node roles own
+func nodeRoleFields() [][]string {
+	fields := [][]string{{"spec", "taints"}}
+	for _, label := range allRoleLabels {
+		fields = append(fields, []string{"metadata", "labels", label})
 	}
-	if err != nil {
-		log.Infof("Failed to update node: %v, ", err)
-		os.Exit(1)
+	return fields
+}
+
+func getNodeRoles(nodeInfo v1.Node) nodeRoles {
+	labels := map[string]string{}
+	for _, label := range allRoleLabels {
+		if value, found := nodeInfo.Labels[label]; found {
+			labels[label] = value
+		}
+	}
+	return nodeRoles{Labels: labels, Taints: roleTaints(nodeInfo.Spec.Taints)}
+}
+
+func setRoleLabels(labels map[string]string, flags nodeRoleTypes, shouldEnableLabel bool) {
+	for label, selected := range map[string]bool{gpuWorkerLabel: flags.GpuWorker, cpuWorkerLabel: flags.CpuWorker, systemWorkerLabel: flags.RunaiSystemWorker} {
+		if !selected {
+			continue
+		}
+		if shouldEnableLabel {
+			labels[label] = ""
+		} else {
+			delete(labels, label)
+		}
 	}
 }
 
 func Remove() *cobra.Command {
 	flags := nodeRoleTypes{}
+	evictionOptions := evictionOptions{}
+	safetyOptions := safetyOptions{}
 	withBackend := false
 	var command = &cobra.Command{
 		Use:     "node-role NODE_NAME",
 		Aliases: []string{"node-roles"},
 		Short:   "Remove node with roles",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateParallelism(flags.Parallelism); err != nil {
+				return err
+			}
 			if len(args) == 0 && !flags.AllNodes {
-				fmt.Println("No nodes were selected")
 				cmd.HelpFunc()(cmd, args)
-				os.Exit(1)
+				return commandUtil.Validationf("No nodes were selected")
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			if err := checkNodeRolesSafety(client, flags, args, false, safetyOptions); err != nil {
+				return err
+			}
+			if err := updateNodeRoles(client, flags, args, false, withBackend, evictionOptions); err != nil {
+				return err
 			}
-			client := client.GetClient()
-			nodesInCluster := labelNodesWithRolesAndGetNodesInCluster(client, flags, args, false)
-			updateRunaiConfigurations(client, flags, nodesInCluster, withBackend)
 			log.Infof("Successfully updated nodes with roles")
+			return nil
 		},
 	}
 
-	command.Flags().BoolVar(&withBackend, "with-backend", false, "Update backend pods (In Air-gapped environment)")
+	command.Flags().BoolVar(&withBackend, "with-backend", false, "Update backend pods (In Air-gapped environment). The backend must be installed by a Flux HelmRelease, a plain Helm release is refused before any node is changed.")
 	command.Flags().BoolVar(&flags.AllNodes, "all", false, "Set all nodes")
 	command.Flags().BoolVar(&flags.CpuWorker, "cpu-worker", false, "Set nodes with node-role of CPU Worker.")
 	command.Flags().BoolVar(&flags.GpuWorker, "gpu-worker", false, "Set nodes with node-role of GPU Worker.")
 	command.Flags().BoolVar(&flags.RunaiSystemWorker, "runai-system-worker", false, "Set nodes with node-role of Run:AI System Worker.")
+	command.Flags().BoolVar(&flags.Taint, "taint", false, "Also remove the NoSchedule taint that matches each role.")
+	addParallelismFlag(command, &flags.Parallelism)
+	addEvictionFlags(command, &evictionOptions)
+	addSafetyFlags(command, &safetyOptions)
 	return command
 }
@@ -0,0 +1,127 @@
+package noderole
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultParallelism = 10
+
+func addParallelismFlag(command *cobra.Command, parallelism *int) {
+	command.Flags().IntVar(parallelism, "parallelism", defaultParallelism, "Maximal number of nodes to update concurrently.")
+}
+
+func validateParallelism(parallelism int) error {
+	if parallelism < 1 {
+		return commandUtil.Validationf("--parallelism must be at least 1, got: %d", parallelism)
+	}
+	return nil
+}
+
+// nodeUpdateResult is the result of the update of a single node
+type nodeUpdateResult struct {
+	node v1.Node
+	err  error
+}
+
+// updateNodesInParallel updates the nodes by a pool of at most parallelism concurrent workers, and updates the nodes
+// that failed once more after all the others are done. It prints a summary of the nodes that succeeded and failed,
+// and returns the updated nodes and an error when any node still failed
+func updateNodesInParallel(client *client.Client, nodes []v1.Node, parallelism int, update func(node *v1.Node) error) (map[string]v1.Node, error) {
+	results := runNodeUpdates(nodes, parallelism, update)
+
+	var failedNodes []v1.Node
+	for _, result := range results {
+		if result.err != nil {
+			log.Debugf("Failed to update node: %v, retrying, error: %v", result.node.Name, result.err)
+			failedNodes = append(failedNodes, result.node)
+		}
+	}
+	if len(failedNodes) > 0 {
+		log.Infof("Retrying the update of %d failed nodes", len(failedNodes))
+		retryResults := runNodeUpdates(failedNodes, parallelism, func(node *v1.Node) error {
+			// the node may have changed since it was listed
+			latestNode, err := client.GetClientset().CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("Failed to get node: %v, error: %w", node.Name, err)
+			}
+			*node = *latestNode
+			return update(node)
+		})
+		for name, result := range retryResults {
+			results[name] = result
+		}
+	}
+
+	updatedNodes := map[string]v1.Node{}
+	failures := map[string]error{}
+	for name, result := range results {
+		if result.err != nil {
+			failures[name] = result.err
+		} else {
+			updatedNodes[name] = result.node
+		}
+	}
+	printNodeUpdatesSummary(len(updatedNodes), failures)
+	if len(failures) > 0 {
+		return updatedNodes, fmt.Errorf("Failed to update %d of %d nodes", len(failures), len(nodes))
+	}
+	return updatedNodes, nil
+}
+
+// runNodeUpdates runs the update of every node once, and returns the results by node name
+func runNodeUpdates(nodes []v1.Node, parallelism int, update func(node *v1.Node) error) map[string]nodeUpdateResult {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	nodesToUpdate := make(chan v1.Node)
+	var lock sync.Mutex
+	results := map[string]nodeUpdateResult{}
+	var workers sync.WaitGroup
+	for i := 0; i < parallelism && i < len(nodes); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for node := range nodesToUpdate {
+				err := update(&node)
+				lock.Lock()
+				results[node.Name] = nodeUpdateResult{node: node, err: err}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, node := range nodes {
+		nodesToUpdate <- node
+	}
+	close(nodesToUpdate)
+	workers.Wait()
+	return results
+}
+
+func printNodeUpdatesSummary(succeeded int, failures map[string]error) {
+	log.Infof("Updated %d nodes, %d nodes failed", succeeded, len(failures))
+	if len(failures) == 0 {
+		return
+	}
+	var names []string
+	for name := range failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "NODE\tERROR")
+	for _, name := range names {
+		fmt.Fprintf(writer, "%s\t%v\n", name, failures[name])
+	}
+	writer.Flush()
+}
@@ -0,0 +1,174 @@
+package noderole
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// setRoleTaints adds or removes the NoSchedule taints that match the selected roles,
+// e.g. node-role.kubernetes.io/runai-system=:NoSchedule
+func setRoleTaints(node *v1.Node, flags nodeRoleTypes, shouldEnableTaint bool) {
+	for label, selected := range map[string]bool{gpuWorkerLabel: flags.GpuWorker, cpuWorkerLabel: flags.CpuWorker, systemWorkerLabel: flags.RunaiSystemWorker} {
+		if !selected {
+			continue
+		}
+		taints := removeRoleTaint(node.Spec.Taints, label)
+		if shouldEnableTaint {
+			taints = append(taints, roleTaint(label))
+		}
+		node.Spec.Taints = taints
+	}
+}
+
+func roleTaint(label string) v1.Taint {
+	return v1.Taint{Key: label, Effect: v1.TaintEffectNoSchedule}
+}
+
+func removeRoleTaint(taints []v1.Taint, label string) []v1.Taint {
+	var result []v1.Taint
+	for _, taint := range taints {
+		if taint.Key == label && taint.Effect == v1.TaintEffectNoSchedule {
+			continue
+		}
+		result = append(result, taint)
+	}
+	return result
+}
+
+func hasRoleTaint(node v1.Node, label string) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == label && taint.Effect == v1.TaintEffectNoSchedule {
+			return true
+		}
+	}
+	return false
+}
+
+func roleTaints(taints []v1.Taint) []v1.Taint {
+	var result []v1.Taint
+	for _, taint := range taints {
+		for _, label := range allRoleLabels {
+			if taint.Key == label && taint.Effect == v1.TaintEffectNoSchedule {
+				result = append(result, taint)
+			}
+		}
+	}
+	return result
+}
+
+// roleTolerations returns the tolerations of the role taints which exist on the nodes,
+// so that the Run:AI pods can still be scheduled on the tainted nodes
+func roleTolerations(nodesInCluster map[string]v1.Node) []v1.Toleration {
+	var tolerations []v1.Toleration
+	for _, label := range allRoleLabels {
+		for _, nodeInfo := range nodesInCluster {
+			if hasRoleTaint(nodeInfo, label) {
+				tolerations = append(tolerations, v1.Toleration{Key: label, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule})
+				break
+			}
+		}
+	}
+	return tolerations
+}
+
+// mergeRoleTolerations replaces the role tolerations in the existing tolerations and keeps all other tolerations
+func mergeRoleTolerations(existing, tolerations []v1.Toleration) []v1.Toleration {
+	var result []v1.Toleration
+	for _, toleration := range existing {
+		isRoleToleration := false
+		for _, label := range allRoleLabels {
+			if toleration.Key == label {
+				isRoleToleration = true
+			}
+		}
+		if !isRoleToleration {
+			result = append(result, toleration)
+		}
+	}
+	return append(result, tolerations...)
+}
+
+func updateRunaiConfigTolerationsIfNeeded(client *client.Client, flags nodeRoleTypes, tolerations []v1.Toleration) ([]interface{}, bool, error) {
+	if !flags.Taint {
+		return nil, false, nil
+	}
+	return setRunaiConfigTolerations(client, func(existing []v1.Toleration) []v1.Toleration {
+		return mergeRoleTolerations(existing, tolerations)
+	})
+}
+
+// setRunaiConfigTolerations replaces the global tolerations of the RunaiConfig with the tolerations returned
+// by newTolerations, and returns the raw values it replaced and whether the RunaiConfig was updated
+func setRunaiConfigTolerations(client *client.Client, newTolerations func([]v1.Toleration) []v1.Toleration) ([]interface{}, bool, error) {
+	var oldValues []interface{}
+	updated := false
+	err := retry.Mutation("update runaiconfig", func() error {
+		runaiConfig, err := common.GetRunaiConfig(client)
+		if err != nil {
+			return err
+		}
+		oldValues, _, err = unstructured.NestedSlice(runaiConfig.Object, "spec", "global", "tolerations")
+		if err != nil {
+			return fmt.Errorf("Failed to get tolerations from runaiConfig, error: %w", err)
+		}
+		values := []interface{}{}
+		for _, toleration := range newTolerations(tolerationsFromUnstructured(oldValues)) {
+			value, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&toleration)
+			if err != nil {
+				return err
+			}
+			values = append(values, value)
+		}
+		if reflect.DeepEqual(values, oldValues) || (len(values) == 0 && len(oldValues) == 0) {
+			return nil
+		}
+
+		log.Debugf("Updating RunaiConfig with tolerations: %v", values)
+		if len(values) == 0 {
+			unstructured.RemoveNestedField(runaiConfig.Object, "spec", "global", "tolerations")
+		} else {
+			unstructured.SetNestedSlice(runaiConfig.Object, values, "spec", "global", "tolerations")
+		}
+		_, err = client.GetDynamicClient().Resource(common.RunaiConfigResource).Namespace(common.RunaiNamespace).Update(runaiConfig, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to update runaiconfig, error: %w", err)
+		}
+		updated = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return oldValues, updated, nil
+}
+
+func restoreRunaiConfigTolerations(client *client.Client, oldValues []interface{}) error {
+	_, _, err := setRunaiConfigTolerations(client, func([]v1.Toleration) []v1.Toleration {
+		return tolerationsFromUnstructured(oldValues)
+	})
+	return err
+}
+
+func tolerationsFromUnstructured(values []interface{}) []v1.Toleration {
+	var tolerations []v1.Toleration
+	for _, value := range values {
+		valueMap, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var toleration v1.Toleration
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(valueMap, &toleration); err == nil {
+			tolerations = append(tolerations, toleration)
+		}
+	}
+	return tolerations
+}
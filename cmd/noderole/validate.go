@@ -0,0 +1,232 @@
+package noderole
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/pkg/client"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	gpuResourceName v1.ResourceName = "nvidia.com/gpu"
+)
+
+type safetyOptions struct {
+	Force          bool
+	MinSystemNodes int
+}
+
+func addSafetyFlags(command *cobra.Command, options *safetyOptions) {
+	command.Flags().BoolVar(&options.Force, "force", false, "Apply the node roles even if the safety checks fail.")
+	command.Flags().IntVar(&options.MinSystemNodes, "min-system-nodes", 1, "Minimal number of schedulable Run:AI System nodes required while Run:AI System pods are restricted to them.")
+}
+
+// checkNodeRolesSafety verifies that the cluster would still be able to run Run:AI after the
+// requested node roles change, and refuses the change (unless forced) with an explanation
+func checkNodeRolesSafety(client *client.Client, flags nodeRoleTypes, args []string, shouldEnableLabel bool, options safetyOptions) error {
+	return refuseUnlessForced(validateResultingNodeRoles(client, flags, args, shouldEnableLabel, options), options)
+}
+
+func refuseUnlessForced(err error, options safetyOptions) error {
+	if err == nil {
+		return nil
+	}
+	if options.Force {
+		log.Warnf("Ignoring failed safety checks (--force was given): %v", err)
+		return nil
+	}
+	return fmt.Errorf("%w\nUse --force to apply the node roles anyway", err)
+}
+
+func validateResultingNodeRoles(client *client.Client, flags nodeRoleTypes, args []string, shouldEnableLabel bool, options safetyOptions) error {
+	nodeList, err := client.GetClientset().CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list nodes in cluster, error: %w", err)
+	}
+
+	return validateResultingNodes(client, simulateRoleLabels(nodeList.Items, flags, args, shouldEnableLabel), flags, options)
+}
+
+// validateResultingNodes validates the cluster nodes as they would be labeled after changing the given roles
+func validateResultingNodes(client *client.Client, resultingNodes []v1.Node, flags nodeRoleTypes, options safetyOptions) error {
+	currentAffinity, err := getRunaiConfigNodeAffinity(client)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	if isRestrictionActive(currentAffinity, "restrictRunaiSystem", flags.RunaiSystemWorker, nodesWithAnyLabel(resultingNodes, systemWorkerLabel)) {
+		problems = append(problems, validateSystemNodes(client, resultingNodes, currentAffinity, options)...)
+	}
+	if isRestrictionActive(currentAffinity, "restrictScheduling", flags.CpuWorker || flags.GpuWorker, nodesWithAnyLabel(resultingNodes, cpuWorkerLabel, gpuWorkerLabel)) {
+		problems = append(problems, validateGpuWorkerNodes(resultingNodes)...)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("The requested node roles would prevent Run:AI components from being scheduled:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// simulateRoleLabels returns the cluster nodes as they would be labeled after the change
+func simulateRoleLabels(nodes []v1.Node, flags nodeRoleTypes, args []string, shouldEnableLabel bool) []v1.Node {
+	selectedNodes := map[string]bool{}
+	for _, nodeName := range args {
+		selectedNodes[nodeName] = true
+	}
+
+	resultingNodes := make([]v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		node = *node.DeepCopy()
+		if flags.AllNodes || selectedNodes[node.Name] {
+			if node.Labels == nil {
+				node.Labels = map[string]string{}
+			}
+			setRoleLabels(node.Labels, flags, shouldEnableLabel)
+		}
+		resultingNodes = append(resultingNodes, node)
+	}
+	return resultingNodes
+}
+
+// isRestrictionActive returns whether a RunaiConfig restriction has to be satisfied by the change.
+// A restriction that is enabled today stays active until the operator re-renders the Run:AI pods,
+// so removing the last node of its role would strand them
+func isRestrictionActive(currentAffinity map[string]interface{}, restriction string, isRoleChanged bool, resultingRoleNodes []v1.Node) bool {
+	currentlyRestricted, _ := currentAffinity[restriction].(bool)
+	if !isRoleChanged {
+		return currentlyRestricted
+	}
+	return currentlyRestricted || len(resultingRoleNodes) > 0
+}
+
+func validateSystemNodes(client *client.Client, resultingNodes []v1.Node, currentAffinity map[string]interface{}, options safetyOptions) []string {
+	var schedulableSystemNodes []v1.Node
+	for _, node := range nodesWithAnyLabel(resultingNodes, systemWorkerLabel) {
+		if !node.Spec.Unschedulable {
+			schedulableSystemNodes = append(schedulableSystemNodes, node)
+		}
+	}
+
+	if len(schedulableSystemNodes) == 0 {
+		if restricted, _ := currentAffinity["restrictRunaiSystem"].(bool); restricted {
+			return []string{"no schedulable Run:AI System nodes would be left while restrictRunaiSystem is enabled in the RunaiConfig"}
+		}
+		return []string{"no schedulable Run:AI System nodes would be left for the Run:AI System pods"}
+	}
+
+	var problems []string
+	if len(schedulableSystemNodes) < options.MinSystemNodes {
+		problems = append(problems, fmt.Sprintf("only %d schedulable Run:AI System nodes (%s) would be left, at least %d are required", len(schedulableSystemNodes), nodeNames(schedulableSystemNodes), options.MinSystemNodes))
+	}
+
+	requests, err := namespaceResourceRequests(client, common.RunaiNamespace)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	allocatable := v1.ResourceList{}
+	for _, node := range schedulableSystemNodes {
+		addResources(allocatable, node.Status.Allocatable)
+	}
+	for _, resourceName := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		requested := requests[resourceName]
+		available := allocatable[resourceName]
+		if requested.Cmp(available) > 0 {
+			problems = append(problems, fmt.Sprintf("the pods in the %s namespace request %s %s but the Run:AI System nodes have only %s allocatable", common.RunaiNamespace, requested.String(), resourceName, available.String()))
+		}
+	}
+	return problems
+}
+
+func validateGpuWorkerNodes(resultingNodes []v1.Node) []string {
+	gpuWorkerNodes := nodesWithAnyLabel(resultingNodes, gpuWorkerLabel)
+	if len(gpuWorkerNodes) == 0 {
+		return []string{"no GPU Worker nodes would be left while scheduling is restricted to worker nodes"}
+	}
+
+	var nodesWithoutGpus []v1.Node
+	for _, node := range gpuWorkerNodes {
+		gpus := node.Status.Allocatable[gpuResourceName]
+		if gpus.IsZero() {
+			nodesWithoutGpus = append(nodesWithoutGpus, node)
+		}
+	}
+	if len(nodesWithoutGpus) == 0 {
+		return nil
+	}
+	if len(nodesWithoutGpus) == len(gpuWorkerNodes) {
+		return []string{fmt.Sprintf("none of the GPU Worker nodes have allocatable GPUs (%s)", nodeNames(nodesWithoutGpus))}
+	}
+	return []string{fmt.Sprintf("the following GPU Worker nodes have no allocatable GPUs: %s", nodeNames(nodesWithoutGpus))}
+}
+
+func getRunaiConfigNodeAffinity(client *client.Client) (map[string]interface{}, error) {
+	runaiConfig, err := common.GetRunaiConfig(client)
+	if err != nil {
+		return nil, err
+	}
+	nodeAffinity, _, err := unstructured.NestedMap(runaiConfig.Object, "spec", "global", "nodeAffinity")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get nodeAffinityMap from runaiConfig, error: %w", err)
+	}
+	return nodeAffinity, nil
+}
+
+func namespaceResourceRequests(client *client.Client, namespace string) (v1.ResourceList, error) {
+	pods, err := client.GetClientset().CoreV1().Pods(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods in the %s namespace, error: %w", namespace, err)
+	}
+
+	requests := v1.ResourceList{}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			addResources(requests, container.Resources.Requests)
+		}
+	}
+	return requests, nil
+}
+
+func addResources(total, toAdd v1.ResourceList) {
+	for resourceName, quantity := range toAdd {
+		current, found := total[resourceName]
+		if !found {
+			current = resource.Quantity{}
+		}
+		current.Add(quantity)
+		total[resourceName] = current
+	}
+}
+
+func nodesWithAnyLabel(nodes []v1.Node, labels ...string) []v1.Node {
+	var result []v1.Node
+	for _, node := range nodes {
+		for _, label := range labels {
+			if _, found := node.Labels[label]; found {
+				result = append(result, node)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func nodeNames(nodes []v1.Node) string {
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
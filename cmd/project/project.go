@@ -0,0 +1,553 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/scheduling"
+	"github.com/run-ai/runai-cli/pkg/util"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// projectNamespacePrefix is the prefix of the namespace that the project controller creates for each project
+	projectNamespacePrefix = "runai-"
+	// nodeTypeLabel is the node label that the node affinity of a project selects
+	nodeTypeLabel = "run.ai/type"
+)
+
+var (
+	// overQuotaPolicies maps the over-quota policy to spec.gpuOverQuotaWeight, a project with the "none" policy
+	// cannot use GPUs beyond its deserved quota
+	overQuotaPolicies = map[string]int64{"none": 0, "low": 1, "medium": 2, "high": 3}
+)
+
+type projectOptions struct {
+	Department              string
+	DeservedGpus            float64
+	OverQuota               string
+	InteractiveTimeLimit    time.Duration
+	NodeAffinityTrain       []string
+	NodeAffinityInteractive []string
+	Force                   bool
+}
+
+func Command() *cobra.Command {
+	var command = &cobra.Command{
+		Use:     "project",
+		Aliases: []string{"projects"},
+		Short:   "Manage Run:AI projects.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	command.AddCommand(createCommand())
+	command.AddCommand(listCommand())
+	command.AddCommand(describeCommand())
+	command.AddCommand(updateCommand())
+	command.AddCommand(deleteCommand())
+	command.AddCommand(moveCommand())
+
+	return command
+}
+
+func addProjectFlags(command *cobra.Command, options *projectOptions) {
+	command.Flags().StringVar(&options.Department, "department", "", "Department of the project.")
+	command.Flags().Float64Var(&options.DeservedGpus, "deserved-gpus", 0, "GPU quota that the project is guaranteed to get.")
+	command.Flags().StringVar(&options.OverQuota, "over-quota", "medium", "Priority of the project when using GPUs beyond its deserved quota: none, low, medium or high. With none the project cannot go over quota.")
+	command.Flags().DurationVar(&options.InteractiveTimeLimit, "interactive-time-limit", 0, "Maximal duration of interactive jobs, e.g. 8h. 0 means unlimited.")
+	command.Flags().StringSliceVar(&options.NodeAffinityTrain, "node-affinity-train", nil, "Node types (values of the run.ai/type node label) that training jobs may run on.")
+	command.Flags().StringSliceVar(&options.NodeAffinityInteractive, "node-affinity-interactive", nil, "Node types (values of the run.ai/type node label) that interactive jobs may run on.")
+	addForceFlag(command, &options.Force)
+}
+
+func addForceFlag(command *cobra.Command, force *bool) {
+	command.Flags().BoolVar(force, "force", false, "Apply the change even if the GPU quotas of the department exceed their limits.")
+}
+
+func createCommand() *cobra.Command {
+	options := projectOptions{}
+	var command = &cobra.Command{
+		Use:   "create PROJECT_NAME",
+		Short: "Create a project",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			project := &unstructured.Unstructured{Object: map[string]interface{}{}}
+			project.SetAPIVersion(scheduling.ProjectResource.GroupVersion().String())
+			project.SetKind("Project")
+			project.SetName(args[0])
+			if err := setProjectSpec(project, options, cmd.Flags(), true); err != nil {
+				return err
+			}
+			if err := validateProject(client, project); err != nil {
+				return err
+			}
+			if err := checkProjectQuota(client, project, options.Force); err != nil {
+				return err
+			}
+
+			err = retry.Mutation(fmt.Sprintf("create project %v", args[0]), func() error {
+				_, err := client.GetDynamicClient().Resource(scheduling.ProjectResource).Create(project, metav1.CreateOptions{})
+				return err
+			})
+			if errors.IsAlreadyExists(err) {
+				return commandUtil.Conflict(fmt.Errorf("Project: %v already exists, use 'project update' to change it", args[0]))
+			}
+			if err != nil {
+				return fmt.Errorf("Failed to create project: %v, error: %w", args[0], err)
+			}
+			warnIfOverCommitted(client)
+			fmt.Printf("Successfully created project: %v\n", args[0])
+			return nil
+		},
+	}
+
+	addProjectFlags(command, &options)
+	return command
+}
+
+func updateCommand() *cobra.Command {
+	options := projectOptions{}
+	var command = &cobra.Command{
+		Use:   "update PROJECT_NAME",
+		Short: "Update a project, only the given flags are changed",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().NFlag() == 0 {
+				cmd.HelpFunc()(cmd, args)
+				return commandUtil.Validationf("No flags were provided")
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			err = updateProject(client, args[0], func(project *unstructured.Unstructured) error {
+				if err := setProjectSpec(project, options, cmd.Flags(), false); err != nil {
+					return err
+				}
+				if err := validateProject(client, project); err != nil {
+					return err
+				}
+				return checkProjectQuota(client, project, options.Force)
+			})
+			if err != nil {
+				return err
+			}
+			warnIfOverCommitted(client)
+			fmt.Printf("Successfully updated project: %v\n", args[0])
+			return nil
+		},
+	}
+
+	addProjectFlags(command, &options)
+	return command
+}
+
+// updateProject applies mutate to the latest version of the project and updates it
+func updateProject(client *client.Client, name string, mutate func(project *unstructured.Unstructured) error) error {
+	err := retry.Mutation(fmt.Sprintf("update project %v", name), func() error {
+		project, err := client.GetDynamicClient().Resource(scheduling.ProjectResource).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return commandUtil.Validationf("Project: %v does not exist", name)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to get project: %v, error: %w", name, err)
+		}
+		if err := mutate(project); err != nil {
+			return err
+		}
+		_, err = client.GetDynamicClient().Resource(scheduling.ProjectResource).Update(project, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to update project: %v, error: %w", name, err)
+	}
+	return nil
+}
+
+// setProjectSpec sets the spec fields of the given flags, or of all the flags when creating the project
+func setProjectSpec(project *unstructured.Unstructured, options projectOptions, flags *pflag.FlagSet, isCreate bool) error {
+	isSet := func(name string) bool { return isCreate || flags.Changed(name) }
+
+	if isSet("department") {
+		if options.Department == "" {
+			unstructured.RemoveNestedField(project.Object, "spec", "department")
+		} else {
+			unstructured.SetNestedField(project.Object, options.Department, "spec", "department")
+		}
+	}
+	if isSet("deserved-gpus") {
+		if options.DeservedGpus < 0 {
+			return commandUtil.Validationf("--deserved-gpus cannot be negative")
+		}
+		unstructured.SetNestedField(project.Object, options.DeservedGpus, "spec", "deservedGpus")
+	}
+	if isSet("over-quota") {
+		weight, found := overQuotaPolicies[options.OverQuota]
+		if !found {
+			return commandUtil.Validationf("Invalid --over-quota: %v, must be one of: none, low, medium, high", options.OverQuota)
+		}
+		unstructured.SetNestedField(project.Object, weight, "spec", "gpuOverQuotaWeight")
+	}
+	if isSet("interactive-time-limit") {
+		if options.InteractiveTimeLimit < 0 {
+			return commandUtil.Validationf("--interactive-time-limit cannot be negative")
+		}
+		if options.InteractiveTimeLimit == 0 {
+			unstructured.RemoveNestedField(project.Object, "spec", "interactiveJobTimeLimitSecs")
+		} else {
+			unstructured.SetNestedField(project.Object, int64(options.InteractiveTimeLimit.Seconds()), "spec", "interactiveJobTimeLimitSecs")
+		}
+	}
+	if isSet("node-affinity-train") {
+		setNodeAffinity(project, options.NodeAffinityTrain, "nodeAffinityTrain")
+	}
+	if isSet("node-affinity-interactive") {
+		setNodeAffinity(project, options.NodeAffinityInteractive, "nodeAffinityInteractive")
+	}
+	return nil
+}
+
+func setNodeAffinity(project *unstructured.Unstructured, nodeTypes []string, field string) {
+	if len(nodeTypes) == 0 {
+		unstructured.RemoveNestedField(project.Object, "spec", field)
+		return
+	}
+	unstructured.SetNestedStringSlice(project.Object, nodeTypes, "spec", field)
+}
+
+func validateProject(client *client.Client, project *unstructured.Unstructured) error {
+	var problems []string
+	for _, message := range validation.IsDNS1123Label(projectNamespacePrefix + project.GetName()) {
+		problems = append(problems, fmt.Sprintf("invalid project name, the project namespace %s%s: %s", projectNamespacePrefix, project.GetName(), message))
+	}
+
+	if department, _, _ := unstructured.NestedString(project.Object, "spec", "department"); department != "" {
+		_, err := client.GetDynamicClient().Resource(scheduling.DepartmentResource).Get(department, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			problems = append(problems, fmt.Sprintf("department: %v does not exist", department))
+		} else if err != nil {
+			return fmt.Errorf("Failed to get department: %v, error: %w", department, err)
+		}
+	}
+
+	nodeTypes, err := clusterNodeTypes(client)
+	if err != nil {
+		return err
+	}
+	for _, field := range []string{"nodeAffinityTrain", "nodeAffinityInteractive"} {
+		values, _, _ := unstructured.NestedStringSlice(project.Object, "spec", field)
+		for _, value := range values {
+			for _, message := range validation.IsValidLabelValue(value) {
+				problems = append(problems, fmt.Sprintf("invalid node type: %v, %s", value, message))
+			}
+			if !nodeTypes[value] {
+				log.Warnf("No node is labeled with %s=%s, %s of project: %v will not match any node", nodeTypeLabel, value, field, project.GetName())
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return commandUtil.Validationf("Invalid project:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// checkProjectQuota validates the quota hierarchy with the created or updated project
+func checkProjectQuota(client *client.Client, project *unstructured.Unstructured, force bool) error {
+	return scheduling.CheckQuotaHierarchy(client, func(hierarchy *scheduling.QuotaHierarchy) { hierarchy.SetProject(*project) }, force)
+}
+
+func clusterNodeTypes(client *client.Client) (map[string]bool, error) {
+	nodes, err := client.GetClientset().CoreV1().Nodes().List(metav1.ListOptions{LabelSelector: nodeTypeLabel})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list nodes in cluster, error: %w", err)
+	}
+	nodeTypes := map[string]bool{}
+	for _, node := range nodes.Items {
+		nodeTypes[node.Labels[nodeTypeLabel]] = true
+	}
+	return nodeTypes, nil
+}
+
+// warnIfOverCommitted warns when the projects are guaranteed more GPUs than the cluster has
+func warnIfOverCommitted(client *client.Client) {
+	projects, err := client.GetDynamicClient().Resource(scheduling.ProjectResource).List(metav1.ListOptions{})
+	if err != nil {
+		log.Debugf("Failed to list projects, error: %v", err)
+		return
+	}
+	clusterGpus, err := scheduling.ClusterGpus(client)
+	if err != nil {
+		log.Debug(err)
+		return
+	}
+	deservedGpus := float64(0)
+	for _, project := range projects.Items {
+		deservedGpus += scheduling.NestedFloat(project.Object, "spec", "deservedGpus")
+	}
+	if deservedGpus > clusterGpus {
+		log.Warnf("The projects are guaranteed %s GPUs in total but the cluster has only %s", scheduling.FormatGpus(deservedGpus), scheduling.FormatGpus(clusterGpus))
+	}
+}
+
+func listCommand() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "list",
+		Short: "List the projects and their GPU allocation",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			projects, err := client.GetDynamicClient().Resource(scheduling.ProjectResource).List(metav1.ListOptions{})
+			if err != nil {
+				return fmt.Errorf("Failed to list projects, error: %w", err)
+			}
+			allocated, err := scheduling.AllocatedGpusByQueue(client)
+			if err != nil {
+				return err
+			}
+
+			sort.Slice(projects.Items, func(i, j int) bool { return projects.Items[i].GetName() < projects.Items[j].GetName() })
+			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(writer, "NAME\tDEPARTMENT\tDESERVED GPUs\tALLOCATED GPUs\tOVER QUOTA\tINTERACTIVE TIME LIMIT")
+			for _, project := range projects.Items {
+				department, _, _ := unstructured.NestedString(project.Object, "spec", "department")
+				fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", project.GetName(), util.ValueOrDash(department),
+					scheduling.FormatGpus(scheduling.NestedFloat(project.Object, "spec", "deservedGpus")),
+					scheduling.FormatGpus(allocated[project.GetName()]),
+					overQuotaPolicy(project), interactiveTimeLimit(project))
+			}
+			writer.Flush()
+			return nil
+		},
+	}
+	return command
+}
+
+func describeCommand() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "describe PROJECT_NAME",
+		Short: "Show the details of a project and the GPU allocation of its PodGroups",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			project, err := client.GetDynamicClient().Resource(scheduling.ProjectResource).Get(args[0], metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return commandUtil.Validationf("Project: %v does not exist", args[0])
+			}
+			if err != nil {
+				return fmt.Errorf("Failed to get project: %v, error: %w", args[0], err)
+			}
+			if err := describeProject(client, project); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+	return command
+}
+
+func describeProject(client *client.Client, project *unstructured.Unstructured) error {
+	podGroups, err := scheduling.ListPodGroups(client)
+	if err != nil {
+		return err
+	}
+	allocatedByPodGroup, err := scheduling.AllocatedGpusByPodGroup(client)
+	if err != nil {
+		return err
+	}
+
+	var projectPodGroups []unstructured.Unstructured
+	allocated := float64(0)
+	for _, podGroup := range podGroups {
+		if scheduling.PodGroupQueue(podGroup) == project.GetName() {
+			projectPodGroups = append(projectPodGroups, podGroup)
+			allocated += allocatedByPodGroup[scheduling.PodGroupKey(podGroup.GetNamespace(), podGroup.GetName())]
+		}
+	}
+
+	department, _, _ := unstructured.NestedString(project.Object, "spec", "department")
+	train, _, _ := unstructured.NestedStringSlice(project.Object, "spec", "nodeAffinityTrain")
+	interactive, _, _ := unstructured.NestedStringSlice(project.Object, "spec", "nodeAffinityInteractive")
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "Name:\t%s\n", project.GetName())
+	fmt.Fprintf(writer, "Namespace:\t%s%s\n", projectNamespacePrefix, project.GetName())
+	fmt.Fprintf(writer, "Department:\t%s\n", util.ValueOrDash(department))
+	fmt.Fprintf(writer, "Deserved GPUs:\t%s\n", scheduling.FormatGpus(scheduling.NestedFloat(project.Object, "spec", "deservedGpus")))
+	fmt.Fprintf(writer, "Allocated GPUs:\t%s\n", scheduling.FormatGpus(allocated))
+	fmt.Fprintf(writer, "Over Quota:\t%s\n", overQuotaPolicy(*project))
+	fmt.Fprintf(writer, "Interactive Time Limit:\t%s\n", interactiveTimeLimit(*project))
+	fmt.Fprintf(writer, "Node Affinity Train:\t%s\n", util.ValueOrDash(strings.Join(train, ", ")))
+	fmt.Fprintf(writer, "Node Affinity Interactive:\t%s\n", util.ValueOrDash(strings.Join(interactive, ", ")))
+	writer.Flush()
+
+	fmt.Println()
+	if len(projectPodGroups) == 0 {
+		fmt.Println("No PodGroups")
+		return nil
+	}
+	sort.Slice(projectPodGroups, func(i, j int) bool { return projectPodGroups[i].GetName() < projectPodGroups[j].GetName() })
+	writer = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "PODGROUP\tNAMESPACE\tRUNNING\tSUCCEEDED\tFAILED\tALLOCATED GPUs")
+	for _, podGroup := range projectPodGroups {
+		fmt.Fprintf(writer, "%s\t%s\t%v\t%v\t%v\t%s\n", podGroup.GetName(), podGroup.GetNamespace(),
+			scheduling.NestedFloat(podGroup.Object, "status", "running"),
+			scheduling.NestedFloat(podGroup.Object, "status", "succeeded"),
+			scheduling.NestedFloat(podGroup.Object, "status", "failed"),
+			scheduling.FormatGpus(allocatedByPodGroup[scheduling.PodGroupKey(podGroup.GetNamespace(), podGroup.GetName())]))
+	}
+	writer.Flush()
+	return nil
+}
+
+func deleteCommand() *cobra.Command {
+	force := false
+	var command = &cobra.Command{
+		Use:   "delete PROJECT_NAME...",
+		Short: "Delete projects",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			allocated, err := scheduling.AllocatedGpusByQueue(client)
+			if err != nil {
+				return err
+			}
+
+			failed := 0
+			for _, name := range args {
+				if allocated[name] > 0 && !force {
+					fmt.Printf("Project: %v has workloads that are allocated %s GPUs, use --force to delete it anyway\n", name, scheduling.FormatGpus(allocated[name]))
+					failed++
+					continue
+				}
+				err := retry.Mutation(fmt.Sprintf("delete project %v", name), func() error {
+					return client.GetDynamicClient().Resource(scheduling.ProjectResource).Delete(name, &metav1.DeleteOptions{})
+				})
+				if errors.IsNotFound(err) {
+					log.Infof("Project: %v does not exist", name)
+					continue
+				}
+				if err != nil {
+					fmt.Printf("Failed to delete project: %v, error: %v\n", name, err)
+					failed++
+					continue
+				}
+				fmt.Printf("Successfully deleted project: %v\n", name)
+			}
+			if failed > 0 {
+				return fmt.Errorf("Failed to delete %d of %d projects", failed, len(args))
+			}
+			return nil
+		},
+	}
+
+	command.Flags().BoolVar(&force, "force", false, "Delete projects that have workloads with allocated GPUs.")
+	return command
+}
+
+func moveCommand() *cobra.Command {
+	department := ""
+	force := false
+	var command = &cobra.Command{
+		Use:   "move PROJECT_NAME... --department DEPARTMENT_NAME",
+		Short: "Move projects to another department",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if department == "" {
+				cmd.HelpFunc()(cmd, args)
+				return commandUtil.Validationf("No department was provided")
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			_, err = client.GetDynamicClient().Resource(scheduling.DepartmentResource).Get(department, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return commandUtil.Validationf("Department: %v does not exist", department)
+			}
+			if err != nil {
+				return fmt.Errorf("Failed to get department: %v, error: %w", department, err)
+			}
+
+			// the quota hierarchy is validated with all the projects moved, so that moving several projects
+			// together is not refused because of the projects that were not moved yet
+			movedProjects := map[string]bool{}
+			for _, name := range args {
+				movedProjects[name] = true
+			}
+			err = scheduling.CheckQuotaHierarchy(client, func(hierarchy *scheduling.QuotaHierarchy) {
+				for _, project := range hierarchy.Projects {
+					if movedProjects[project.GetName()] {
+						unstructured.SetNestedField(project.Object, department, "spec", "department")
+					}
+				}
+			}, force)
+			if err != nil {
+				return err
+			}
+
+			for _, name := range args {
+				err := updateProject(client, name, func(project *unstructured.Unstructured) error {
+					return unstructured.SetNestedField(project.Object, department, "spec", "department")
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Successfully moved project: %v to department: %v\n", name, department)
+			}
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&department, "department", "", "Department to move the projects to.")
+	addForceFlag(command, &force)
+	return command
+}
+
+func overQuotaPolicy(project unstructured.Unstructured) string {
+	weight, found, err := unstructured.NestedInt64(project.Object, "spec", "gpuOverQuotaWeight")
+	if !found || err != nil {
+		return "-"
+	}
+	for policy, policyWeight := range overQuotaPolicies {
+		if policyWeight == weight {
+			return policy
+		}
+	}
+	return fmt.Sprint(weight)
+}
+
+func interactiveTimeLimit(project unstructured.Unstructured) string {
+	seconds, found, err := unstructured.NestedInt64(project.Object, "spec", "interactiveJobTimeLimitSecs")
+	if !found || err != nil || seconds == 0 {
+		return "-"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
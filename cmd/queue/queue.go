@@ -0,0 +1,321 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/scheduling"
+	"github.com/run-ai/runai-cli/pkg/util"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	podGroupPending   = "Pending"
+	podGroupRunning   = "Running"
+	podGroupCompleted = "Completed"
+)
+
+var (
+	// nonPreemptiblePriorityClasses are the priority classes of workloads that are assumed not to be preempted to
+	// reclaim GPUs for other queues, e.g. interactive (build) jobs. Neither the PodGroup nor its PriorityClass tells
+	// whether the scheduler preempts it, so the PREEMPTIBLE column is a guess from the name of the priority class
+	nonPreemptiblePriorityClasses = map[string]bool{"build": true, "inference": true}
+)
+
+// podGroupInfo is a PodGroup joined with its pods and its queue
+type podGroupInfo struct {
+	PodGroup      unstructured.Unstructured
+	Phase         string
+	AllocatedGpus float64
+	PendingPods   []v1.Pod
+	OverQuota     bool
+	// Preemptible is guessed from the priority class, see nonPreemptiblePriorityClasses
+	Preemptible   bool
+	PriorityClass string
+	Conditions    []string
+	Age           string
+}
+
+// queueInfo is a Queue with the PodGroups that were submitted to it
+type queueInfo struct {
+	Queue         unstructured.Unstructured
+	DeservedGpus  float64
+	AllocatedGpus float64
+	PodGroups     []*podGroupInfo
+}
+
+func GetQueues() *cobra.Command {
+	var command = &cobra.Command{
+		Use:     "queues",
+		Aliases: []string{"queue"},
+		Short:   "Show the deserved and allocated GPUs of the queues",
+		Args:    cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			queues, err := getQueuesInfo(client)
+			if err != nil {
+				return err
+			}
+
+			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(writer, "NAME\tDESERVED GPUs\tALLOCATED GPUs\tOVER QUOTA\tRUNNING\tPENDING")
+			for _, queue := range queues {
+				running, pending := 0, 0
+				for _, podGroup := range queue.PodGroups {
+					switch podGroup.Phase {
+					case podGroupRunning:
+						running++
+					case podGroupPending:
+						pending++
+					}
+				}
+				fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%d\n", queue.Queue.GetName(), scheduling.FormatGpus(queue.DeservedGpus),
+					scheduling.FormatGpus(queue.AllocatedGpus), yesNo(queue.AllocatedGpus > queue.DeservedGpus), running, pending)
+			}
+			writer.Flush()
+			return nil
+		},
+	}
+	return command
+}
+
+func GetPodGroups() *cobra.Command {
+	queueName := ""
+	onlyPending := false
+	var command = &cobra.Command{
+		Use:     "podgroups",
+		Aliases: []string{"podgroup", "pg"},
+		Short:   "Show the PodGroups, and why the pending PodGroups are pending",
+		Args:    cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			queues, err := getQueuesInfo(client)
+			if err != nil {
+				return err
+			}
+
+			var podGroups []*podGroupInfo
+			for _, queue := range queues {
+				if queueName != "" && queue.Queue.GetName() != queueName {
+					continue
+				}
+				for _, podGroup := range queue.PodGroups {
+					if !onlyPending || podGroup.Phase == podGroupPending {
+						podGroups = append(podGroups, podGroup)
+					}
+				}
+			}
+			printPodGroups(podGroups)
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&queueName, "queue", "", "Show only the PodGroups of this queue (the name of the project).")
+	command.Flags().BoolVar(&onlyPending, "pending", false, "Show only the pending PodGroups.")
+	return command
+}
+
+func printPodGroups(podGroups []*podGroupInfo) {
+	if len(podGroups) == 0 {
+		fmt.Println("No PodGroups found")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tNAMESPACE\tQUEUE\tPHASE\tMIN MEMBER\tRUNNING\tALLOCATED GPUs\tPRIORITY CLASS\tOVER QUOTA\tPREEMPTIBLE*\tAGE")
+	for _, podGroup := range podGroups {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%v\t%v\t%s\t%s\t%s\t%s\t%s\n", podGroup.PodGroup.GetName(), podGroup.PodGroup.GetNamespace(),
+			scheduling.PodGroupQueue(podGroup.PodGroup), podGroup.Phase,
+			scheduling.NestedFloat(podGroup.PodGroup.Object, "spec", "minMember"),
+			scheduling.NestedFloat(podGroup.PodGroup.Object, "status", "running"),
+			scheduling.FormatGpus(podGroup.AllocatedGpus), util.ValueOrDash(podGroup.PriorityClass),
+			yesNo(podGroup.OverQuota), yesNo(podGroup.Preemptible), podGroup.Age)
+	}
+	writer.Flush()
+	fmt.Println("* guessed from the priority class, PodGroups of the build and inference priority classes are assumed not to be preempted")
+
+	var pendingPodGroups []*podGroupInfo
+	for _, podGroup := range podGroups {
+		if podGroup.Phase == podGroupPending {
+			pendingPodGroups = append(pendingPodGroups, podGroup)
+		}
+	}
+	if len(pendingPodGroups) == 0 {
+		return
+	}
+	fmt.Println("\nPending PodGroups:")
+	for _, podGroup := range pendingPodGroups {
+		fmt.Printf("  %s/%s (pending for %s, %d pending pods):\n", podGroup.PodGroup.GetNamespace(), podGroup.PodGroup.GetName(), podGroup.Age, len(podGroup.PendingPods))
+		if len(podGroup.Conditions) == 0 {
+			fmt.Println("    - no conditions were reported by the scheduler")
+		}
+		for _, condition := range podGroup.Conditions {
+			fmt.Printf("    - %s\n", condition)
+		}
+	}
+}
+
+// getQueuesInfo joins the queues with their PodGroups and the pods of the PodGroups
+func getQueuesInfo(client *client.Client) ([]*queueInfo, error) {
+	queueList, err := client.GetDynamicClient().Resource(scheduling.QueueResource).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list queues, error: %w", err)
+	}
+	podGroups, err := scheduling.ListPodGroups(client)
+	if err != nil {
+		return nil, err
+	}
+	allocatedByPodGroup, err := scheduling.AllocatedGpusByPodGroup(client)
+	if err != nil {
+		return nil, err
+	}
+	pendingPods, err := pendingPodsByPodGroup(client)
+	if err != nil {
+		return nil, err
+	}
+
+	queuesByName := map[string]*queueInfo{}
+	var queues []*queueInfo
+	for _, queue := range queueList.Items {
+		info := &queueInfo{Queue: queue, DeservedGpus: scheduling.NestedFloat(queue.Object, "spec", "deservedGpus")}
+		queuesByName[queue.GetName()] = info
+		queues = append(queues, info)
+	}
+
+	for _, podGroup := range podGroups {
+		queueName := scheduling.PodGroupQueue(podGroup)
+		queue, found := queuesByName[queueName]
+		if !found {
+			// PodGroups of a queue that was deleted are still shown
+			queue = &queueInfo{Queue: unstructured.Unstructured{Object: map[string]interface{}{}}}
+			queue.Queue.SetName(queueName)
+			queuesByName[queueName] = queue
+			queues = append(queues, queue)
+		}
+
+		key := scheduling.PodGroupKey(podGroup.GetNamespace(), podGroup.GetName())
+		priorityClass, _, _ := unstructured.NestedString(podGroup.Object, "spec", "priorityClassName")
+		info := &podGroupInfo{
+			PodGroup:      podGroup,
+			Phase:         podGroupPhase(podGroup),
+			AllocatedGpus: allocatedByPodGroup[key],
+			PendingPods:   pendingPods[key],
+			Preemptible:   !nonPreemptiblePriorityClasses[priorityClass],
+			PriorityClass: priorityClass,
+			Age:           util.ShortHumanDuration(time.Since(podGroup.GetCreationTimestamp().Time)),
+		}
+		info.Conditions = podGroupConditions(podGroup, info.PendingPods)
+		queue.AllocatedGpus += info.AllocatedGpus
+		queue.PodGroups = append(queue.PodGroups, info)
+	}
+
+	for _, queue := range queues {
+		markOverQuotaPodGroups(queue)
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Queue.GetName() < queues[j].Queue.GetName() })
+	return queues, nil
+}
+
+// markOverQuotaPodGroups marks the PodGroups that use GPUs beyond the deserved GPUs of their queue. The oldest
+// PodGroups are considered to be within the quota, and the newer ones that exceed it are over quota
+func markOverQuotaPodGroups(queue *queueInfo) {
+	sort.Slice(queue.PodGroups, func(i, j int) bool {
+		return queue.PodGroups[i].PodGroup.GetCreationTimestamp().Time.Before(queue.PodGroups[j].PodGroup.GetCreationTimestamp().Time)
+	})
+	allocated := float64(0)
+	for _, podGroup := range queue.PodGroups {
+		if podGroup.AllocatedGpus == 0 {
+			continue
+		}
+		allocated += podGroup.AllocatedGpus
+		podGroup.OverQuota = allocated > queue.DeservedGpus
+	}
+}
+
+func podGroupPhase(podGroup unstructured.Unstructured) string {
+	if phase, _, _ := unstructured.NestedString(podGroup.Object, "status", "phase"); phase != "" {
+		return phase
+	}
+	if scheduling.NestedFloat(podGroup.Object, "status", "running") > 0 {
+		return podGroupRunning
+	}
+	if scheduling.NestedFloat(podGroup.Object, "status", "succeeded") > 0 || scheduling.NestedFloat(podGroup.Object, "status", "failed") > 0 {
+		return podGroupCompleted
+	}
+	return podGroupPending
+}
+
+// podGroupConditions returns the conditions reported by the scheduler on the PodGroup, or the reasons
+// the pending pods of the PodGroup were not scheduled
+func podGroupConditions(podGroup unstructured.Unstructured, pendingPods []v1.Pod) []string {
+	var conditions []string
+	values, _, _ := unstructured.NestedSlice(podGroup.Object, "status", "conditions")
+	for _, value := range values {
+		condition, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var parts []string
+		for _, field := range []string{"type", "reason", "message"} {
+			if text, ok := condition[field].(string); ok && text != "" {
+				parts = append(parts, text)
+			}
+		}
+		if len(parts) > 0 {
+			conditions = append(conditions, strings.Join(parts, ": "))
+		}
+	}
+	if len(conditions) > 0 {
+		return conditions
+	}
+
+	reasons := map[string]bool{}
+	for _, pod := range pendingPods {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse && condition.Message != "" {
+				reason := fmt.Sprintf("%s: %s", condition.Reason, condition.Message)
+				if !reasons[reason] {
+					reasons[reason] = true
+					conditions = append(conditions, reason)
+				}
+			}
+		}
+	}
+	return conditions
+}
+
+func pendingPodsByPodGroup(client *client.Client) (map[string][]v1.Pod, error) {
+	pods, err := client.GetClientset().CoreV1().Pods("").List(metav1.ListOptions{FieldSelector: "status.phase=Pending"})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list pods, error: %w", err)
+	}
+	pendingPods := map[string][]v1.Pod{}
+	for _, pod := range pods.Items {
+		if podGroupName := scheduling.PodGroupName(pod); podGroupName != "" {
+			key := scheduling.PodGroupKey(pod.Namespace, podGroupName)
+			pendingPods[key] = append(pendingPods[key], pod)
+		}
+	}
+	return pendingPods, nil
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
@@ -15,48 +15,153 @@
 package root
 
 import (
+	"strings"
+
+	"github.com/run-ai/runai-cli/cmd/apply"
+	"github.com/run-ai/runai-cli/cmd/clusterconfig"
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/cmd/controller"
+	"github.com/run-ai/runai-cli/cmd/create"
+	"github.com/run-ai/runai-cli/cmd/department"
 	getversion "github.com/run-ai/runai-cli/cmd/get"
 	"github.com/run-ai/runai-cli/cmd/install"
+	"github.com/run-ai/runai-cli/cmd/project"
 	"github.com/run-ai/runai-cli/cmd/remove"
 	"github.com/run-ai/runai-cli/cmd/set"
+	"github.com/run-ai/runai-cli/cmd/template"
 	"github.com/run-ai/runai-cli/cmd/uninstall"
 	"github.com/run-ai/runai-cli/cmd/update"
 	"github.com/run-ai/runai-cli/cmd/upgrade"
 	"github.com/run-ai/runai-cli/cmd/version"
 
+	"github.com/run-ai/runai-cli/pkg/client"
 	"github.com/run-ai/runai-cli/pkg/config"
 	"github.com/run-ai/runai-cli/pkg/util"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/lock"
+	"github.com/run-ai/runai-cli/pkg/util/patch"
 	"github.com/spf13/cobra"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 )
 
-var LogLevel string
+var (
+	LogLevel  string
+	StealLock bool
+
+	// lockedCommands are the commands that hold the admin lock while they run, by their path under the root command,
+	// because they change the Run:AI operators, the RunaiConfig, the nodes or the objects that admins share. Their
+	// read-only subcommands, e.g. upgrade status, template list and project list, run without the lock
+	lockedCommands = map[string]bool{
+		"install":              true,
+		"upgrade":              true,
+		"uninstall":            true,
+		"set node-role":        true,
+		"remove node-role":     true,
+		"apply node-roles":     true,
+		"create secret":        true,
+		"set secret":           true,
+		"remove secret":        true,
+		"project create":       true,
+		"project update":       true,
+		"project move":         true,
+		"project delete":       true,
+		"department create":    true,
+		"department update":    true,
+		"department delete":    true,
+		"template create":      true,
+		"template edit":        true,
+		"template set-default": true,
+		"template delete":      true,
+		"cluster-config set":   true,
+		"cluster-config unset": true,
+	}
+
+	// readOnlyFlags are the flags that make a locked command only print what it would change, by its path under the
+	// root command. A locked command that is given all the flags of one of its sets runs without the lock, e.g. the
+	// --dry-run of set node-role only applies to --auto, and the command changes the nodes without it
+	readOnlyFlags = map[string][][]string{
+		"upgrade":              {{"plan"}},
+		"set node-role":        {{"auto", "dry-run"}},
+		"apply node-roles":     {{"dry-run"}},
+		"template create":      {{"dry-run"}},
+		"template edit":        {{"dry-run"}},
+		"template set-default": {{"dry-run"}},
+		"template delete":      {{"dry-run"}},
+	}
+)
 
 // NewCommand returns a new instance of an Arena command
 func NewCommand() *cobra.Command {
 	var command = &cobra.Command{
 		Use:   config.CLIName,
 		Short: "runai-adm is a command line interface to a RunAI cluster",
-		Run: func(cmd *cobra.Command, args []string) {
-			cmd.HelpFunc()(cmd, args)
+		Long:  "runai-adm is a command line interface to a RunAI cluster\n\n" + commandUtil.ExitCodesHelp,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
 		},
+		// the errors are printed by main, with the exit code of their kind
+		SilenceErrors: true,
+		SilenceUsage:  true,
 		// Would be run before any child command
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			util.SetLogLevel(LogLevel)
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := util.SetLogLevel(LogLevel); err != nil {
+				return err
+			}
+			if needsLock(cmd) {
+				client, err := client.GetClient()
+				if err != nil {
+					return err
+				}
+				return lock.Acquire(client, common.RunaiNamespace, strings.Join(append([]string{cmd.CommandPath()}, args...), " "), StealLock)
+			}
+			return nil
 		},
 	}
 
 	// enable logging
 	command.PersistentFlags().StringVar(&LogLevel, "loglevel", "info", "Set the logging level. One of: debug|info|warn|error")
+	command.PersistentFlags().BoolVar(&StealLock, "steal-lock", false, "Take the admin lock of the cluster even if another command holds it, e.g. when that command was killed.")
+	command.PersistentFlags().BoolVar(&patch.ServerSideApply, "server-side-apply", false, "Change the labels, affinity and replicas of Kubernetes objects with a server-side apply by the runai-adm field manager, which fails on a conflict instead of overriding the changes of other controllers.")
 
+	command.AddCommand(create.Command())
 	command.AddCommand(set.Command())
 	command.AddCommand(remove.Command())
+	command.AddCommand(apply.Command())
 	command.AddCommand(upgrade.Command())
 	command.AddCommand(version.Command())
 	command.AddCommand(update.Command())
 	command.AddCommand(getversion.Command())
 	command.AddCommand(install.Command())
 	command.AddCommand(uninstall.Command())
+	command.AddCommand(controller.Command())
+	command.AddCommand(project.Command())
+	command.AddCommand(department.Command())
+	command.AddCommand(template.Command())
+	command.AddCommand(clusterconfig.Command())
 
+	commandUtil.ClassifyUsageErrors(command)
 	return command
 }
+
+// needsLock returns whether the command holds the admin lock of the cluster while it runs
+func needsLock(cmd *cobra.Command) bool {
+	path := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	if !lockedCommands[path] {
+		return false
+	}
+	for _, flags := range readOnlyFlags[path] {
+		if allFlagsSet(cmd, flags) {
+			return false
+		}
+	}
+	return true
+}
+
+func allFlagsSet(cmd *cobra.Command, names []string) bool {
+	for _, name := range names {
+		if flag := cmd.Flags().Lookup(name); flag == nil || !flag.Changed || flag.Value.String() != "true" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,255 @@
+package secret
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type createSecretOptions struct {
+	ClusterWide    bool
+	FromFiles      []string
+	FromLiterals   []string
+	DockerServer   string
+	DockerUsername string
+	DockerPassword string
+	DockerEmail    string
+	FromNamespace  string
+}
+
+func Create() *cobra.Command {
+	options := createSecretOptions{}
+	var command = &cobra.Command{
+		Use:     "secret SECRET_NAME",
+		Aliases: []string{"secrets"},
+		Short:   "Create or update a Secret in the runai namespace",
+		Long: `Create or update a Secret in the runai namespace from files, literals, Docker registry credentials or a Secret in another namespace.
+With --cluster-wide the Secret is labeled to be available in all the Run:AI projects.`,
+		Example: `  runai-adm create secret regcred --cluster-wide --docker-server=registry.example.com --docker-username=user --docker-password=pass
+  runai-adm create secret credentials --cluster-wide --from-literal=user=admin --from-file=key=./key.pem
+  runai-adm create secret regcred --cluster-wide --from-namespace=default`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			secret, err := buildSecret(args[0], options)
+			if err != nil {
+				return err
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			if options.FromNamespace != "" {
+				if err := copySecretData(client, secret, options.FromNamespace); err != nil {
+					return err
+				}
+			}
+			setClusterWideLabel(secret, options.ClusterWide)
+			created, err := createOrUpdateSecret(client, secret)
+			if err != nil {
+				return err
+			}
+			if created {
+				fmt.Printf("Successfully created secret: %v\n", secret.Name)
+			} else {
+				fmt.Printf("Successfully updated secret: %v\n", secret.Name)
+			}
+			return nil
+		},
+	}
+
+	command.Flags().BoolVar(&options.ClusterWide, "cluster-wide", false, "set Secret as cluster wide")
+	command.Flags().StringArrayVar(&options.FromFiles, "from-file", nil, "Key files, specified as [key=]path. A directory adds each of its regular files. Can be repeated.")
+	command.Flags().StringArrayVar(&options.FromLiterals, "from-literal", nil, "Key and literal value, specified as key=value. Can be repeated.")
+	command.Flags().StringVar(&options.DockerServer, "docker-server", "", "Server of the Docker registry.")
+	command.Flags().StringVar(&options.DockerUsername, "docker-username", "", "Username of the Docker registry.")
+	command.Flags().StringVar(&options.DockerPassword, "docker-password", "", "Password of the Docker registry.")
+	command.Flags().StringVar(&options.DockerEmail, "docker-email", "", "Email of the Docker registry.")
+	command.Flags().StringVar(&options.FromNamespace, "from-namespace", "", "Copy the Secret with the same name from this namespace.")
+	return command
+}
+
+// buildSecret builds the Secret from exactly one kind of source. The data of a Secret copied from another
+// namespace is filled by copySecretData
+func buildSecret(name string, options createSecretOptions) (*v1.Secret, error) {
+	isGeneric := len(options.FromFiles) > 0 || len(options.FromLiterals) > 0
+	isDocker := options.DockerServer != "" || options.DockerUsername != "" || options.DockerPassword != ""
+	isCopy := options.FromNamespace != ""
+	sources := 0
+	for _, isSource := range []bool{isGeneric, isDocker, isCopy} {
+		if isSource {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, commandUtil.Validationf("Exactly one source must be provided: --from-file/--from-literal, --docker-server/--docker-username/--docker-password or --from-namespace")
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: common.RunaiNamespace},
+		Type:       v1.SecretTypeOpaque,
+		Data:       map[string][]byte{},
+	}
+	switch {
+	case isGeneric:
+		for _, fromFile := range options.FromFiles {
+			if err := addFileData(secret.Data, fromFile); err != nil {
+				return nil, err
+			}
+		}
+		for _, fromLiteral := range options.FromLiterals {
+			parts := strings.SplitN(fromLiteral, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, commandUtil.Validationf("Invalid literal: %v, must be specified as key=value", fromLiteral)
+			}
+			if err := addKey(secret.Data, parts[0], []byte(parts[1])); err != nil {
+				return nil, err
+			}
+		}
+	case isDocker:
+		if options.DockerServer == "" || options.DockerUsername == "" || options.DockerPassword == "" {
+			return nil, commandUtil.Validationf("--docker-server, --docker-username and --docker-password must all be provided")
+		}
+		dockerConfig, err := dockerConfigJson(options)
+		if err != nil {
+			return nil, err
+		}
+		secret.Type = v1.SecretTypeDockerConfigJson
+		secret.Data[v1.DockerConfigJsonKey] = dockerConfig
+	}
+	return secret, nil
+}
+
+func addFileData(data map[string][]byte, fromFile string) error {
+	key, path := "", fromFile
+	if parts := strings.SplitN(fromFile, "=", 2); len(parts) == 2 {
+		key, path = parts[0], parts[1]
+		if key == "" {
+			return commandUtil.Validationf("Invalid file: %v, must be specified as [key=]path", fromFile)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read file: %v, error: %w", path, err)
+	}
+	if !info.IsDir() {
+		if key == "" {
+			key = filepath.Base(path)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Failed to read file: %v, error: %w", path, err)
+		}
+		return addKey(data, key, content)
+	}
+
+	if key != "" {
+		return commandUtil.Validationf("A key cannot be specified for the directory: %v", path)
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read directory: %v, error: %w", path, err)
+	}
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(path, file.Name()))
+		if err != nil {
+			return fmt.Errorf("Failed to read file: %v, error: %w", file.Name(), err)
+		}
+		if err := addKey(data, file.Name(), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addKey(data map[string][]byte, key string, value []byte) error {
+	if _, found := data[key]; found {
+		return commandUtil.Validationf("The key: %v was provided more than once", key)
+	}
+	data[key] = value
+	return nil
+}
+
+func dockerConfigJson(options createSecretOptions) ([]byte, error) {
+	type dockerConfigEntry struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Email    string `json:"email,omitempty"`
+		Auth     string `json:"auth"`
+	}
+	dockerConfig := map[string]map[string]dockerConfigEntry{
+		"auths": {
+			options.DockerServer: {
+				Username: options.DockerUsername,
+				Password: options.DockerPassword,
+				Email:    options.DockerEmail,
+				Auth:     base64.StdEncoding.EncodeToString([]byte(options.DockerUsername + ":" + options.DockerPassword)),
+			},
+		},
+	}
+	return json.Marshal(dockerConfig)
+}
+
+func copySecretData(client *client.Client, secret *v1.Secret, namespace string) error {
+	if namespace == common.RunaiNamespace {
+		return commandUtil.Validationf("The Secret cannot be copied from the %s namespace to itself", common.RunaiNamespace)
+	}
+	source, err := client.GetClientset().CoreV1().Secrets(namespace).Get(secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to get secret: %v from the %s namespace, error: %w", secret.Name, namespace, err)
+	}
+	secret.Type = source.Type
+	secret.Data = source.Data
+	return nil
+}
+
+// createOrUpdateSecret creates the Secret, or replaces the data of the existing Secret and keeps its other labels.
+// Returns whether the Secret was created
+func createOrUpdateSecret(client *client.Client, secret *v1.Secret) (bool, error) {
+	secrets := client.GetClientset().CoreV1().Secrets(common.RunaiNamespace)
+	created := false
+	err := retry.Mutation(fmt.Sprintf("create or update secret %s", secret.Name), func() error {
+		existing, err := secrets.Get(secret.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = secrets.Create(secret)
+			if errors.IsAlreadyExists(err) {
+				// created concurrently, the next attempt updates it
+				return errors.NewConflict(v1.Resource("secrets"), secret.Name, err)
+			}
+			created = err == nil
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if existing.Type != secret.Type {
+			return commandUtil.Conflict(fmt.Errorf("Secret: %v already exists with type: %v, which cannot be changed to: %v", secret.Name, existing.Type, secret.Type))
+		}
+		existing.Data = secret.Data
+		existing.StringData = nil
+		setClusterWideLabel(existing, secret.Labels[clusterWideSecretLabel] == "true")
+		_, err = secrets.Update(existing)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("Failed to create or update secret: %v, error: %w", secret.Name, err)
+	}
+	return created, nil
+}
@@ -2,12 +2,18 @@ package secret
 
 import (
 	"fmt"
-	"os"
+	"strings"
 
+	"github.com/run-ai/runai-cli/cmd/common"
 	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/patch"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type nodeRoleTypes struct {
@@ -18,6 +24,10 @@ const (
 	clusterWideSecretLabel = "runai/cluster-wide"
 )
 
+var (
+	secretsResource = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+)
+
 func Set() *cobra.Command {
 	flags := nodeRoleTypes{}
 	var command = &cobra.Command{
@@ -25,17 +35,22 @@ func Set() *cobra.Command {
 		Aliases: []string{"secrets"},
 		Short:   "Set Secret resource",
 		Args:    cobra.MinimumNArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if cmd.Flags().NFlag() == 0 {
-				fmt.Println("No flags were provided")
 				cmd.HelpFunc()(cmd, args)
-				os.Exit(1)
+				return commandUtil.Validationf("No flags were provided")
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
 			}
-			client := client.GetClient()
 			if flags.ClusterWide {
-				updateSecrets(client, args, true)
+				if err := updateSecrets(client, args, true); err != nil {
+					return err
+				}
 				fmt.Println("Successfully set cluster wide settings to secrets")
 			}
+			return nil
 		},
 	}
 
@@ -50,17 +65,22 @@ func Remove() *cobra.Command {
 		Aliases: []string{"secrets"},
 		Short:   "Remove Secret resource",
 		Args:    cobra.MinimumNArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if cmd.Flags().NFlag() == 0 {
-				fmt.Println("No flags were provided")
 				cmd.HelpFunc()(cmd, args)
-				os.Exit(1)
+				return commandUtil.Validationf("No flags were provided")
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
 			}
-			client := client.GetClient()
 			if flags.ClusterWide {
-				updateSecrets(client, args, false)
+				if err := updateSecrets(client, args, false); err != nil {
+					return err
+				}
 				fmt.Println("Successfully removed cluster wide settings from secrets")
 			}
+			return nil
 		},
 	}
 
@@ -68,30 +88,34 @@ func Remove() *cobra.Command {
 	return command
 }
 
-func updateSecrets(client *client.Client, args []string, shouldAddSecret bool) {
-	secretList, err := client.GetClientset().CoreV1().Secrets("runai").List(metav1.ListOptions{})
+func updateSecrets(client *client.Client, args []string, shouldAddSecret bool) error {
+	secretList, err := client.GetClientset().CoreV1().Secrets(common.RunaiNamespace).List(metav1.ListOptions{})
 	if err != nil {
-		fmt.Printf("Failed to list all secrets in the runai Namespace, error: %v", err)
-		os.Exit(1)
+		return fmt.Errorf("Failed to list all secrets in the %s Namespace, error: %w", common.RunaiNamespace, err)
 	}
 
 	secretsToUpdateMap := map[string]bool{}
 	for _, secretsToLabel := range args {
 		secretsToUpdateMap[secretsToLabel] = false
 	}
+	var failedSecrets []string
 	for _, secretInfo := range secretList.Items {
 		if _, found := secretsToUpdateMap[secretInfo.Name]; found {
-			if secretInfo.Labels == nil {
-				secretInfo.Labels = map[string]string{}
-			}
-			delete(secretInfo.Labels, clusterWideSecretLabel)
-			if shouldAddSecret {
-				secretInfo.Labels[clusterWideSecretLabel] = "true"
-			} else {
-				delete(secretInfo.Labels, clusterWideSecretLabel)
-			}
 			secretsToUpdateMap[secretInfo.Name] = true
-			_, err = client.GetClientset().CoreV1().Secrets("runai").Update(&secretInfo)
+			err := retry.Mutation(fmt.Sprintf("update secret %s", secretInfo.Name), func() error {
+				current, err := client.GetClientset().CoreV1().Secrets(common.RunaiNamespace).Get(secretInfo.Name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				secret := current.DeepCopy()
+				setClusterWideLabel(secret, shouldAddSecret)
+				return patch.Update(client.GetDynamicClient().Resource(secretsResource).Namespace(common.RunaiNamespace), current, secret,
+					[]string{"metadata", "labels", clusterWideSecretLabel})
+			})
+			if err != nil {
+				failedSecrets = append(failedSecrets, fmt.Sprintf("%v: %v", secretInfo.Name, err))
+				continue
+			}
 			log.Debugf("Updated secret: %v", secretInfo.Name)
 		}
 	}
@@ -101,4 +125,19 @@ func updateSecrets(client *client.Client, args []string, shouldAddSecret bool) {
 			log.Infof("Secret: %v does not exist", secretName)
 		}
 	}
+	if len(failedSecrets) > 0 {
+		return fmt.Errorf("Failed to update secrets:\n  - %s", strings.Join(failedSecrets, "\n  - "))
+	}
+	return nil
+}
+
+func setClusterWideLabel(secret *v1.Secret, clusterWide bool) {
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	if clusterWide {
+		secret.Labels[clusterWideSecretLabel] = "true"
+	} else {
+		delete(secret.Labels, clusterWideSecretLabel)
+	}
 }
@@ -24,8 +24,8 @@ func Command() *cobra.Command {
 	var command = &cobra.Command{
 		Use:   "set",
 		Short: "Set resources.",
-		Run: func(cmd *cobra.Command, args []string) {
-			cmd.HelpFunc()(cmd, args)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
 		},
 	}
 
@@ -0,0 +1,634 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/util"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// templateLabel marks the ConfigMaps in the runai namespace that are templates of the researcher CLI
+	templateLabel = "runai/template"
+	// defaultAnnotation marks the template that the researcher CLI uses when no template is given
+	defaultAnnotation = "runai/default"
+	// templateConfigMapPrefix is the prefix of the ConfigMaps of the templates created by this CLI
+	templateConfigMapPrefix = "cli-template-"
+
+	nameKey        = "name"
+	descriptionKey = "description"
+	valuesKey      = "values"
+)
+
+// cliTemplate is a template of the researcher CLI, stored in a ConfigMap
+type cliTemplate struct {
+	Name        string
+	Description string
+	Values      map[string]interface{}
+	Default     bool
+	ConfigMap   *v1.ConfigMap
+}
+
+// templateChange is a template before and after a change, Old is nil for a new template and New is nil for a deleted template
+type templateChange struct {
+	Old *cliTemplate
+	New *cliTemplate
+}
+
+type templateOptions struct {
+	Description string
+	ValuesFile  string
+	Set         []string
+	Unset       []string
+	Default     bool
+	DryRun      bool
+}
+
+func Command() *cobra.Command {
+	var command = &cobra.Command{
+		Use:     "template",
+		Aliases: []string{"templates"},
+		Short:   "Manage the templates of the researcher CLI.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	command.AddCommand(createCommand())
+	command.AddCommand(listCommand())
+	command.AddCommand(showCommand())
+	command.AddCommand(editCommand())
+	command.AddCommand(deleteCommand())
+	command.AddCommand(setDefaultCommand())
+
+	return command
+}
+
+func addValuesFlags(command *cobra.Command, options *templateOptions) {
+	command.Flags().StringVar(&options.Description, "description", "", "Description of the template.")
+	command.Flags().StringVar(&options.ValuesFile, "values-file", "", "YAML file with the values of the template, keyed by the researcher submit flags, e.g. 'gpu: 1'.")
+	command.Flags().StringArrayVar(&options.Set, "set", nil, "Set a value of the template, e.g. --set gpu=1 --set hostIPC=true --set volume=[/a:/a].")
+	command.Flags().BoolVar(&options.DryRun, "dry-run", false, "Only show the changes to the templates, without applying them.")
+}
+
+func createCommand() *cobra.Command {
+	options := templateOptions{}
+	var command = &cobra.Command{
+		Use:   "create TEMPLATE_NAME",
+		Short: "Create a template",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			configMapName := templateConfigMapPrefix + args[0]
+			if messages := validation.IsDNS1123Subdomain(configMapName); len(messages) > 0 {
+				return commandUtil.Validationf("Invalid template name: %v, %s", args[0], strings.Join(messages, ", "))
+			}
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			templates, err := listTemplates(client)
+			if err != nil {
+				return err
+			}
+			if findTemplate(templates, args[0]) != nil {
+				return commandUtil.Conflict(fmt.Errorf("Template: %v already exists, use 'template edit' to change it", args[0]))
+			}
+
+			template := &cliTemplate{
+				Name:        args[0],
+				Description: options.Description,
+				Values:      map[string]interface{}{},
+				ConfigMap:   &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: configMapName, Namespace: common.RunaiNamespace}},
+			}
+			if err := setTemplateValues(template, options); err != nil {
+				return err
+			}
+			changes := []templateChange{{New: template}}
+			if options.Default {
+				changes = setDefault(templates, template, changes)
+			}
+			if err := applyTemplateChanges(client, changes, options.DryRun); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	addValuesFlags(command, &options)
+	command.Flags().BoolVar(&options.Default, "default", false, "Make the template the default template of the researcher CLI.")
+	return command
+}
+
+func editCommand() *cobra.Command {
+	options := templateOptions{}
+	var command = &cobra.Command{
+		Use:   "edit TEMPLATE_NAME",
+		Short: "Edit the description and the values of a template, with an editor if no values are given",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			templates, err := listTemplates(client)
+			if err != nil {
+				return err
+			}
+			old := findTemplate(templates, args[0])
+			if old == nil {
+				return fmt.Errorf("Template: %v does not exist", args[0])
+			}
+
+			template := old.copy()
+			if cmd.Flags().Changed("description") {
+				template.Description = options.Description
+			}
+			if options.ValuesFile != "" || len(options.Set) > 0 || len(options.Unset) > 0 {
+				err = setTemplateValues(template, options)
+			} else if !cmd.Flags().Changed("description") {
+				err = editTemplateValues(template)
+			}
+			if err == nil {
+				err = applyTemplateChanges(client, []templateChange{{Old: old, New: template}}, options.DryRun)
+			}
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	addValuesFlags(command, &options)
+	command.Flags().StringArrayVar(&options.Unset, "unset", nil, "Remove a value from the template, e.g. --unset hostIPC.")
+	return command
+}
+
+func setDefaultCommand() *cobra.Command {
+	dryRun := false
+	var command = &cobra.Command{
+		Use:   "set-default TEMPLATE_NAME",
+		Short: "Make a template the default template of the researcher CLI",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			templates, err := listTemplates(client)
+			if err != nil {
+				return err
+			}
+			template := findTemplate(templates, args[0])
+			if template == nil {
+				return fmt.Errorf("Template: %v does not exist", args[0])
+			}
+			if err := applyTemplateChanges(client, setDefault(templates, template, nil), dryRun); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "Only show the changes to the templates, without applying them.")
+	return command
+}
+
+func deleteCommand() *cobra.Command {
+	dryRun := false
+	var command = &cobra.Command{
+		Use:   "delete TEMPLATE_NAME...",
+		Short: "Delete templates",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			templates, err := listTemplates(client)
+			if err != nil {
+				return err
+			}
+			var changes []templateChange
+			for _, name := range args {
+				template := findTemplate(templates, name)
+				if template == nil {
+					log.Infof("Template: %v does not exist", name)
+					continue
+				}
+				if template.Default {
+					log.Warnf("Template: %v is the default template, the researcher CLI will have no default template", name)
+				}
+				changes = append(changes, templateChange{Old: template})
+			}
+			if err := applyTemplateChanges(client, changes, dryRun); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "Only show the changes to the templates, without applying them.")
+	return command
+}
+
+func listCommand() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "list",
+		Short: "List the templates",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			templates, err := listTemplates(client)
+			if err != nil {
+				return err
+			}
+			if len(templates) == 0 {
+				fmt.Println("No templates found")
+				return nil
+			}
+
+			var defaults []string
+			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(writer, "NAME\tDESCRIPTION\tDEFAULT\tCONFIGMAP")
+			for _, template := range templates {
+				if template.Default {
+					defaults = append(defaults, template.Name)
+				}
+				fmt.Fprintf(writer, "%s\t%s\t%v\t%s\n", template.Name, util.ValueOrDash(template.Description), template.Default, template.ConfigMap.Name)
+			}
+			writer.Flush()
+			if len(defaults) > 1 {
+				log.Warnf("More than one template is marked as default: %s, use 'template set-default' to choose one", strings.Join(defaults, ", "))
+			}
+			return nil
+		},
+	}
+	return command
+}
+
+func showCommand() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "show TEMPLATE_NAME",
+		Short: "Show the description and the values of a template",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			templates, err := listTemplates(client)
+			if err != nil {
+				return err
+			}
+			template := findTemplate(templates, args[0])
+			if template == nil {
+				return fmt.Errorf("Template: %v does not exist", args[0])
+			}
+
+			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(writer, "Name:\t%s\n", template.Name)
+			fmt.Fprintf(writer, "Description:\t%s\n", util.ValueOrDash(template.Description))
+			fmt.Fprintf(writer, "Default:\t%v\n", template.Default)
+			fmt.Fprintf(writer, "ConfigMap:\t%s/%s\n", template.ConfigMap.Namespace, template.ConfigMap.Name)
+			writer.Flush()
+			fmt.Println("\nValues:")
+			if len(template.Values) == 0 {
+				fmt.Println("  -")
+			}
+			writer = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, key := range sortedKeys(template.Values) {
+				flag := "-"
+				if submit, found := submitValues[key]; found {
+					flag = submit.flag
+				}
+				fmt.Fprintf(writer, "  %s:\t%s\t(%s)\n", key, formatValue(template.Values[key]), flag)
+			}
+			writer.Flush()
+			return nil
+		},
+	}
+	return command
+}
+
+func listTemplates(client *client.Client) ([]*cliTemplate, error) {
+	configMaps, err := client.GetClientset().CoreV1().ConfigMaps(common.RunaiNamespace).List(metav1.ListOptions{LabelSelector: templateLabel + "=true"})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list templates, error: %w", err)
+	}
+	var templates []*cliTemplate
+	for i := range configMaps.Items {
+		template, err := templateFromConfigMap(&configMaps.Items[i])
+		if err != nil {
+			log.Warnf("Ignoring template ConfigMap: %v, error: %v", configMaps.Items[i].Name, err)
+			continue
+		}
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+func findTemplate(templates []*cliTemplate, name string) *cliTemplate {
+	for _, template := range templates {
+		if template.Name == name {
+			return template
+		}
+	}
+	return nil
+}
+
+// templateFromConfigMap reads a template, templates without a name in their data are named after their ConfigMap
+func templateFromConfigMap(configMap *v1.ConfigMap) (*cliTemplate, error) {
+	values, err := parseValues(configMap.Data[valuesKey])
+	if err != nil {
+		return nil, err
+	}
+	name := configMap.Data[nameKey]
+	if name == "" {
+		name = configMap.Name
+	}
+	return &cliTemplate{
+		Name:        name,
+		Description: configMap.Data[descriptionKey],
+		Values:      values,
+		Default:     configMap.Annotations[defaultAnnotation] == "true",
+		ConfigMap:   configMap,
+	}, nil
+}
+
+func (template *cliTemplate) copy() *cliTemplate {
+	return &cliTemplate{
+		Name:        template.Name,
+		Description: template.Description,
+		Values:      copyValues(template.Values),
+		Default:     template.Default,
+		ConfigMap:   template.ConfigMap,
+	}
+}
+
+// toConfigMap returns the ConfigMap of the template, keeping the values blob as is if the values did not change
+func (template *cliTemplate) toConfigMap(old *cliTemplate) (*v1.ConfigMap, error) {
+	configMap := template.ConfigMap.DeepCopy()
+	if configMap.Labels == nil {
+		configMap.Labels = map[string]string{}
+	}
+	configMap.Labels[templateLabel] = "true"
+	if template.Default {
+		if configMap.Annotations == nil {
+			configMap.Annotations = map[string]string{}
+		}
+		configMap.Annotations[defaultAnnotation] = "true"
+	} else {
+		delete(configMap.Annotations, defaultAnnotation)
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[nameKey] = template.Name
+	if template.Description != "" {
+		configMap.Data[descriptionKey] = template.Description
+	} else {
+		delete(configMap.Data, descriptionKey)
+	}
+	if old == nil || len(valuesDiff(old.Values, template.Values)) > 0 {
+		values, err := formatValues(template.Values)
+		if err != nil {
+			return nil, err
+		}
+		configMap.Data[valuesKey] = values
+	}
+	return configMap, nil
+}
+
+func setTemplateValues(template *cliTemplate, options templateOptions) error {
+	previousValues := copyValues(template.Values)
+	if options.ValuesFile != "" {
+		data, err := ioutil.ReadFile(options.ValuesFile)
+		if err != nil {
+			return fmt.Errorf("Failed to read values file: %v, error: %w", options.ValuesFile, err)
+		}
+		if template.Values, err = parseValues(string(data)); err != nil {
+			return err
+		}
+	}
+	values, err := parseSetValues(options.Set)
+	if err != nil {
+		return err
+	}
+	for key, value := range values {
+		template.Values[key] = value
+	}
+	for _, key := range options.Unset {
+		delete(template.Values, key)
+	}
+	return validateValues(template.Values, changedKeys(previousValues, template.Values))
+}
+
+// editTemplateValues opens the values of the template in the editor of $EDITOR
+func editTemplateValues(template *cliTemplate) error {
+	values, err := formatValues(template.Values)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile("", "runai-template-*.yaml")
+	if err != nil {
+		return fmt.Errorf("Failed to create a temporary file, error: %w", err)
+	}
+	defer os.Remove(file.Name())
+	header := fmt.Sprintf("# Values of template %s, the valid keys are:\n# %s\n", template.Name, strings.Join(submitValueKeys(), ", "))
+	_, err = file.WriteString(header + values)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("Failed to write a temporary file, error: %w", err)
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	command := exec.Command(editor[0], append(editor[1:], file.Name())...)
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("Failed to run the editor: %v, error: %w", strings.Join(editor, " "), err)
+	}
+
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return fmt.Errorf("Failed to read the edited values, error: %w", err)
+	}
+	previousValues := template.Values
+	if template.Values, err = parseValues(string(data)); err != nil {
+		return err
+	}
+	return validateValues(template.Values, changedKeys(previousValues, template.Values))
+}
+
+// setDefault adds the changes that make the template the only default template. The template is made the default
+// before the other templates are cleared, so that a failure between the changes leaves a default template
+func setDefault(templates []*cliTemplate, template *cliTemplate, changes []templateChange) []templateChange {
+	var cleared []templateChange
+	for _, other := range templates {
+		if other.Name != template.Name && other.Default {
+			updated := other.copy()
+			updated.Default = false
+			cleared = append(cleared, templateChange{Old: other, New: updated})
+		}
+	}
+	for i := range changes {
+		if changes[i].New != nil && changes[i].New.Name == template.Name {
+			changes[i].New.Default = true
+			return append(changes, cleared...)
+		}
+	}
+	updated := template.copy()
+	updated.Default = true
+	return append(append(changes, templateChange{Old: template, New: updated}), cleared...)
+}
+
+// applyTemplateChanges prints the changes to the templates, and applies them in order unless this is a dry run
+func applyTemplateChanges(client *client.Client, changes []templateChange, dryRun bool) error {
+	var pending []templateChange
+	for _, change := range changes {
+		if printTemplateChange(change) {
+			pending = append(pending, change)
+		}
+	}
+	if len(pending) == 0 || dryRun {
+		return nil
+	}
+
+	configMaps := client.GetClientset().CoreV1().ConfigMaps(common.RunaiNamespace)
+	for _, change := range pending {
+		err := retry.Mutation(fmt.Sprintf("apply the changes to template %v", templateName(change)), func() error {
+			switch {
+			case change.New == nil:
+				err := configMaps.Delete(change.Old.ConfigMap.Name, &metav1.DeleteOptions{})
+				if errors.IsNotFound(err) {
+					return nil
+				}
+				return err
+			case change.Old == nil:
+				configMap, err := change.New.toConfigMap(nil)
+				if err != nil {
+					return err
+				}
+				_, err = configMaps.Create(configMap)
+				return err
+			default:
+				configMap, err := change.New.toConfigMap(change.Old)
+				if err != nil {
+					return err
+				}
+				_, err = configMaps.Update(configMap)
+				if errors.IsConflict(err) {
+					// the changes were computed from the version that was read, so they must not be applied to a newer one
+					return commandUtil.Conflict(fmt.Errorf("Template: %v was changed while it was edited, run the command again to see the current changes", change.New.Name))
+				}
+				return err
+			}
+		})
+		if _, changedWhileEdited := err.(*commandUtil.Error); changedWhileEdited {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to apply the changes to template: %v, error: %w", templateName(change), err)
+		}
+	}
+	fmt.Println("Successfully applied the changes to the templates")
+	return nil
+}
+
+// printTemplateChange prints the difference between the template before and after the change, and returns
+// whether there is any difference
+func printTemplateChange(change templateChange) bool {
+	name := templateName(change)
+	if change.New == nil {
+		fmt.Printf("Template: %v will be deleted\n", name)
+		return true
+	}
+
+	old := change.Old
+	if old == nil {
+		old = &cliTemplate{Values: map[string]interface{}{}}
+	}
+	var lines []string
+	if old.Description != change.New.Description {
+		lines = append(lines, fmt.Sprintf("  ~ description: %s -> %s", formatValue(old.Description), formatValue(change.New.Description)))
+	}
+	if old.Default != change.New.Default {
+		lines = append(lines, fmt.Sprintf("  ~ default: %v -> %v", old.Default, change.New.Default))
+	}
+	lines = append(lines, valuesDiff(old.Values, change.New.Values)...)
+
+	if change.Old == nil {
+		fmt.Printf("Template: %v will be created:\n", name)
+	} else if len(lines) == 0 {
+		fmt.Printf("Template: %v has no changes\n", name)
+		return false
+	} else {
+		fmt.Printf("Template: %v will be changed:\n", name)
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return true
+}
+
+func valuesDiff(old, new map[string]interface{}) []string {
+	keys := map[string]interface{}{}
+	for key := range old {
+		keys[key] = nil
+	}
+	for key := range new {
+		keys[key] = nil
+	}
+	var lines []string
+	for _, key := range sortedKeys(keys) {
+		oldValue, inOld := old[key]
+		newValue, inNew := new[key]
+		switch {
+		case !inOld:
+			lines = append(lines, fmt.Sprintf("  + %s: %s", key, formatValue(newValue)))
+		case !inNew:
+			lines = append(lines, fmt.Sprintf("  - %s: %s", key, formatValue(oldValue)))
+		case formatValue(oldValue) != formatValue(newValue):
+			lines = append(lines, fmt.Sprintf("  ~ %s: %s -> %s", key, formatValue(oldValue), formatValue(newValue)))
+		}
+	}
+	return lines
+}
+
+func formatValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+func templateName(change templateChange) string {
+	if change.New != nil {
+		return change.New.Name
+	}
+	return change.Old.Name
+}
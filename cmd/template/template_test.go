@@ -0,0 +1,49 @@
+package template
+
+import "testing"
+
+func TestSetDefault(t *testing.T) {
+	templates := []*cliTemplate{
+		{Name: "old-default", Default: true},
+		{Name: "other"},
+		{Name: "new-default"},
+	}
+	changes := setDefault(templates, templates[2], nil)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got: %d", len(changes))
+	}
+	// the new default is set first, so that a failure of the second change does not leave the CLI without a default
+	if changes[0].New.Name != "new-default" || !changes[0].New.Default {
+		t.Errorf("Expected the first change to make new-default the default, got: %v default: %v", changes[0].New.Name, changes[0].New.Default)
+	}
+	if changes[1].New.Name != "old-default" || changes[1].New.Default {
+		t.Errorf("Expected the second change to clear old-default, got: %v default: %v", changes[1].New.Name, changes[1].New.Default)
+	}
+}
+
+func TestValidateChangedValues(t *testing.T) {
+	// unknownKey is not a value that runai-adm knows, e.g. of a newer researcher CLI
+	previous := map[string]interface{}{"image": "ubuntu", "unknownKey": true}
+	tests := []struct {
+		name        string
+		values      map[string]interface{}
+		expectError bool
+	}{
+		{name: "unchanged unknown key is kept", values: map[string]interface{}{"image": "ubuntu", "unknownKey": true, "gpu": float64(1)}},
+		{name: "unknown key is removed", values: map[string]interface{}{"image": "ubuntu"}},
+		{name: "unknown key is changed", values: map[string]interface{}{"image": "ubuntu", "unknownKey": false}, expectError: true},
+		{name: "unknown key is added", values: map[string]interface{}{"image": "ubuntu", "unknownKey": true, "otherKey": 1}, expectError: true},
+		{name: "changed value of the wrong type", values: map[string]interface{}{"image": true, "unknownKey": true}, expectError: true},
+		{name: "invalid quantity", values: map[string]interface{}{"image": "ubuntu", "unknownKey": true, "memory": "1 GB"}, expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateValues(test.values, changedKeys(previous, test.values))
+			if test.expectError && err == nil {
+				t.Error("Expected an error")
+			} else if !test.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package template
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+type valueKind string
+
+const (
+	boolValue       valueKind = "boolean"
+	stringValue     valueKind = "string"
+	numberValue     valueKind = "number"
+	quantityValue   valueKind = "quantity"
+	stringListValue valueKind = "list of strings"
+)
+
+type submitValue struct {
+	flag string
+	kind valueKind
+}
+
+// submitValues are the keys of the template values that the researcher CLI reads for the flags of 'runai submit'.
+// The list was written by hand from the submit flags of the researcher CLI and the examples of this repository
+// (examples/cli-defaults.yaml), it is not generated from a released version of the researcher CLI and may miss the
+// keys of newer versions. That is why only the keys that a command changes are validated, the keys that a template
+// already has are kept as they are
+var submitValues = map[string]submitValue{
+	"image":                      {"--image", stringValue},
+	"imagePullPolicy":            {"--image-pull-policy", stringValue},
+	"alwaysPullImage":            {"--always-pull-image", boolValue},
+	"localImage":                 {"--local-image", boolValue},
+	"gpu":                        {"--gpu", numberValue},
+	"cpu":                        {"--cpu", quantityValue},
+	"memory":                     {"--memory", quantityValue},
+	"cpuLimit":                   {"--cpu-limit", quantityValue},
+	"memoryLimit":                {"--memory-limit", quantityValue},
+	"shm":                        {"--large-shm", boolValue},
+	"hostIPC":                    {"--host-ipc", boolValue},
+	"hostNetwork":                {"--host-network", boolValue},
+	"interactive":                {"--interactive", boolValue},
+	"preemptible":                {"--preemptible", boolValue},
+	"elastic":                    {"--elastic", boolValue},
+	"isJupyter":                  {"--jupyter", boolValue},
+	"nodeType":                   {"--node-type", stringValue},
+	"project":                    {"--project", stringValue},
+	"workingDir":                 {"--working-dir", stringValue},
+	"command":                    {"--command", stringListValue},
+	"args":                       {"--args", stringListValue},
+	"environment":                {"--environment", stringListValue},
+	"volume":                     {"--volume", stringListValue},
+	"ports":                      {"--port", stringListValue},
+	"serviceType":                {"--service-type", stringValue},
+	"runAsUser":                  {"--run-as-user", boolValue},
+	"preventPrivilegeEscalation": {"--prevent-privilege-escalation", boolValue},
+	"createHomeDir":              {"--create-home-dir", boolValue},
+	"ttlSecondsAfterFinished":    {"--ttl-after-finish", numberValue},
+	"backoffLimit":               {"--backoffLimit", numberValue},
+	"jobNamePrefix":              {"--job-name-prefix", stringValue},
+	"parallelism":                {"--parallelism", numberValue},
+	"completions":                {"--completions", numberValue},
+}
+
+// parseValues parses the values YAML blob of a template
+func parseValues(data string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(data), &values); err != nil {
+		return nil, commandUtil.Validation(fmt.Errorf("Failed to parse the template values, error: %w", err))
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return values, nil
+}
+
+func formatValues(values map[string]interface{}) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("Failed to format the template values, error: %w", err)
+	}
+	return string(data), nil
+}
+
+// validateValues checks that the given keys of the values are read by the researcher CLI, and have the type of their flag
+func validateValues(values map[string]interface{}, keys []string) error {
+	var problems []string
+	for _, key := range keys {
+		value := values[key]
+		submit, found := submitValues[key]
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: not a value of the researcher submit flags", key))
+			continue
+		}
+		if err := validateValue(submit.kind, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %v", key, submit.flag, err))
+		}
+	}
+	if len(problems) > 0 {
+		return commandUtil.Validationf("Invalid template values:\n  - %s\nThe valid keys are: %s", strings.Join(problems, "\n  - "), strings.Join(submitValueKeys(), ", "))
+	}
+	return nil
+}
+
+func validateValue(kind valueKind, value interface{}) error {
+	valid := false
+	switch kind {
+	case boolValue:
+		_, valid = value.(bool)
+	case stringValue:
+		_, valid = value.(string)
+	case numberValue:
+		_, valid = value.(float64)
+	case quantityValue:
+		switch quantity := value.(type) {
+		case float64:
+			valid = true
+		case string:
+			if _, err := resource.ParseQuantity(quantity); err != nil {
+				return fmt.Errorf("invalid quantity: %v", quantity)
+			}
+			valid = true
+		}
+	case stringListValue:
+		switch list := value.(type) {
+		case string:
+			valid = true
+		case []interface{}:
+			valid = true
+			for _, item := range list {
+				if _, ok := item.(string); !ok {
+					valid = false
+				}
+			}
+		}
+	}
+	if !valid {
+		return fmt.Errorf("expected a %s, got: %v", kind, value)
+	}
+	return nil
+}
+
+// parseSetValues parses key=value pairs, the values are parsed as YAML, e.g. gpu=1, hostIPC=true or volume=[/a:/a, /b:/b]
+func parseSetValues(pairs []string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, commandUtil.Validationf("Invalid value: %v, expected key=value", pair)
+		}
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(parts[1]), &value); err != nil || value == nil {
+			value = parts[1]
+		}
+		values[parts[0]] = value
+	}
+	return values, nil
+}
+
+// changedKeys returns the keys of the new values that were added or changed, the removed keys are not returned
+func changedKeys(old, new map[string]interface{}) []string {
+	var keys []string
+	for _, key := range sortedKeys(new) {
+		if oldValue, found := old[key]; !found || formatValue(oldValue) != formatValue(new[key]) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	copied := map[string]interface{}{}
+	for key, value := range values {
+		copied[key] = value
+	}
+	return copied
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func submitValueKeys() []string {
+	var keys []string
+	for key := range submitValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -16,9 +16,9 @@ package uninstall
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/run-ai/runai-cli/pkg/util/kubectl"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/run-ai/runai-cli/cmd/common"
@@ -26,7 +26,6 @@ import (
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type uninstallFlags struct {
@@ -39,26 +38,35 @@ func Command() *cobra.Command {
 		Use:   "uninstall",
 		Short: "Uninstall the Run:AI cluster",
 		Args:  cobra.ExactArgs(0),
-		Run: func(cmd *cobra.Command, args []string) {
-			client := client.GetClient()
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
 			if uninstallFlags.deleteAll {
 				log.Infof("Deleting RunaiConfig")
-				deleteRunaiConfig(client)
-			} else {
-				common.ScaleRunaiOperator(client, 0)
+				if err := deleteRunaiConfig(client); err != nil {
+					return err
+				}
+			} else if err := common.ScaleDownRunaiOperator(client); err != nil {
+				return err
+			}
+			if err := deleteAllResources(client, uninstallFlags); err != nil {
+				return err
 			}
-			deleteAllResources(client, uninstallFlags)
 			deleteResourcesByKubectlCommand()
 
 			if uninstallFlags.deleteAll {
-				err := client.GetClientset().CoreV1().Namespaces().Delete("runai", &metav1.DeleteOptions{})
+				err := retry.Mutation("delete namespace runai", func() error {
+					return client.GetClientset().CoreV1().Namespaces().Delete("runai", &metav1.DeleteOptions{})
+				})
 				if err != nil {
-					log.Infof("Failed to delete namespace runai, error %v", err)
-					os.Exit(1)
+					return fmt.Errorf("Failed to delete namespace runai, error: %w", err)
 				}
 				log.Infof("Deleted namespace runai")
 			}
 			log.Println("Successfully uninstalled Run:AI Cluster")
+			return nil
 		},
 	}
 	command.Flags().BoolVarP(&uninstallFlags.deleteAll, "all", "A", false, "use flag to delete: Runai Namespace, RunaiConfig, Runai Operator")
@@ -66,7 +74,19 @@ func Command() *cobra.Command {
 	return command
 }
 
-func deleteAllResources(client *client.Client, uninstallFlags uninstallFlags) {
+// deleteAllResources deletes the workloads and the PVCs of Run:AI. It continues after a failure, and returns an error
+// when any of them was not deleted
+func deleteAllResources(client *client.Client, uninstallFlags uninstallFlags) error {
+	failed := 0
+	deleteResource := func(kind, name string, delete func() error) {
+		if err := retry.Deletion(fmt.Sprintf("delete %s %v", kind, name), delete); err != nil {
+			log.Warnf("Failed to delete %s: %v, error: %v", kind, name, err)
+			failed++
+			return
+		}
+		log.Debugf("deleted %s %v", kind, name)
+	}
+
 	deployments, err := client.GetClientset().AppsV1().Deployments("runai").List(metav1.ListOptions{})
 	if err == nil {
 		for _, deployment := range deployments.Items {
@@ -74,87 +94,96 @@ func deleteAllResources(client *client.Client, uninstallFlags uninstallFlags) {
 				log.Infof("Keeping RunAI Operator with 0 replicas")
 				continue
 			}
-			client.GetClientset().AppsV1().Deployments("runai").Delete(deployment.Name, &metav1.DeleteOptions{})
-			log.Debugf("deleted deployment %v", deployment.Name)
+			deleteResource("deployment", deployment.Name, func() error {
+				return client.GetClientset().AppsV1().Deployments("runai").Delete(deployment.Name, &metav1.DeleteOptions{})
+			})
 		}
+	} else {
+		log.Warnf("Failed to list deployments, error: %v", err)
+		failed++
 	}
 
 	dss, err := client.GetClientset().AppsV1().DaemonSets("runai").List(metav1.ListOptions{})
 	if err == nil {
 		for _, ds := range dss.Items {
-			client.GetClientset().AppsV1().DaemonSets("runai").Delete(ds.Name, &metav1.DeleteOptions{})
-			log.Debugf("deleted ds %v", ds.Name)
+			deleteResource("daemonset", ds.Name, func() error {
+				return client.GetClientset().AppsV1().DaemonSets("runai").Delete(ds.Name, &metav1.DeleteOptions{})
+			})
 		}
+	} else {
+		log.Warnf("Failed to list daemonsets, error: %v", err)
+		failed++
 	}
 
 	stss, err := client.GetClientset().AppsV1().StatefulSets("runai").List(metav1.ListOptions{})
 	if err == nil {
 		for _, sts := range stss.Items {
-			client.GetClientset().AppsV1().StatefulSets("runai").Delete(sts.Name, &metav1.DeleteOptions{})
-			log.Debugf("deleted sts %v", sts.Name)
+			deleteResource("statefulset", sts.Name, func() error {
+				return client.GetClientset().AppsV1().StatefulSets("runai").Delete(sts.Name, &metav1.DeleteOptions{})
+			})
 		}
+	} else {
+		log.Warnf("Failed to list statefulsets, error: %v", err)
+		failed++
 	}
 
 	jobs, err := client.GetClientset().BatchV1().Jobs("runai").List(metav1.ListOptions{})
 	if err == nil {
 		for _, job := range jobs.Items {
-			client.GetClientset().AppsV1().StatefulSets("runai").Delete(job.Name, &metav1.DeleteOptions{})
-			log.Debugf("deleted job %v", job.Name)
+			deleteResource("job", job.Name, func() error {
+				return client.GetClientset().BatchV1().Jobs("runai").Delete(job.Name, &metav1.DeleteOptions{})
+			})
 		}
+	} else {
+		log.Warnf("Failed to list jobs, error: %v", err)
+		failed++
 	}
 
-	err = client.GetClientset().CoreV1().PersistentVolumeClaims("runai").Delete("data-runai-db-0", &metav1.DeleteOptions{})
-	if err == nil {
-		log.Debugf("Deleted PVC: data-runai-db-0")
-	}
-
-	err = client.GetClientset().CoreV1().PersistentVolumeClaims("runai").Delete("prometheus-runai-prometheus-operator-prometheus-db-prometheus-runai-prometheus-operator-prometheus-0", &metav1.DeleteOptions{})
-	if err == nil {
-		log.Debugf("Deleted PVC: prometheus-runai-prometheus-operator-prometheus-db-prometheus-runai-prometheus-operator-prometheus-0")
+	for _, pvcName := range []string{
+		"data-runai-db-0",
+		"prometheus-runai-prometheus-operator-prometheus-db-prometheus-runai-prometheus-operator-prometheus-0",
+		"storage-volume-runai-prometheus-pushgateway-0",
+	} {
+		deleteResource("PVC", pvcName, func() error {
+			return client.GetClientset().CoreV1().PersistentVolumeClaims("runai").Delete(pvcName, &metav1.DeleteOptions{})
+		})
 	}
 
-	err = client.GetClientset().CoreV1().PersistentVolumeClaims("runai").Delete("storage-volume-runai-prometheus-pushgateway-0", &metav1.DeleteOptions{})
-	if err == nil {
-		log.Debugf("Deleted PVC: storage-volume-runai-prometheus-pushgateway-0")
+	if failed > 0 {
+		return fmt.Errorf("Failed to delete %d of the Run:AI resources", failed)
 	}
+	return nil
 }
 
-func deleteRunaiConfig(client *client.Client) {
-	runaiconfigResource := schema.GroupVersionResource{Group: "run.ai", Version: "v1", Resource: "runaiconfigs"}
-	var error error
-	var runaiConfig *unstructured.Unstructured
-	for i := 0; i < common.NumberOfRetiresForApiServer; i++ {
-		runaiConfig, error = client.GetDynamicClient().Resource(runaiconfigResource).Namespace("runai").Get("runai", metav1.GetOptions{})
-		if error != nil {
-			fmt.Println("Failed to get RunaiConfig")
-			return
+func deleteRunaiConfig(client *client.Client) error {
+	runaiConfigs := client.GetDynamicClient().Resource(common.RunaiConfigResource).Namespace(common.RunaiNamespace)
+	deleted := false
+	err := retry.Mutation("delete runaiconfig", func() error {
+		runaiConfig, err := runaiConfigs.Get(common.RunaiConfigName, metav1.GetOptions{})
+		if err != nil {
+			log.Infof("Failed to get RunaiConfig, error: %v", err)
+			return nil
 		}
 		var emptyMap []string
-		err := unstructured.SetNestedStringSlice(runaiConfig.Object, emptyMap, "metadata", "finalizers")
-		if err != nil {
-			fmt.Printf("Failed to update RunaiConfig finalizer, error: %v", err)
-			os.Exit(1)
+		if err := unstructured.SetNestedStringSlice(runaiConfig.Object, emptyMap, "metadata", "finalizers"); err != nil {
+			return fmt.Errorf("Failed to update RunaiConfig finalizer, error: %w", err)
 		}
-		_, error = client.GetDynamicClient().Resource(runaiconfigResource).Namespace("runai").Update(runaiConfig, metav1.UpdateOptions{})
-		if error != nil {
-			log.Debugf("Failed to update runaiconfig, attempt: %v, error: %v", i, error)
-			continue
+		if _, err := runaiConfigs.Update(runaiConfig, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("Failed to update runaiconfig, error: %w", err)
 		}
-		error = client.GetDynamicClient().Resource(runaiconfigResource).Namespace("runai").Delete("runai", &metav1.DeleteOptions{})
-		if error != nil {
-			log.Debugf("Failed to delete runaiconfig, attempt: %v, error: %v", i, error)
-			continue
+		if err := runaiConfigs.Delete(common.RunaiConfigName, &metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("Failed to delete runaiconfig, error: %w", err)
 		}
-
-		break
+		deleted = true
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-
-	if error != nil {
-		log.Infof("Failed to update runaiconfig, error: %v", error)
-		os.Exit(1)
+	if deleted {
+		log.Infof("Deleted runaiconfig")
 	}
-
-	log.Infof("Deleted runaiconfig")
+	return nil
 }
 
 func deleteResourcesByKubectlCommand() {
@@ -0,0 +1,174 @@
+package update
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/run-ai/runai-cli/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	versionFileName = "VERSION"
+)
+
+// extractRelease extracts the binary and the VERSION file of a release archive into a temp folder. Other files of
+// the archive, e.g. the install script, are not needed
+func extractRelease(archivePath string) (string, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return "", fmt.Errorf("Error unarchiving downloaded file %s: %w", archivePath, err)
+	}
+	defer gzipReader.Close()
+
+	extractDir, err := ioutil.TempDir("", fmt.Sprintf("%s-%s-%s", config.CLIName, osName, arch))
+	if err != nil {
+		return "", err
+	}
+
+	wanted := map[string]bool{config.CLIName: true, versionFileName: true}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			os.RemoveAll(extractDir)
+			return "", fmt.Errorf("Error unarchiving downloaded file %s: %w", archivePath, err)
+		}
+		name := path.Base(header.Name)
+		if header.Typeflag != tar.TypeReg || !wanted[name] {
+			continue
+		}
+		if err := writeFile(path.Join(extractDir, name), tarReader, 0700); err != nil {
+			os.RemoveAll(extractDir)
+			return "", err
+		}
+		delete(wanted, name)
+	}
+	if len(wanted) > 0 {
+		os.RemoveAll(extractDir)
+		return "", fmt.Errorf("The archive %s does not contain %s", archivePath, strings.Join(keys(wanted), ", "))
+	}
+
+	log.Infof("Unarchived version in %s", extractDir)
+	return extractDir, nil
+}
+
+// readVersionFile returns the version in the VERSION file of a folder
+func readVersionFile(dir string) (string, error) {
+	data, err := ioutil.ReadFile(path.Join(dir, versionFileName))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// installRelease installs the binary and the VERSION file into the install folder. Each file is written next to
+// its target and renamed over it, so a failure never leaves a partially written binary
+func installRelease(sourceDir string, installDir string) error {
+	if err := os.MkdirAll(installDir, 0755); err != nil {
+		return installError(installDir, err)
+	}
+	files := []struct {
+		name string
+		mode os.FileMode
+	}{{config.CLIName, 0755}, {versionFileName, 0644}}
+	for _, file := range files {
+		source, err := os.Open(path.Join(sourceDir, file.name))
+		if err != nil {
+			return err
+		}
+		err = replaceFile(path.Join(installDir, file.name), source, file.mode)
+		source.Close()
+		if err != nil {
+			return installError(installDir, err)
+		}
+	}
+	return nil
+}
+
+// linkBinary points BIN_DIR/runai-adm to the binary in the install folder, replacing an existing link atomically
+func linkBinary(installDir string, binDir string) error {
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return installError(binDir, err)
+	}
+	link := path.Join(binDir, config.CLIName)
+	if info, err := os.Lstat(link); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s exists and is not a symbolic link, remove it or use another --prefix", link)
+	}
+	tempLink := link + ".new"
+	os.Remove(tempLink)
+	if err := os.Symlink(path.Join(installDir, config.CLIName), tempLink); err != nil {
+		return installError(binDir, err)
+	}
+	if err := os.Rename(tempLink, link); err != nil {
+		os.Remove(tempLink)
+		return installError(binDir, err)
+	}
+	return nil
+}
+
+func replaceFile(target string, content io.Reader, mode os.FileMode) error {
+	temp, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := io.Copy(temp, content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), target)
+}
+
+func writeFile(target string, content io.Reader, mode os.FileMode) error {
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return fmt.Errorf("Could not write %s: %w", target, err)
+	}
+	return file.Close()
+}
+
+func installError(dir string, err error) error {
+	if os.IsPermission(err) {
+		return fmt.Errorf("No permission to write to %s, run the command as root or install to a folder of your own with --prefix: %w", dir, err)
+	}
+	return fmt.Errorf("Failed to install to %s: %w", dir, err)
+}
+
+func keys(set map[string]bool) []string {
+	var result []string
+	for key := range set {
+		result = append(result, key)
+	}
+	return result
+}
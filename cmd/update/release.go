@@ -0,0 +1,345 @@
+package update
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultGithubUrl = "https://api.github.com/repos/run-ai/runai-admin-cli"
+	// checksumSuffix is the suffix of the asset that holds the SHA256 checksum of another asset
+	checksumSuffix = ".sha256"
+	// mirrorIndexFile is the index of the releases in a mirror, in the format of the GitHub releases API
+	mirrorIndexFile = "index.json"
+)
+
+var (
+	// checksumsAssets are the names of release assets that hold the SHA256 checksums of all the other assets,
+	// in the format of sha256sum
+	checksumsAssets = []string{"checksums.txt", "sha256sums.txt", "SHA256SUMS"}
+)
+
+type GithubResponse struct {
+	TagName   string  `json:"tag_name"`
+	AssetsUrl string  `json:"assets_url"`
+	Assets    []Asset `json:"assets"`
+}
+
+type Asset struct {
+	Name        string `json:"name"`
+	Url         string `json:"url"`
+	DownloadUrl string `json:"browser_download_url"`
+}
+
+// releaseSource is where the releases of the CLI are downloaded from
+type releaseSource interface {
+	// getRelease returns the release of the given version, or the latest release if no version is given
+	getRelease(version string) (*GithubResponse, error)
+	// open returns the content of an asset of a release
+	open(release *GithubResponse, asset Asset) (io.ReadCloser, error)
+	String() string
+}
+
+// githubSource downloads the releases from GitHub or from GitHub Enterprise, with a token for private repositories
+type githubSource struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+// mirrorSource downloads the releases from an HTTP folder with an index.json file that lists the releases, in the
+// format of the GitHub releases API. The assets of each release are in a folder named after its tag,
+// e.g. MIRROR_URL/v0.0.14/checksums.txt
+type mirrorSource struct {
+	client *http.Client
+	url    string
+}
+
+// newReleaseSource returns the mirror if a mirror URL is given, and GitHub otherwise
+func newReleaseSource(options updateOptions) (releaseSource, error) {
+	client, err := newHttpClient(options.CaFile)
+	if err != nil {
+		return nil, err
+	}
+	if options.MirrorUrl != "" {
+		return &mirrorSource{client: client, url: strings.TrimSuffix(options.MirrorUrl, "/")}, nil
+	}
+	githubUrl := options.GithubUrl
+	if githubUrl == "" {
+		githubUrl = defaultGithubUrl
+	}
+	return &githubSource{client: client, url: strings.TrimSuffix(githubUrl, "/"), token: options.GithubToken}, nil
+}
+
+// newHttpClient returns an HTTP client that uses the proxy of the HTTPS_PROXY environment variable and trusts
+// the certificates of the CA bundle, in addition to the certificates of the system
+func newHttpClient(caFile string) (*http.Client, error) {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read CA bundle %s: %w", caFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates found in CA bundle %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport, Timeout: 5 * time.Minute}, nil
+}
+
+func (source *githubSource) String() string {
+	return source.url
+}
+
+func (source *githubSource) getRelease(version string) (*GithubResponse, error) {
+	if version == "" {
+		release := new(GithubResponse)
+		if err := source.getJson(source.url+"/releases/latest", release); err != nil {
+			return nil, err
+		}
+		return release, nil
+	}
+
+	var err error
+	for _, tag := range versionTags(version) {
+		release := new(GithubResponse)
+		if err = source.getJson(source.url+"/releases/tags/"+tag, release); err == nil {
+			return release, nil
+		}
+	}
+	return nil, fmt.Errorf("Could not find release %s: %w", version, err)
+}
+
+func (source *githubSource) getJson(url string, output interface{}) error {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	source.authorize(request)
+	body, err := doRequest(source.client, request)
+	if err != nil {
+		return fmt.Errorf("Could not access github api: %w", err)
+	}
+	defer body.Close()
+
+	if err := json.NewDecoder(body).Decode(output); err != nil {
+		return fmt.Errorf("Could not read body of github response: %w", err)
+	}
+	return nil
+}
+
+// open downloads the asset with the API when there is a token, since the browser URLs of a private repository
+// do not accept tokens
+func (source *githubSource) open(release *GithubResponse, asset Asset) (io.ReadCloser, error) {
+	url := asset.DownloadUrl
+	if source.token != "" && asset.Url != "" {
+		url = asset.Url
+	}
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	source.authorize(request)
+	request.Header.Set("Accept", "application/octet-stream")
+	return doRequest(source.client, request)
+}
+
+func (source *githubSource) authorize(request *http.Request) {
+	if source.token != "" {
+		request.Header.Set("Authorization", "token "+source.token)
+	}
+}
+
+func (source *mirrorSource) String() string {
+	return source.url
+}
+
+func (source *mirrorSource) getRelease(version string) (*GithubResponse, error) {
+	request, err := http.NewRequest(http.MethodGet, source.url+"/"+mirrorIndexFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := doRequest(source.client, request)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the index of mirror %s: %w", source.url, err)
+	}
+	defer body.Close()
+	var releases []GithubResponse
+	if err := json.NewDecoder(body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("Could not parse the index of mirror %s: %w", source.url, err)
+	}
+
+	var found *GithubResponse
+	for i, release := range releases {
+		if version != "" {
+			for _, tag := range versionTags(version) {
+				if release.TagName == tag {
+					return &releases[i], nil
+				}
+			}
+			continue
+		}
+		if found == nil {
+			found = &releases[i]
+		} else if comparison, err := compareVersions(release.TagName, found.TagName); err == nil && comparison > 0 {
+			found = &releases[i]
+		}
+	}
+	if found == nil {
+		if version == "" {
+			return nil, fmt.Errorf("The index of mirror %s has no releases", source.url)
+		}
+		return nil, fmt.Errorf("Could not find release %s in mirror %s", version, source.url)
+	}
+	return found, nil
+}
+
+func (source *mirrorSource) open(release *GithubResponse, asset Asset) (io.ReadCloser, error) {
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s/%s", source.url, url.PathEscape(release.TagName), url.PathEscape(asset.Name)), nil)
+	if err != nil {
+		return nil, err
+	}
+	return doRequest(source.client, request)
+}
+
+func doRequest(client *http.Client, request *http.Request) (io.ReadCloser, error) {
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("Unexpected response from %s: %s", request.URL, response.Status)
+	}
+	return response.Body, nil
+}
+
+// versionTags returns the tags that a version may be released with, e.g. 0.0.14 and v0.0.14
+func versionTags(version string) []string {
+	if strings.HasPrefix(version, "v") {
+		return []string{version}
+	}
+	return []string{version, "v" + version}
+}
+
+// findAsset returns the archive of the release for the current OS and architecture
+func findAsset(release *GithubResponse) (*Asset, error) {
+	platform := fmt.Sprintf("%s-%s", osName, arch)
+	for i, asset := range release.Assets {
+		if strings.Contains(asset.Name, platform) && strings.HasSuffix(asset.Name, ".tar.gz") {
+			log.Infof("Found matching asset %s", asset.Name)
+			return &release.Assets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Could not find a matching asset for %s in release %s", platform, release.TagName)
+}
+
+// findChecksum returns the published SHA256 checksum of the asset, either from an asset named after it
+// or from a checksums file of the release
+func findChecksum(source releaseSource, release *GithubResponse, asset *Asset) (string, error) {
+	for _, candidate := range release.Assets {
+		if candidate.Name == asset.Name+checksumSuffix {
+			return downloadChecksum(source, release, candidate, asset.Name)
+		}
+	}
+	for _, name := range checksumsAssets {
+		for _, candidate := range release.Assets {
+			if candidate.Name == name {
+				return downloadChecksum(source, release, candidate, asset.Name)
+			}
+		}
+	}
+	return "", fmt.Errorf("Release %s does not publish a SHA256 checksum of %s", release.TagName, asset.Name)
+}
+
+func downloadChecksum(source releaseSource, release *GithubResponse, checksumAsset Asset, fileName string) (string, error) {
+	body, err := source.open(release, checksumAsset)
+	if err != nil {
+		return "", fmt.Errorf("Could not download %s: %w", checksumAsset.Name, err)
+	}
+	defer body.Close()
+	return readChecksum(body, checksumAsset.Name, fileName)
+}
+
+// readChecksum returns the checksum of a file from either a single checksum or lines of '<checksum>  <file name>'
+func readChecksum(reader io.Reader, checksumName string, fileName string) (string, error) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 1 || (len(fields) == 2 && path.Base(strings.TrimPrefix(fields[1], "*")) == fileName) {
+			return normalizeChecksum(fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("Could not read %s: %w", checksumName, err)
+	}
+	return "", fmt.Errorf("%s has no checksum of %s", checksumName, fileName)
+}
+
+func normalizeChecksum(checksum string) (string, error) {
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("Invalid SHA256 checksum: %s", checksum)
+	}
+	return checksum, nil
+}
+
+// downloadFile downloads the asset to a temp folder and returns its path
+func downloadFile(source releaseSource, release *GithubResponse, asset Asset) (string, error) {
+	body, err := source.open(release, asset)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	downloadPath := path.Join(os.TempDir(), asset.Name)
+	out, err := os.Create(downloadPath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, body); err != nil {
+		return "", fmt.Errorf("Could not write %s: %w", downloadPath, err)
+	}
+	if err = out.Close(); err != nil {
+		return "", fmt.Errorf("Could not write %s: %w", downloadPath, err)
+	}
+
+	log.Infof("Downloaded archive to %s", downloadPath)
+	return downloadPath, nil
+}
+
+// fileChecksum returns the SHA256 checksum of a file
+func fileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("Could not read %s: %w", filePath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,266 @@
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testChecksum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	testRepoPath = "/api/v3/repos/run-ai/runai-admin-cli"
+)
+
+// newGithubServer serves the releases API of a GitHub Enterprise repository, which requires the token when one is given
+func newGithubServer(t *testing.T, token string, releases map[string]GithubResponse) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if token != "" && request.Header.Get("Authorization") != "token "+token {
+			http.Error(writer, "Bad credentials", http.StatusUnauthorized)
+			return
+		}
+		release, found := releases[strings.TrimPrefix(request.URL.Path, testRepoPath)]
+		if !found {
+			http.NotFound(writer, request)
+			return
+		}
+		if err := json.NewEncoder(writer).Encode(release); err != nil {
+			t.Errorf("Failed to write release: %v", err)
+		}
+	}))
+}
+
+func TestGithubSourceGetRelease(t *testing.T) {
+	releases := map[string]GithubResponse{
+		"/releases/latest":       {TagName: "v0.0.15"},
+		"/releases/tags/v0.0.14": {TagName: "v0.0.14"},
+	}
+	server := newGithubServer(t, "secret", releases)
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		token       string
+		version     string
+		expectedTag string
+		expectError bool
+	}{
+		{name: "latest", token: "secret", expectedTag: "v0.0.15"},
+		{name: "version with v prefix", token: "secret", version: "v0.0.14", expectedTag: "v0.0.14"},
+		{name: "version without v prefix", token: "secret", version: "0.0.14", expectedTag: "v0.0.14"},
+		{name: "missing version", token: "secret", version: "0.0.99", expectError: true},
+		{name: "missing token", version: "v0.0.14", expectError: true},
+		{name: "wrong token", token: "other", expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the trailing slash of an Enterprise URL is ignored
+			source, err := newReleaseSource(updateOptions{GithubUrl: server.URL + testRepoPath + "/", GithubToken: test.token})
+			if err != nil {
+				t.Fatal(err)
+			}
+			release, err := source.getRelease(test.version)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("Expected an error, got release: %v", release.TagName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if release.TagName != test.expectedTag {
+				t.Errorf("Expected release: %v, got: %v", test.expectedTag, release.TagName)
+			}
+		})
+	}
+}
+
+func TestGithubSourceOpen(t *testing.T) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests = append(requests, request)
+		fmt.Fprint(writer, "archive")
+	}))
+	defer server.Close()
+	asset := Asset{Name: "runai-adm-v0.0.14-linux-amd64.tar.gz", Url: server.URL + "/api/assets/1", DownloadUrl: server.URL + "/download/v0.0.14/runai-adm-v0.0.14-linux-amd64.tar.gz"}
+
+	tests := []struct {
+		name          string
+		token         string
+		expectedPath  string
+		expectedToken string
+	}{
+		{name: "public repository downloads the browser URL", expectedPath: "/download/v0.0.14/runai-adm-v0.0.14-linux-amd64.tar.gz"},
+		{name: "private repository downloads with the API", token: "secret", expectedPath: "/api/assets/1", expectedToken: "token secret"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests = nil
+			source := &githubSource{client: server.Client(), url: server.URL, token: test.token}
+			body, err := source.open(&GithubResponse{TagName: "v0.0.14"}, asset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body.Close()
+			if len(requests) != 1 {
+				t.Fatalf("Expected a single request, got: %d", len(requests))
+			}
+			if requests[0].URL.Path != test.expectedPath {
+				t.Errorf("Expected a request to: %v, got: %v", test.expectedPath, requests[0].URL.Path)
+			}
+			if authorization := requests[0].Header.Get("Authorization"); authorization != test.expectedToken {
+				t.Errorf("Expected authorization: %q, got: %q", test.expectedToken, authorization)
+			}
+			if accept := requests[0].Header.Get("Accept"); accept != "application/octet-stream" {
+				t.Errorf("Expected accept: application/octet-stream, got: %v", accept)
+			}
+		})
+	}
+}
+
+// newMirrorServer serves an index.json of the releases and the assets of each release in a folder named after its tag
+func newMirrorServer(t *testing.T, releases []GithubResponse, files map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/mirror/"+mirrorIndexFile {
+			if err := json.NewEncoder(writer).Encode(releases); err != nil {
+				t.Errorf("Failed to write index: %v", err)
+			}
+			return
+		}
+		content, found := files[strings.TrimPrefix(request.URL.Path, "/mirror/")]
+		if !found {
+			http.NotFound(writer, request)
+			return
+		}
+		fmt.Fprint(writer, content)
+	}))
+}
+
+func TestMirrorSourceGetRelease(t *testing.T) {
+	releases := []GithubResponse{{TagName: "v0.0.9"}, {TagName: "v0.0.14"}, {TagName: "v0.0.10"}}
+	server := newMirrorServer(t, releases, nil)
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		version     string
+		expectedTag string
+		expectError bool
+	}{
+		{name: "latest is the newest version, not the last one", expectedTag: "v0.0.14"},
+		{name: "version with v prefix", version: "v0.0.10", expectedTag: "v0.0.10"},
+		{name: "version without v prefix", version: "0.0.9", expectedTag: "v0.0.9"},
+		{name: "missing version", version: "0.0.99", expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := newReleaseSource(updateOptions{MirrorUrl: server.URL + "/mirror/"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			release, err := source.getRelease(test.version)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("Expected an error, got release: %v", release.TagName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if release.TagName != test.expectedTag {
+				t.Errorf("Expected release: %v, got: %v", test.expectedTag, release.TagName)
+			}
+		})
+	}
+}
+
+func TestMirrorSourceEmptyIndex(t *testing.T) {
+	server := newMirrorServer(t, []GithubResponse{}, nil)
+	defer server.Close()
+	source := &mirrorSource{client: server.Client(), url: server.URL + "/mirror"}
+	if _, err := source.getRelease(""); err == nil {
+		t.Error("Expected an error for an index without releases")
+	}
+}
+
+func TestFindChecksum(t *testing.T) {
+	archive := Asset{Name: "runai-adm-v0.0.14-linux-amd64.tar.gz"}
+	otherChecksum := strings.Repeat("a", 64)
+	files := map[string]string{
+		"v0.0.14/" + archive.Name + checksumSuffix: testChecksum + "\n",
+		"v0.0.14/checksums.txt":                    otherChecksum + "  runai-adm-v0.0.14-darwin-amd64.tar.gz\n" + testChecksum + "  " + archive.Name + "\n",
+		"v0.0.13/SHA256SUMS":                       otherChecksum + "  runai-adm-v0.0.13-darwin-amd64.tar.gz\n",
+	}
+	server := newMirrorServer(t, nil, files)
+	defer server.Close()
+	source := &mirrorSource{client: server.Client(), url: server.URL + "/mirror"}
+
+	tests := []struct {
+		name        string
+		release     GithubResponse
+		expectError bool
+	}{
+		{name: "checksum asset of the archive", release: GithubResponse{TagName: "v0.0.14", Assets: []Asset{archive, {Name: "checksums.txt"}, {Name: archive.Name + checksumSuffix}}}},
+		{name: "checksums file of the release", release: GithubResponse{TagName: "v0.0.14", Assets: []Asset{archive, {Name: "checksums.txt"}}}},
+		{name: "checksums file without the archive", release: GithubResponse{TagName: "v0.0.13", Assets: []Asset{archive, {Name: "SHA256SUMS"}}}, expectError: true},
+		{name: "no checksum", release: GithubResponse{TagName: "v0.0.14", Assets: []Asset{archive}}, expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checksum, err := findChecksum(source, &test.release, &archive)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("Expected an error, got checksum: %v", checksum)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checksum != testChecksum {
+				t.Errorf("Expected checksum: %v, got: %v", testChecksum, checksum)
+			}
+		})
+	}
+}
+
+func TestReadChecksum(t *testing.T) {
+	fileName := "runai-adm-v0.0.14-linux-amd64.tar.gz"
+	tests := []struct {
+		name        string
+		content     string
+		expectError bool
+	}{
+		{name: "single checksum", content: testChecksum + "\n"},
+		{name: "upper case checksum", content: strings.ToUpper(testChecksum)},
+		{name: "sha256sum line", content: testChecksum + "  " + fileName + "\n"},
+		{name: "sha256sum binary mode line", content: testChecksum + " *" + fileName + "\n"},
+		{name: "sha256sum line with a folder", content: testChecksum + "  dist/" + fileName + "\n"},
+		{name: "line of another file", content: testChecksum + "  runai-adm-v0.0.14-darwin-amd64.tar.gz\n", expectError: true},
+		{name: "invalid checksum", content: "not-a-checksum\n", expectError: true},
+		{name: "short checksum", content: testChecksum[:32] + "\n", expectError: true},
+		{name: "empty", content: "", expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checksum, err := readChecksum(ioutil.NopCloser(strings.NewReader(test.content)), "checksums.txt", fileName)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("Expected an error, got checksum: %v", checksum)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checksum != testChecksum {
+				t.Errorf("Expected checksum: %v, got: %v", testChecksum, checksum)
+			}
+		})
+	}
+}
@@ -1,158 +1,347 @@
 package update
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 
-	"github.com/mholt/archiver"
+	"github.com/run-ai/runai-cli/pkg/config"
+	"github.com/run-ai/runai-cli/pkg/util"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/version"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
 )
 
 var (
-	url    = "https://api.github.com/repos/run-ai/runai-admin-cli/releases/latest"
 	osName = runtime.GOOS
 	arch   = runtime.GOARCH
 )
 
-type GithubResponse struct {
-	AssetsUrl string  `json:"assets_url"`
-	Assets    []Asset `json:"assets"`
-}
+// the environment variables that configure the release source when its flags are not given, so that
+// air-gapped hosts can be configured once
+const (
+	githubUrlEnv   = "RUNAI_ADM_GITHUB_URL"
+	githubTokenEnv = "RUNAI_ADM_GITHUB_TOKEN"
+	mirrorUrlEnv   = "RUNAI_ADM_MIRROR_URL"
+	caFileEnv      = "RUNAI_ADM_CA_FILE"
+)
 
-type Asset struct {
-	Name        string `json:"name"`
-	DownloadUrl string `json:"browser_download_url"`
+type updateOptions struct {
+	Version      string
+	Check        bool
+	Prefix       string
+	Force        bool
+	SkipChecksum bool
+	Checksum     string
+	FromFile     string
+	GithubUrl    string
+	GithubToken  string
+	MirrorUrl    string
+	CaFile       string
 }
 
 func Command() *cobra.Command {
+	options := updateOptions{}
 	var command = &cobra.Command{
 		Use:   "update",
 		Short: "Update the Run:AI Admin CLI to latest version.",
-		Run: func(cmd *cobra.Command, args []string) {
-			if os.Getuid() != 0 {
-				log.Error("The command must be run as root")
-				os.Exit(1)
-			}
-
-			latestRelease := new(GithubResponse)
-
-			// Find latest release from github
-			err := getResponseFromGithub(url, &latestRelease)
-
+		RunE: func(cmd *cobra.Command, args []string) error {
+			currentVersion, err := version.GetVersion()
 			if err != nil {
-				log.Error(err)
-				os.Exit(1)
+				return err
 			}
 
-			var matchingAsset Asset
-			// Find matching asset for current OS and ARCH
-			for _, asset := range latestRelease.Assets {
-				if strings.Contains(asset.Name, osName) && strings.Contains(asset.Name, arch) {
-					log.Infof("Found matching asset %s", asset.Name)
-					matchingAsset = asset
-					break
+			if options.FromFile != "" {
+				if options.Check || options.Version != "" {
+					return commandUtil.Validationf("--check and --version cannot be used with --from-file")
+				}
+				if err := updateFromFile(currentVersion.Version, options); err != nil {
+					return err
 				}
+				return nil
 			}
 
-			if matchingAsset.DownloadUrl == "" {
-				log.Errorf("Could not find a matching asset for %s-%s", osName, arch)
-				os.Exit(1)
+			optionFromEnv(&options.GithubUrl, githubUrlEnv)
+			optionFromEnv(&options.GithubToken, githubTokenEnv)
+			optionFromEnv(&options.MirrorUrl, mirrorUrlEnv)
+			optionFromEnv(&options.CaFile, caFileEnv)
+			source, err := newReleaseSource(options)
+			if err != nil {
+				return err
 			}
+			log.Debugf("Using release source: %s", source)
 
-			// Download the asset to temp folder
-			downloadPath, err := downloadFile(matchingAsset.DownloadUrl, matchingAsset.Name)
-
+			release, err := source.getRelease(options.Version)
 			if err != nil {
-				log.Errorf("Could not download an archive file %s", err)
-				os.Exit(1)
+				return err
 			}
 
-			// Unarchive the asset to temp folder
-			unarchivePath := path.Join(os.TempDir(), fmt.Sprintf("%s-%s", osName, arch))
-
-			tarArchiver := archiver.Tar{
-				OverwriteExisting: true,
-				MkdirAll:          true,
+			if options.Check {
+				printUpdateCheck(currentVersion.Version, release.TagName)
+				return nil
 			}
 
-			targzArchiver := archiver.TarGz{
-				Tar: &tarArchiver,
+			if err := update(currentVersion.Version, source, release, options); err != nil {
+				return err
 			}
+			return nil
+		},
+	}
 
-			err = targzArchiver.Unarchive(downloadPath, unarchivePath)
-			if err != nil {
-				log.Errorf("Error unarchiving downloaded file %s", err)
-				os.Exit(1)
-			}
+	command.Flags().StringVar(&options.Version, "version", "", "Version to update to, e.g. v0.0.14. Defaults to the latest version.")
+	command.Flags().BoolVar(&options.Check, "check", false, "Only show the installed and the latest versions, without updating.")
+	command.Flags().StringVar(&options.Prefix, "prefix", "", "Install to PREFIX/runai-adm and link it from PREFIX/bin, e.g. ~/.local to update without root. Defaults to replacing the installed binary.")
+	command.Flags().BoolVar(&options.Force, "force", false, "Install the version even if it is older than or the same as the installed version.")
+	command.Flags().BoolVar(&options.SkipChecksum, "skip-checksum", false, "Install the version even if the release does not publish a SHA256 checksum of its archive.")
+	command.Flags().StringVar(&options.Checksum, "checksum", "", "Expected SHA256 checksum of the archive, instead of the published checksum or the ARCHIVE.sha256 file of --from-file.")
+	command.Flags().StringVar(&options.FromFile, "from-file", "", "Install from a release archive on this host, e.g. runai-adm-v0.0.14-linux-amd64.tar.gz, for hosts without internet access.")
+	command.Flags().StringVar(&options.GithubUrl, "github-url", "", fmt.Sprintf("GitHub API URL of the CLI repository, e.g. https://github.example.com/api/v3/repos/run-ai/runai-admin-cli for GitHub Enterprise. Defaults to $%s or %s.", githubUrlEnv, defaultGithubUrl))
+	command.Flags().StringVar(&options.GithubToken, "github-token", "", fmt.Sprintf("GitHub token to access a private repository. Defaults to $%s.", githubTokenEnv))
+	command.Flags().StringVar(&options.MirrorUrl, "mirror-url", "", fmt.Sprintf("URL of an HTTP mirror of the releases, with an %s in the format of the GitHub releases API and a folder per release tag. Defaults to $%s.", mirrorIndexFile, mirrorUrlEnv))
+	command.Flags().StringVar(&options.CaFile, "ca-file", "", fmt.Sprintf("CA bundle to trust when downloading releases, e.g. of an artifact proxy. Defaults to $%s. Proxies are taken from HTTPS_PROXY.", caFileEnv))
+	return command
+}
 
-			log.Infof("Unarchived version in %s", unarchivePath)
+func update(currentVersion string, source releaseSource, release *GithubResponse, options updateOptions) error {
+	if err := checkUpdateVersion(currentVersion, release.TagName, options.Force); err != nil {
+		return err
+	}
 
-			// Install using install script
-			installScriptPath := path.Join(unarchivePath, "install-runai.sh")
-			installCommand := exec.Command(installScriptPath)
-			installCommand.Stdout = os.Stdout
-			installCommand.Stderr = os.Stderr
-			err = installCommand.Run()
+	installDir, binDir, err := installDirs(options.Prefix)
+	if err != nil {
+		return err
+	}
 
-			if err != nil {
-				log.Errorf("Error executing install script %s", err)
-				os.Exit(1)
+	asset, err := findAsset(release)
+	if err != nil {
+		return err
+	}
+	checksum := options.Checksum
+	if checksum == "" {
+		if checksum, err = findChecksum(source, release, asset); err != nil {
+			if !options.SkipChecksum {
+				return fmt.Errorf("%w\nUse --skip-checksum to install it without verifying its checksum", err)
 			}
+			log.Warnf("Installing without verifying the checksum (--skip-checksum was given): %v", err)
+		}
+	}
 
-			log.Infof("Successfully installed a new version")
-		},
+	downloadPath, err := downloadFile(source, release, *asset)
+	if err != nil {
+		return fmt.Errorf("Could not download an archive file %w", err)
+	}
+	defer os.Remove(downloadPath)
+	if err := verifyChecksum(downloadPath, checksum); err != nil {
+		return err
 	}
 
-	return command
+	extractDir, archiveVersion, err := extractArchive(downloadPath)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(extractDir)
+	if archiveVersion != release.TagName {
+		return fmt.Errorf("The archive contains version %s, but version %s was expected", archiveVersion, release.TagName)
+	}
+	return installExtracted(extractDir, archiveVersion, installDir, binDir)
 }
 
-func getResponseFromGithub(url string, output interface{}) error {
-	res, err := http.Get(url)
+// updateFromFile installs a release archive that was copied to this host. Its checksum is verified against
+// --checksum, or against the ARCHIVE.sha256 file next to it
+func updateFromFile(currentVersion string, options updateOptions) error {
+	installDir, binDir, err := installDirs(options.Prefix)
+	if err != nil {
+		return err
+	}
+
+	checksum := options.Checksum
+	if checksum == "" {
+		if checksum, err = readChecksumFile(options.FromFile); err != nil {
+			if !options.SkipChecksum {
+				return fmt.Errorf("%w\nUse --checksum to give the checksum of the archive, or --skip-checksum to install it without verifying its checksum", err)
+			}
+			log.Warnf("Installing without verifying the checksum (--skip-checksum was given): %v", err)
+		}
+	}
+	if err := verifyChecksum(options.FromFile, checksum); err != nil {
+		return err
+	}
 
+	extractDir, archiveVersion, err := extractArchive(options.FromFile)
 	if err != nil {
-		return fmt.Errorf("Could not access github api: %s", err)
+		return err
+	}
+	defer os.RemoveAll(extractDir)
+	if err := checkUpdateVersion(currentVersion, archiveVersion, options.Force); err != nil {
+		return err
 	}
+	return installExtracted(extractDir, archiveVersion, installDir, binDir)
+}
 
-	defer res.Body.Close()
+func readChecksumFile(archivePath string) (string, error) {
+	checksumPath := archivePath + checksumSuffix
+	file, err := os.Open(checksumPath)
+	if err != nil {
+		return "", fmt.Errorf("Could not read the checksum of %s: %w", archivePath, err)
+	}
+	defer file.Close()
+	return readChecksum(file, checksumPath, path.Base(archivePath))
+}
 
-	err = json.NewDecoder(res.Body).Decode(output)
+// verifyChecksum compares the SHA256 checksum of the file with the expected checksum, if there is one
+func verifyChecksum(filePath string, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	expected, err := normalizeChecksum(expected)
+	if err != nil {
+		return err
+	}
+	checksum, err := fileChecksum(filePath)
+	if err != nil {
+		return err
+	}
+	if checksum != expected {
+		return fmt.Errorf("The SHA256 checksum of %s is %s, but the expected checksum is %s", path.Base(filePath), checksum, expected)
+	}
+	log.Infof("Verified the SHA256 checksum of %s", path.Base(filePath))
+	return nil
+}
 
+// extractArchive extracts a release archive and returns the folder of its files and its version
+func extractArchive(archivePath string) (string, string, error) {
+	extractDir, err := extractRelease(archivePath)
+	if err != nil {
+		return "", "", err
+	}
+	archiveVersion, err := readVersionFile(extractDir)
 	if err != nil {
-		return fmt.Errorf("Could not read body of github response: %s", err)
+		os.RemoveAll(extractDir)
+		return "", "", fmt.Errorf("Could not read the version of the archive: %w", err)
+	}
+	return extractDir, archiveVersion, nil
+}
+
+// installExtracted installs an extracted release archive, and links it from the bin folder if given
+func installExtracted(extractDir string, archiveVersion string, installDir string, binDir string) error {
+	if err := installRelease(extractDir, installDir); err != nil {
+		return err
+	}
+	if binDir != "" {
+		if err := linkBinary(installDir, binDir); err != nil {
+			return err
+		}
+		if !inPath(binDir) {
+			log.Warnf("%s is not in your PATH, add it to run the installed version", binDir)
+		}
 	}
 
+	log.Infof("Successfully installed version %s in %s", archiveVersion, installDir)
 	return nil
 }
 
-func downloadFile(url string, assetName string) (string, error) {
-	resp, err := http.Get(url)
+// installDirs returns the folder to install to and the folder to link the binary from. Without a prefix the
+// installed binary is replaced in its folder, and its existing links are kept
+func installDirs(prefix string) (string, string, error) {
+	if prefix == "" {
+		installDir, err := util.GetRunaiConfigDir()
+		return installDir, "", err
+	}
+	if strings.HasPrefix(prefix, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", err
+		}
+		prefix = path.Join(home, prefix[2:])
+	}
+	prefix, err := filepath.Abs(prefix)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	defer resp.Body.Close()
+	return path.Join(prefix, config.CLIName), path.Join(prefix, "bin"), nil
+}
 
-	// Create the file
-	downloadPath := path.Join(os.TempDir(), assetName)
+// checkUpdateVersion refuses to install a version that is older than or the same as the installed version, unless forced
+func checkUpdateVersion(currentVersion string, newVersion string, force bool) error {
+	if currentVersion == "" {
+		log.Warnf("The installed version is unknown, installing version %s", newVersion)
+		return nil
+	}
+	comparison, err := compareVersions(newVersion, currentVersion)
+	if err != nil {
+		return err
+	}
+
+	reason := ""
+	if comparison == 0 {
+		reason = fmt.Sprintf("Version %s is already installed", currentVersion)
+	} else if comparison < 0 {
+		reason = fmt.Sprintf("Version %s is older than the installed version %s", newVersion, currentVersion)
+	}
+	if reason == "" {
+		return nil
+	}
+	if force {
+		log.Warnf("%s, installing it anyway (--force was given)", reason)
+		return nil
+	}
+	return fmt.Errorf("%s\nUse --force to install it anyway", reason)
+}
 
-	out, err := os.Create(downloadPath)
+func printUpdateCheck(currentVersion string, latestVersion string) {
+	fmt.Printf("Installed version: %s\n", valueOrUnknown(currentVersion))
+	fmt.Printf("Latest version:    %s\n", latestVersion)
+	if currentVersion == "" {
+		return
+	}
+	comparison, err := compareVersions(latestVersion, currentVersion)
 	if err != nil {
-		return "", err
+		log.Warn(err)
+		return
+	}
+	if comparison > 0 {
+		fmt.Println("A newer version is available, run 'runai-adm update' to install it")
+	} else {
+		fmt.Println("The installed version is up to date")
 	}
+}
 
-	defer out.Close()
+// compareVersions returns -1, 0 or 1 if the first version is older, the same or newer than the second
+func compareVersions(first string, second string) (int, error) {
+	firstVersion, err := utilversion.ParseGeneric(first)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid version: %s", first)
+	}
+	comparison, err := firstVersion.Compare(second)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid version: %s", second)
+	}
+	return comparison, nil
+}
 
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
+func inPath(dir string) bool {
+	for _, pathDir := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.Clean(pathDir) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
 
-	log.Infof("Downloaded archive to %s", downloadPath)
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
 
-	return downloadPath, nil
+func optionFromEnv(option *string, env string) {
+	if *option == "" {
+		*option = os.Getenv(env)
+	}
 }
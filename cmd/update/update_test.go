@@ -0,0 +1,199 @@
+package update
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/run-ai/runai-cli/pkg/config"
+)
+
+func TestCheckUpdateVersion(t *testing.T) {
+	tests := []struct {
+		name           string
+		currentVersion string
+		newVersion     string
+		force          bool
+		expectError    bool
+	}{
+		{name: "newer version", currentVersion: "v0.0.13", newVersion: "v0.0.14"},
+		{name: "newer version without v prefix", currentVersion: "0.0.9", newVersion: "v0.0.14"},
+		{name: "unknown installed version", currentVersion: "", newVersion: "v0.0.14"},
+		{name: "same version", currentVersion: "v0.0.14", newVersion: "v0.0.14", expectError: true},
+		{name: "older version", currentVersion: "v0.0.14", newVersion: "v0.0.9", expectError: true},
+		{name: "same version forced", currentVersion: "v0.0.14", newVersion: "v0.0.14", force: true},
+		{name: "older version forced", currentVersion: "v0.0.14", newVersion: "v0.0.9", force: true},
+		{name: "invalid new version", currentVersion: "v0.0.14", newVersion: "latest", expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkUpdateVersion(test.currentVersion, test.newVersion, test.force)
+			if test.expectError && err == nil {
+				t.Error("Expected an error")
+			} else if !test.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "archive.tar.gz")
+	if err := ioutil.WriteFile(filePath, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		expected    string
+		expectError bool
+	}{
+		{name: "matching checksum", expected: testChecksum},
+		{name: "matching upper case checksum", expected: " " + strings.ToUpper(testChecksum) + " "},
+		{name: "no expected checksum", expected: ""},
+		{name: "other checksum", expected: strings.Repeat("a", 64), expectError: true},
+		{name: "invalid checksum", expected: "abc", expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyChecksum(filePath, test.expected)
+			if test.expectError && err == nil {
+				t.Error("Expected an error")
+			} else if !test.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "runai-adm-update-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeReleaseArchive writes a release archive with the binary and the VERSION file, as the release build packs
+// them, and returns its path and checksum
+func writeReleaseArchive(t *testing.T, dir string, version string) (string, string) {
+	archivePath := path.Join(dir, "runai-adm-"+version+"-linux-amd64.tar.gz")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	files := []struct{ name, content string }{
+		{"runai-adm-" + version + "/" + config.CLIName, "binary " + version},
+		{"runai-adm-" + version + "/" + versionFileName, version + "\n"},
+		{"runai-adm-" + version + "/install-runai.sh", "#!/bin/sh"},
+	}
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Mode: 0755, Size: int64(len(file.content)), Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return archivePath, hex.EncodeToString(sum[:])
+}
+
+func TestUpdateFromFile(t *testing.T) {
+	tests := []struct {
+		name             string
+		currentVersion   string
+		checksumFile     bool
+		checksumFlag     bool
+		wrongChecksum    bool
+		skipChecksum     bool
+		force            bool
+		expectError      bool
+		expectsInstalled bool
+	}{
+		{name: "checksum file next to the archive", currentVersion: "v0.0.13", checksumFile: true, expectsInstalled: true},
+		{name: "checksum flag", currentVersion: "v0.0.13", checksumFlag: true, expectsInstalled: true},
+		{name: "wrong checksum flag", currentVersion: "v0.0.13", wrongChecksum: true, expectError: true},
+		{name: "no checksum", currentVersion: "v0.0.13", expectError: true},
+		{name: "no checksum skipped", currentVersion: "v0.0.13", skipChecksum: true, expectsInstalled: true},
+		{name: "same version", currentVersion: "v0.0.14", checksumFile: true, expectError: true},
+		{name: "same version forced", currentVersion: "v0.0.14", checksumFile: true, force: true, expectsInstalled: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			archivePath, archiveChecksum := writeReleaseArchive(t, dir, "v0.0.14")
+			if test.checksumFile {
+				content := archiveChecksum + "  " + path.Base(archivePath) + "\n"
+				if err := ioutil.WriteFile(archivePath+checksumSuffix, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			options := updateOptions{FromFile: archivePath, Prefix: path.Join(dir, "prefix"), SkipChecksum: test.skipChecksum, Force: test.force}
+			if test.checksumFlag {
+				options.Checksum = archiveChecksum
+			}
+			if test.wrongChecksum {
+				options.Checksum = strings.Repeat("a", 64)
+			}
+
+			err := updateFromFile(test.currentVersion, options)
+			if test.expectError {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			binaryPath := path.Join(dir, "prefix", "bin", config.CLIName)
+			binary, err := ioutil.ReadFile(binaryPath)
+			if !test.expectsInstalled {
+				if err == nil {
+					t.Errorf("Expected nothing to be installed, found: %v", binaryPath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected the binary to be linked from %v, error: %v", binaryPath, err)
+			}
+			if string(binary) != "binary v0.0.14" {
+				t.Errorf("Expected the binary of v0.0.14, got: %q", binary)
+			}
+			installedVersion, err := readVersionFile(path.Join(dir, "prefix", config.CLIName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if installedVersion != "v0.0.14" {
+				t.Errorf("Expected installed version: v0.0.14, got: %v", installedVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package upgrade
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/util"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	"github.com/run-ai/runai-cli/pkg/util/transaction"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	journalConfigMapName = "runai-adm-upgrade-journal"
+	journalKey           = "journal"
+
+	upgradeRunning     = "Running"
+	upgradeSucceeded   = "Succeeded"
+	upgradeFailed      = "Failed"
+	upgradeInterrupted = "Interrupted"
+
+	stepPending        = "Pending"
+	stepRunning        = "Running"
+	stepDone           = "Done"
+	stepFailed         = "Failed"
+	stepRolledBack     = "Rolled back"
+	stepRollbackFailed = "Rollback failed"
+)
+
+// upgradeJournal records the steps of an upgrade and their results in a ConfigMap, so an incomplete upgrade can be
+// resumed. The steps of a resumed upgrade are built from the flags and the operator images that it also records
+type upgradeJournal struct {
+	Status          string        `json:"status"`
+	StartTime       time.Time     `json:"startTime"`
+	EndTime         *time.Time    `json:"endTime,omitempty"`
+	FilePath        string        `json:"filePath,omitempty"`
+	OperatorVersion string        `json:"operatorVersion,omitempty"`
+	Image           string        `json:"image,omitempty"`
+	PreviousImage   string        `json:"previousImage,omitempty"`
+	Steps           []journalStep `json:"steps"`
+
+	client *client.Client
+}
+
+type journalStep struct {
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	StartTime *time.Time `json:"startTime,omitempty"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+func newJournal(client *client.Client, upgradeFlags upgradeFlags) *upgradeJournal {
+	return &upgradeJournal{
+		StartTime:       time.Now(),
+		FilePath:        upgradeFlags.filePath,
+		OperatorVersion: upgradeFlags.operatorVersion,
+		Image:           upgradeFlags.image,
+		client:          client,
+	}
+}
+
+func (journal *upgradeJournal) flags() upgradeFlags {
+	return upgradeFlags{filePath: journal.FilePath, operatorVersion: journal.OperatorVersion, image: journal.Image}
+}
+
+// readJournal returns the journal of the last upgrade, or nil if there is none
+func readJournal(client *client.Client) (*upgradeJournal, error) {
+	configMap, err := client.GetClientset().CoreV1().ConfigMaps(common.RunaiNamespace).Get(journalConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the upgrade journal, error: %w", err)
+	}
+	journal := &upgradeJournal{client: client}
+	if err := yaml.Unmarshal([]byte(configMap.Data[journalKey]), journal); err != nil {
+		return nil, fmt.Errorf("Failed to parse the upgrade journal, error: %w", err)
+	}
+	return journal, nil
+}
+
+// run runs the steps from the step at index first, and records their progress
+func (journal *upgradeJournal) run(steps []transaction.Step, first int) error {
+	if first == 0 {
+		journal.Steps = nil
+		for _, step := range steps {
+			journal.Steps = append(journal.Steps, journalStep{Name: step.Name, Status: stepPending})
+		}
+	}
+	journal.Status = upgradeRunning
+	journal.save()
+
+	err := transaction.Resume(steps, first, journal)
+	switch {
+	case err == nil:
+		journal.Status = upgradeSucceeded
+	case transaction.IsInterrupted(err):
+		journal.Status = upgradeInterrupted
+	default:
+		journal.Status = upgradeFailed
+	}
+	now := time.Now()
+	journal.EndTime = &now
+	journal.save()
+	return err
+}
+
+// completed returns whether the upgrade succeeded. Any other upgrade can be resumed
+func (journal *upgradeJournal) completed() bool {
+	return journal.Status == upgradeSucceeded
+}
+
+// matches returns whether the journal records the given steps, i.e. that it was written by a runai-adm that upgrades
+// with the same steps
+func (journal *upgradeJournal) matches(steps []transaction.Step) bool {
+	if len(journal.Steps) != len(steps) {
+		return false
+	}
+	for i, step := range steps {
+		if journal.Steps[i].Name != step.Name {
+			return false
+		}
+	}
+	return true
+}
+
+// firstIncompleteStep returns the index of the first step that was not done. A step that was running when the
+// upgrade stopped, that failed or that was rolled back is run again
+func (journal *upgradeJournal) firstIncompleteStep() int {
+	for i, step := range journal.Steps {
+		if step.Status != stepDone {
+			return i
+		}
+	}
+	return len(journal.Steps)
+}
+
+// lastStep returns the name of the last step that was started, or done
+func (journal *upgradeJournal) lastStep() string {
+	last := "-"
+	for _, step := range journal.Steps {
+		if step.Status == stepPending {
+			break
+		}
+		last = step.Name
+	}
+	return last
+}
+
+// operatorDowntime returns how long a successful upgrade kept the Run:AI operator scaled down
+func (journal *upgradeJournal) operatorDowntime() (time.Duration, bool) {
+	if journal.Status != upgradeSucceeded {
+		return 0, false
+	}
+	var scaledDown, scaledUp *time.Time
+	for _, step := range journal.Steps {
+		switch step.Name {
+		case scaleDownOperatorStep:
+			scaledDown = step.StartTime
+		case scaleUpOperatorStep:
+			scaledUp = step.EndTime
+		}
+	}
+	if scaledDown == nil || scaledUp == nil {
+		return 0, false
+	}
+	return scaledUp.Sub(*scaledDown).Round(time.Second), true
+}
+
+func (journal *upgradeJournal) StepStarted(step transaction.Step) {
+	now := time.Now()
+	journal.setStep(step.Name, func(journalStep *journalStep) {
+		journalStep.Status = stepRunning
+		journalStep.StartTime = &now
+		journalStep.EndTime = nil
+		journalStep.Error = ""
+	})
+}
+
+func (journal *upgradeJournal) StepDone(step transaction.Step, err error) {
+	now := time.Now()
+	journal.setStep(step.Name, func(journalStep *journalStep) {
+		journalStep.Status = stepDone
+		journalStep.EndTime = &now
+		if err != nil {
+			journalStep.Status = stepFailed
+			journalStep.Error = err.Error()
+		}
+	})
+}
+
+func (journal *upgradeJournal) StepRolledBack(step transaction.Step, err error) {
+	journal.setStep(step.Name, func(journalStep *journalStep) {
+		journalStep.Status = stepRolledBack
+		if err != nil {
+			journalStep.Status = stepRollbackFailed
+			journalStep.Error = err.Error()
+		}
+	})
+}
+
+func (journal *upgradeJournal) setStep(name string, update func(journalStep *journalStep)) {
+	for i := range journal.Steps {
+		if journal.Steps[i].Name == name {
+			update(&journal.Steps[i])
+		}
+	}
+	journal.save()
+}
+
+// save writes the journal to its ConfigMap. A failure to write it does not fail the upgrade, which only loses the
+// ability to resume it
+func (journal *upgradeJournal) save() {
+	data, err := yaml.Marshal(journal)
+	if err != nil {
+		log.Warnf("Failed to format the upgrade journal, error: %v", err)
+		return
+	}
+	configMaps := journal.client.GetClientset().CoreV1().ConfigMaps(common.RunaiNamespace)
+	err = retry.Mutation("save the upgrade journal", func() error {
+		configMap, err := configMaps.Get(journalConfigMapName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			configMap = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: journalConfigMapName, Namespace: common.RunaiNamespace},
+				Data:       map[string]string{journalKey: string(data)},
+			}
+			_, err = configMaps.Create(configMap)
+			if errors.IsAlreadyExists(err) {
+				// created concurrently, the next attempt updates it
+				err = errors.NewConflict(v1.Resource("configmaps"), journalConfigMapName, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		configMap.Data = map[string]string{journalKey: string(data)}
+		_, err = configMaps.Update(configMap)
+		return err
+	})
+	if err != nil {
+		log.Warnf("Failed to save the upgrade journal, error: %v", err)
+	}
+}
+
+func statusCommand() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "status",
+		Short: "Show the steps of the last upgrade and their results",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			journal, err := readJournal(client)
+			if err != nil {
+				return err
+			}
+			if journal == nil {
+				fmt.Println("No upgrade was recorded on the cluster")
+				return nil
+			}
+			printJournal(journal)
+			return nil
+		},
+	}
+	return command
+}
+
+func printJournal(journal *upgradeJournal) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "Status:\t%s\n", journal.Status)
+	fmt.Fprintf(writer, "Started:\t%s\n", formatTime(&journal.StartTime))
+	fmt.Fprintf(writer, "Finished:\t%s\n", formatTime(journal.EndTime))
+	if journal.FilePath != "" {
+		fmt.Fprintf(writer, "File:\t%s\n", journal.FilePath)
+	}
+	if journal.OperatorVersion != "" {
+		fmt.Fprintf(writer, "Version:\t%s\n", journal.OperatorVersion)
+	}
+	if journal.Image != "" {
+		fmt.Fprintf(writer, "Image:\t%s\n", journal.Image)
+	}
+	if journal.PreviousImage != "" {
+		fmt.Fprintf(writer, "Previous image:\t%s\n", journal.PreviousImage)
+	}
+	writer.Flush()
+	fmt.Println()
+
+	writer = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "STEP\tSTATUS\tSTARTED\tFINISHED\tERROR")
+	for _, step := range journal.Steps {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", step.Name, step.Status, formatTime(step.StartTime), formatTime(step.EndTime), util.ValueOrDash(step.Error))
+	}
+	writer.Flush()
+
+	switch journal.Status {
+	case upgradeRunning:
+		fmt.Printf("\nThe upgrade has not completed, it is at step: %s. If its command is no longer running, continue it with 'upgrade --resume'\n", journal.lastStep())
+	case upgradeFailed, upgradeInterrupted:
+		fmt.Printf("\nThe upgrade has not completed, continue it from its first step that was not done with 'upgrade --resume'\n")
+	}
+}
+
+func formatTime(value *time.Time) string {
+	if value == nil {
+		return "-"
+	}
+	return value.Local().Format(time.RFC3339)
+}
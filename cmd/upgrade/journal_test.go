@@ -0,0 +1,28 @@
+package upgrade
+
+import "testing"
+
+func TestFirstIncompleteStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		expected int
+	}{
+		{name: "killed while running", statuses: []string{stepDone, stepRunning, stepPending}, expected: 1},
+		{name: "failed step", statuses: []string{stepDone, stepFailed, stepPending}, expected: 1},
+		{name: "interrupted and rolled back", statuses: []string{stepDone, stepRolledBack, stepRolledBack, stepPending}, expected: 1},
+		{name: "all rolled back", statuses: []string{stepRolledBack, stepDone, stepRollbackFailed}, expected: 0},
+		{name: "all done", statuses: []string{stepDone, stepDone}, expected: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			journal := &upgradeJournal{}
+			for _, status := range test.statuses {
+				journal.Steps = append(journal.Steps, journalStep{Status: status})
+			}
+			if first := journal.firstIncompleteStep(); first != test.expected {
+				t.Errorf("Expected the first incomplete step: %d, got: %d", test.expected, first)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package upgrade
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/run-ai/runai-cli/autogenerate"
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/pkg/client"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+)
+
+// plannedStep is a step of the upgrade and what it would change in the cluster
+type plannedStep struct {
+	Name    string
+	Summary string
+	Details []string
+}
+
+// planUpgrade returns the steps of an upgrade with the given flags, in the order they would run, and what each of
+// them would change in the cluster. Nothing is changed
+func planUpgrade(client *client.Client, upgradeFlags upgradeFlags) ([]plannedStep, error) {
+	operatorReplicas := int32(0)
+	previousImage := ""
+	if upgradeFlags.operatorVersion != "" || upgradeFlags.image != "" {
+		deployment, err := getOperatorDeployment(client)
+		if err != nil {
+			return nil, err
+		}
+		if len(deployment.Spec.Template.Spec.Containers) == 0 {
+			return nil, fmt.Errorf("The Run:AI operator has no containers")
+		}
+		previousImage = deployment.Spec.Template.Spec.Containers[0].Image
+		operatorReplicas = 1
+		if deployment.Spec.Replicas != nil {
+			operatorReplicas = *deployment.Spec.Replicas
+		}
+	}
+
+	image, shouldDeleteStsAndPvc := "", false
+	if previousImage != "" {
+		var err error
+		image, shouldDeleteStsAndPvc, err = targetImage(previousImage, upgradeFlags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	steps, err := upgradeSteps(client, upgradeFlags, previousImage)
+	if err != nil {
+		return nil, err
+	}
+
+	var planned []plannedStep
+	for _, step := range steps {
+		plannedStep := plannedStep{Name: step.Name}
+		var err error
+		switch step.Name {
+		case applyFileStep:
+			var data []byte
+			data, err = ioutil.ReadFile(upgradeFlags.filePath)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read %v, error: %w", upgradeFlags.filePath, err)
+			}
+			plannedStep.Summary, plannedStep.Details, err = planManifest(client, data)
+		case applyPreUpgradeYamlsStep:
+			plannedStep.Summary, plannedStep.Details, err = planManifest(client, []byte(autogenerate.PreInstallYaml))
+		case scaleDownOperatorStep:
+			plannedStep.Summary = fmt.Sprintf("%d -> 0 replicas", operatorReplicas)
+		case deleteJobsStep:
+			plannedStep.Summary, plannedStep.Details, err = planJobsDeletion(client)
+		case updateOperatorVersionStep:
+			plannedStep.Summary = fmt.Sprintf("%s -> %s", previousImage, image)
+			if image == previousImage {
+				plannedStep.Summary = fmt.Sprintf("%s, unchanged", image)
+			}
+		case deleteStatefulResourcesStep:
+			plannedStep.Summary, plannedStep.Details, err = planStatefulResourcesDeletion(client, shouldDeleteStsAndPvc)
+		case scaleUpOperatorStep:
+			plannedStep.Summary = fmt.Sprintf("0 -> %d replicas", operatorReplicas)
+		}
+		if err != nil {
+			return nil, err
+		}
+		planned = append(planned, plannedStep)
+	}
+	return planned, nil
+}
+
+// planManifest returns how many of the objects of the manifest kubectl apply would create or change, and the objects
+// that it would create or change. An object is unchanged when the configuration that was last applied to it is the
+// one in the manifest
+func planManifest(client *client.Client, data []byte) (string, []string, error) {
+	groupResources, err := restmapper.GetAPIGroupResources(client.GetClientset().Discovery())
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to discover the resources of the cluster, error: %w", err)
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(groupResources)
+
+	var details []string
+	total, toCreate, toChange := 0, 0, 0
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		manifestObject := map[string]interface{}{}
+		if err := decoder.Decode(&manifestObject); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", nil, fmt.Errorf("Failed to parse the manifest, error: %w", err)
+		}
+		if len(manifestObject) == 0 {
+			continue
+		}
+		object := &unstructured.Unstructured{Object: manifestObject}
+		total++
+
+		current, err := getManifestObject(client, mapper, object)
+		if err != nil {
+			return "", nil, err
+		}
+		if current == nil {
+			toCreate++
+			details = append(details, fmt.Sprintf("+ %s", describeObject(object)))
+		} else if appliedConfigurationChanged(current, manifestObject) {
+			toChange++
+			details = append(details, fmt.Sprintf("~ %s", describeObject(object)))
+		}
+	}
+	return fmt.Sprintf("%d objects: %d to create, %d to change, %d unchanged", total, toCreate, toChange, total-toCreate-toChange), details, nil
+}
+
+// getManifestObject returns the object of the cluster that an object of a manifest would be applied to, or nil if
+// there is none
+func getManifestObject(client *client.Client, mapper meta.RESTMapper, object *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	gvk := object.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// the kind is defined by a CRD of the manifest, which is not created yet
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find the resource of %s, error: %w", describeObject(object), err)
+	}
+
+	var resources dynamic.ResourceInterface = client.GetDynamicClient().Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		namespace := object.GetNamespace()
+		if namespace == "" {
+			namespace = client.GetDefaultNamespace()
+		}
+		resources = client.GetDynamicClient().Resource(mapping.Resource).Namespace(namespace)
+	}
+	current, err := resources.Get(object.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s, error: %w", describeObject(object), err)
+	}
+	return current, nil
+}
+
+// appliedConfigurationChanged returns whether the manifest object differs from the configuration that kubectl apply
+// recorded on the object of the cluster. An object that was not created by kubectl apply is changed by it
+func appliedConfigurationChanged(current *unstructured.Unstructured, manifestObject map[string]interface{}) bool {
+	lastApplied, found := current.GetAnnotations()[v1.LastAppliedConfigAnnotation]
+	if !found {
+		return true
+	}
+	applied := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lastApplied), &applied); err != nil {
+		log.Debugf("Ignoring the invalid %s annotation of %s, error: %v", v1.LastAppliedConfigAnnotation, current.GetName(), err)
+		return true
+	}
+	// the numbers of the manifest are compared as JSON numbers, as the ones of the annotation
+	manifestJSON, err := json.Marshal(manifestObject)
+	if err != nil {
+		return true
+	}
+	manifest := map[string]interface{}{}
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return true
+	}
+	// kubectl records the namespace it applied the object to, also when the manifest has none
+	unstructured.RemoveNestedField(applied, "metadata", "namespace")
+	unstructured.RemoveNestedField(manifest, "metadata", "namespace")
+	return !reflect.DeepEqual(applied, manifest)
+}
+
+func describeObject(object *unstructured.Unstructured) string {
+	if object.GetNamespace() == "" {
+		return fmt.Sprintf("%s %s", object.GetKind(), object.GetName())
+	}
+	return fmt.Sprintf("%s %s/%s", object.GetKind(), object.GetNamespace(), object.GetName())
+}
+
+func planJobsDeletion(client *client.Client) (string, []string, error) {
+	jobList, err := client.GetClientset().BatchV1().Jobs(common.RunaiNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to list jobs in the runai namespace, error: %w", err)
+	}
+	var details []string
+	for _, job := range jobList.Items {
+		details = append(details, fmt.Sprintf("- Job %s/%s", common.RunaiNamespace, job.Name))
+	}
+	return fmt.Sprintf("%d jobs to delete", len(jobList.Items)), details, nil
+}
+
+// planStatefulResourcesDeletion returns the stateful resources of the previous version that exist in the cluster and
+// would be deleted
+func planStatefulResourcesDeletion(client *client.Client, shouldDeleteStsAndPvc bool) (string, []string, error) {
+	if !shouldDeleteStsAndPvc {
+		return "nothing to delete, the stateful resources of the previous version are kept", nil, nil
+	}
+	var details []string
+	for _, name := range oldStatefulSets {
+		_, err := client.GetClientset().AppsV1().StatefulSets(common.RunaiNamespace).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("Failed to get statefulset: %s, error: %w", name, err)
+		}
+		details = append(details, fmt.Sprintf("- StatefulSet %s/%s", common.RunaiNamespace, name))
+	}
+	for _, name := range oldPersistentVolumeClaims {
+		_, err := client.GetClientset().CoreV1().PersistentVolumeClaims(common.RunaiNamespace).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("Failed to get PVC: %s, error: %w", name, err)
+		}
+		details = append(details, fmt.Sprintf("- PersistentVolumeClaim %s/%s", common.RunaiNamespace, name))
+	}
+	return fmt.Sprintf("%d statefulsets and PVCs to delete", len(details)), details, nil
+}
+
+func printUpgradePlan(client *client.Client, steps []plannedStep) {
+	fmt.Printf("Upgrade plan: %d steps\n", len(steps))
+	scaleDown, scaleUp := 0, 0
+	for i, step := range steps {
+		if step.Summary == "" {
+			fmt.Printf("%d. %s\n", i+1, step.Name)
+		} else {
+			fmt.Printf("%d. %s: %s\n", i+1, step.Name, step.Summary)
+		}
+		for _, detail := range step.Details {
+			fmt.Printf("     %s\n", detail)
+		}
+		switch step.Name {
+		case scaleDownOperatorStep:
+			scaleDown = i + 1
+		case scaleUpOperatorStep:
+			scaleUp = i + 1
+		}
+	}
+
+	if scaleDown == 0 {
+		fmt.Println("The Run:AI operator is not scaled down")
+		return
+	}
+	fmt.Printf("The Run:AI operator is scaled down from step %d until step %d\n", scaleDown, scaleUp)
+	journal, err := readJournal(client)
+	if err != nil {
+		log.Debugf("Failed to read the journal of the last upgrade, error: %v", err)
+		return
+	}
+	if journal == nil {
+		return
+	}
+	if downtime, found := journal.operatorDowntime(); found {
+		fmt.Printf("The last upgrade scaled it down for: %v\n", downtime)
+	}
+}
@@ -24,13 +24,46 @@ import (
 	"github.com/run-ai/runai-cli/autogenerate"
 	"github.com/run-ai/runai-cli/cmd/common"
 	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/util"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
 	"github.com/run-ai/runai-cli/pkg/util/kubectl"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	"github.com/run-ai/runai-cli/pkg/util/transaction"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// the names of the upgrade steps, which the journal of an upgrade records to resume it
+const (
+	applyFileStep               = "Apply the Run:AI configuration file"
+	applyPreUpgradeYamlsStep    = "Apply the pre-upgrade yamls"
+	scaleDownOperatorStep       = "Scale down the Run:AI operator"
+	deleteJobsStep              = "Delete Run:AI jobs"
+	updateOperatorVersionStep   = "Update the Run:AI operator version"
+	deleteStatefulResourcesStep = "Delete Run:AI stateful resources"
+	scaleUpOperatorStep         = "Scale up the Run:AI operator"
+)
+
+var (
+	// lastStatefulResourcesVersion is the last version with the stateful resources below, which are deleted when
+	// upgrading from it or from an older version
+	lastStatefulResourcesVersion = [3]int{1, 0, 92}
+
+	oldStatefulSets = []string{
+		"runai-db",
+		"runai-prometheus-pushgateway",
+		"prometheus-runai-prometheus-operator-prometheus",
+	}
+	oldPersistentVolumeClaims = []string{
+		"data-runai-db-0",
+		"prometheus-runai-prometheus-operator-prometheus-db-prometheus-runai-prometheus-operator-prometheus-0",
+		"storage-volume-runai-prometheus-pushgateway-0",
+	}
+)
+
 type upgradeFlags struct {
 	filePath        string
 	operatorVersion string
@@ -39,45 +72,47 @@ type upgradeFlags struct {
 
 func Command() *cobra.Command {
 	upgradeFlags := upgradeFlags{}
+	resume := false
+	plan := false
 	var command = &cobra.Command{
 		Use:   "upgrade",
 		Short: "Upgrade Run:AI cluster",
 		Args:  cobra.ExactArgs(0),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if cmd.Flags().NFlag() == 0 {
-				fmt.Println("No flags were provided")
 				cmd.HelpFunc()(cmd, args)
-				return
+				return commandUtil.Validationf("No flags were provided")
 			}
-
-			if upgradeFlags.filePath != "" {
-				log.Infof("Installing from file: %v", upgradeFlags.filePath)
-				for i := 0; i < 2; i++ {
-					kubectl.Apply(upgradeFlags.filePath) // need to remove the crds from this file
-				}
+			if resume && (upgradeFlags.filePath != "" || upgradeFlags.operatorVersion != "" || upgradeFlags.image != "") {
+				return commandUtil.Validationf("--resume cannot be used together with --file or --version, the upgrade is resumed with the flags it was started with")
+			}
+			if resume && plan {
+				return commandUtil.Validationf("--plan cannot be used together with --resume, see 'upgrade status' for the steps of the last upgrade")
 			}
 
-			upgradeYamlsBeforeRun()
-
-			if upgradeFlags.operatorVersion != "" || upgradeFlags.image != "" {
-				client := client.GetClient()
-				common.ScaleRunaiOperator(client, 0)
-				josList, err := client.GetClientset().BatchV1().Jobs("runai").List(metav1.ListOptions{})
+			client, err := client.GetClient()
+			if err != nil {
+				return err
+			}
+			if plan {
+				steps, err := planUpgrade(client, upgradeFlags)
 				if err != nil {
-					fmt.Printf("Failed to list jobs in the runai namespace, error: %v", err)
-					os.Exit(1)
+					return err
 				}
-				for _, job := range josList.Items {
-					client.GetClientset().BatchV1().Jobs("runai").Delete(job.Name, &metav1.DeleteOptions{})
-					log.Debugf("Deleted Job: %v", job.Name)
-				}
-
-				upgradeVersion(client, upgradeFlags)
-
-				common.ScaleRunaiOperator(client, 1)
+				printUpgradePlan(client, steps)
+				return nil
+			}
+			if resume {
+				err = resumeUpgrade(client)
+			} else {
+				err = startUpgrade(client, upgradeFlags)
+			}
+			if err != nil {
+				return err
 			}
 
 			log.Println("Successfully upgraded the Run:AI Cluster")
+			return nil
 		},
 	}
 
@@ -85,93 +120,316 @@ func Command() *cobra.Command {
 	command.Flags().StringVarP(&upgradeFlags.operatorVersion, "version", "v", "", "Set a Run:AI version (e.g. 1.0.45)")
 	command.Flags().StringVarP(&upgradeFlags.image, "image", "i", "", "set image")
 	command.Flags().MarkHidden("image")
+	command.Flags().BoolVar(&plan, "plan", false, "Only print the ordered steps of the upgrade and the objects that each of them would change, without upgrading.")
+	command.Flags().BoolVar(&resume, "resume", false, "Continue the last upgrade from the step it stopped at, e.g. when its command was killed or interrupted, or a step failed. See 'upgrade status'.")
 
+	command.AddCommand(statusCommand())
 	return command
 }
 
-func upgradeYamlsBeforeRun() {
+// startUpgrade runs a new upgrade and records its steps in the upgrade journal
+func startUpgrade(client *client.Client, upgradeFlags upgradeFlags) error {
+	previous, err := readJournal(client)
+	if err != nil {
+		log.Debugf("Failed to read the journal of the previous upgrade, error: %v", err)
+	} else if previous != nil && !previous.completed() {
+		log.Warnf("The previous upgrade did not complete (%s), it stopped at step: %s. Starting a new upgrade, use 'upgrade --resume' to continue the previous one instead", previous.Status, previous.lastStep())
+	}
+
+	journal := newJournal(client, upgradeFlags)
+	if upgradeFlags.operatorVersion != "" || upgradeFlags.image != "" {
+		journal.PreviousImage, err = getOperatorImage(client)
+		if err != nil {
+			return err
+		}
+	}
+	steps, err := upgradeSteps(client, journal.flags(), journal.PreviousImage)
+	if err != nil {
+		return err
+	}
+	return journal.run(steps, 0)
+}
+
+// resumeUpgrade continues the upgrade of the journal from its first step that was not done. An upgrade that was
+// interrupted or failed is resumed as well, its steps that were rolled back are run again
+func resumeUpgrade(client *client.Client) error {
+	journal, err := readJournal(client)
+	if err != nil {
+		return err
+	}
+	if journal == nil {
+		return fmt.Errorf("No upgrade was recorded on the cluster, there is no upgrade to resume")
+	}
+	if journal.completed() {
+		return fmt.Errorf("The last upgrade has status: %s, there is no upgrade to resume", journal.Status)
+	}
+	steps, err := upgradeSteps(client, journal.flags(), journal.PreviousImage)
+	if err != nil {
+		return err
+	}
+	if !journal.matches(steps) {
+		return fmt.Errorf("The last upgrade was run by another version of runai-adm with other steps, it cannot be resumed")
+	}
+	first := journal.firstIncompleteStep()
+	if journal.FilePath != "" && first < len(steps) && steps[first].Name == applyFileStep {
+		if _, err := os.Stat(journal.FilePath); err != nil {
+			return commandUtil.Validation(fmt.Errorf("The file of the upgrade %v cannot be read, resume the upgrade from the host it was started on, error: %w", journal.FilePath, err))
+		}
+	}
+	if first < len(steps) {
+		log.Infof("Resuming the upgrade at step: %s", steps[first].Name)
+	}
+	return journal.run(steps, first)
+}
+
+// upgradeSteps returns the steps of an upgrade. The steps of the operator restore the operator image and replicas
+// on failure or interrupt. The steps only depend on their arguments, so that a resumed upgrade has the same steps
+func upgradeSteps(client *client.Client, upgradeFlags upgradeFlags, previousImage string) ([]transaction.Step, error) {
+	var steps []transaction.Step
+	if upgradeFlags.filePath != "" {
+		steps = append(steps, transaction.Step{
+			Name: applyFileStep,
+			Do: func() error {
+				log.Infof("Installing from file: %v", upgradeFlags.filePath)
+				var err error
+				for i := 0; i < 2; i++ {
+					err = kubectl.Apply(upgradeFlags.filePath) // need to remove the crds from this file
+				}
+				if err != nil {
+					return fmt.Errorf("Failed to apply %v, error: %w", upgradeFlags.filePath, err)
+				}
+				return nil
+			},
+		})
+	}
+	steps = append(steps, transaction.Step{
+		Name: applyPreUpgradeYamlsStep,
+		Do:   upgradeYamlsBeforeRun,
+	})
+	if upgradeFlags.operatorVersion == "" && upgradeFlags.image == "" {
+		return steps, nil
+	}
+
+	image, shouldDeleteStsAndPvc, err := targetImage(previousImage, upgradeFlags)
+	if err != nil {
+		return nil, err
+	}
+	return append(steps,
+		transaction.Step{
+			Name:     scaleDownOperatorStep,
+			Do:       func() error { return common.ScaleDownRunaiOperator(client) },
+			Rollback: func() error { return common.RestoreRunaiOperator(client) },
+		},
+		transaction.Step{
+			Name: deleteJobsStep,
+			Do:   func() error { return deleteJobs(client) },
+		},
+		transaction.Step{
+			Name: updateOperatorVersionStep,
+			Do: func() error {
+				if util.ImageTag(previousImage) == "latest" && upgradeFlags.operatorVersion != "latest" {
+					log.Infof("Setting image to 'latest' as an old image was 'latest'")
+				}
+				if err := setOperatorImage(client, image); err != nil {
+					return fmt.Errorf("Failed to update Run:AI operator with new tag, error: %w", err)
+				}
+				return nil
+			},
+			Rollback: func() error { return setOperatorImage(client, previousImage) },
+		},
+		transaction.Step{
+			Name: deleteStatefulResourcesStep,
+			Do: func() error {
+				if !shouldDeleteStsAndPvc {
+					return nil
+				}
+				return deleteStatefulResources(client)
+			},
+		},
+		transaction.Step{
+			Name: scaleUpOperatorStep,
+			Do:   func() error { return common.RestoreRunaiOperator(client) },
+		},
+	), nil
+}
+
+func upgradeYamlsBeforeRun() error {
 	log.Infof("Upgrading yamls before upgrade")
 	file, err := ioutil.TempFile("", "pre_upgrade.yaml")
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
 	defer os.Remove(file.Name())
 	if _, err := file.Write([]byte(autogenerate.PreInstallYaml)); err != nil {
-		fmt.Errorf("failed to write file error: %v", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to write file error: %w", err)
 	}
 
-	kubectl.Apply(file.Name())
+	if err := kubectl.Apply(file.Name()); err != nil {
+		return fmt.Errorf("Failed to apply the pre-upgrade yamls, error: %w", err)
+	}
+	return nil
 }
 
-func upgradeVersion(client *client.Client, upgradeFlags upgradeFlags) {
-	var err error
-	var deployment *appsv1.Deployment
-	shouldDeleteStsAndPvc := false
-	for i := 0; i < common.NumberOfRetiresForApiServer; i++ {
-		deployment, err = client.GetClientset().AppsV1().Deployments("runai").Get("runai-operator", metav1.GetOptions{})
+func deleteJobs(client *client.Client) error {
+	josList, err := client.GetClientset().BatchV1().Jobs(common.RunaiNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to list jobs in the runai namespace, error: %w", err)
+	}
+	failed := 0
+	for _, job := range josList.Items {
+		err := retry.Deletion(fmt.Sprintf("delete job %v", job.Name), func() error {
+			return client.GetClientset().BatchV1().Jobs(common.RunaiNamespace).Delete(job.Name, &metav1.DeleteOptions{})
+		})
 		if err != nil {
-			log.Infof("Run:AI operator does not exist on runai namespace, error: %v", err)
-			os.Exit(1)
-		}
-		currentImage := strings.Split(deployment.Spec.Template.Spec.Containers[0].Image, ":")
-		currentTag := currentImage[1]
-		if currentTag == "latest" {
-			if upgradeFlags.operatorVersion != "latest" {
-				log.Infof("Setting image to 'latest' as an old image was 'latest'")
-			}
-		} else {
-			if upgradeFlags.image != "" {
-				deployment.Spec.Template.Spec.Containers[0].Image = upgradeFlags.image
-				shouldDeleteStsAndPvc = true
-			} else {
-				currentMinorVersion := strings.Split(currentTag, ".")
-				currentMinorInt, _ := strconv.Atoi(currentMinorVersion[2])
-				deployment.Spec.Template.Spec.Containers[0].Image = fmt.Sprintf("%s:%s", currentImage[0], upgradeFlags.operatorVersion)
-				shouldDeleteStsAndPvc = currentMinorInt <= 92
-			}
+			log.Warnf("Failed to delete job: %v, error: %v", job.Name, err)
+			failed++
+			continue
 		}
-		_, err = client.GetClientset().AppsV1().Deployments("runai").Update(deployment)
+		log.Debugf("Deleted Job: %v", job.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to delete %d of %d jobs in the runai namespace", failed, len(josList.Items))
+	}
+	return nil
+}
+
+// targetImage returns the operator image to upgrade to from the previous image, and whether the stateful resources
+// of the previous version should be deleted. They are deleted only when the tag of the previous image is a version up
+// to 1.0.92, and never when it cannot be parsed, e.g. for an image that is pinned by a digest
+func targetImage(previousImage string, upgradeFlags upgradeFlags) (string, bool, error) {
+	repository, tag, _ := util.ParseImage(previousImage)
+	if tag == "latest" {
+		return previousImage, false, nil
+	}
+	deleteStatefulResources, err := hasStatefulResources(tag)
+	if upgradeFlags.image != "" {
 		if err != nil {
-			log.Debugf("Failed to update the deployment of the Run:AI operator, attempt: %v, error: %v", i, err)
-			continue
+			log.Debugf("Keeping the stateful resources of the previous version, error: %v", err)
+			return upgradeFlags.image, false, nil
 		}
-		break
+		return upgradeFlags.image, deleteStatefulResources, nil
 	}
 	if err != nil {
-		log.Infof("Failed to update Run:AI operator with new tag, error: %v", err)
-		os.Exit(1)
+		return "", false, commandUtil.Validation(fmt.Errorf("Failed to upgrade the Run:AI operator image %s by version, error: %w", previousImage, err))
 	}
+	return fmt.Sprintf("%s:%s", repository, upgradeFlags.operatorVersion), deleteStatefulResources, nil
+}
 
-	if shouldDeleteStsAndPvc {
-		err = client.GetClientset().AppsV1().StatefulSets("runai").Delete("runai-db", &metav1.DeleteOptions{})
-		if err == nil {
-			log.Debugf("Deleted Statefulset: runai-db")
+// hasStatefulResources returns whether a version tag is a version up to 1.0.92, which has the stateful resources
+func hasStatefulResources(tag string) (bool, error) {
+	version, err := parseVersionTag(tag)
+	if err != nil {
+		return false, err
+	}
+	for i := range version {
+		if version[i] != lastStatefulResourcesVersion[i] {
+			return version[i] < lastStatefulResourcesVersion[i], nil
 		}
+	}
+	return true, nil
+}
 
-		err = client.GetClientset().AppsV1().StatefulSets("runai").Delete("runai-prometheus-pushgateway", &metav1.DeleteOptions{})
-		if err == nil {
-			log.Debugf("Deleted Statefulset: runai-prometheus-pushgateway")
+// parseVersionTag returns the major, minor and patch numbers of a version tag, e.g. 1, 0 and 92 of 1.0.92
+func parseVersionTag(tag string) ([3]int, error) {
+	var version [3]int
+	versionParts := strings.Split(tag, ".")
+	if len(versionParts) != len(version) {
+		return version, fmt.Errorf("the tag %q is not a version, e.g. 1.0.92", tag)
+	}
+	for i, part := range versionParts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return version, fmt.Errorf("the tag %q is not a version, e.g. 1.0.92", tag)
 		}
+		version[i] = number
+	}
+	return version, nil
+}
+
+func getOperatorImage(client *client.Client) (string, error) {
+	deployment, err := getOperatorDeployment(client)
+	if err != nil {
+		return "", err
+	}
+	if len(deployment.Spec.Template.Spec.Containers) == 0 {
+		return "", fmt.Errorf("The Run:AI operator has no containers")
+	}
+	return deployment.Spec.Template.Spec.Containers[0].Image, nil
+}
+
+func getOperatorDeployment(client *client.Client) (*appsv1.Deployment, error) {
+	deployment, err := client.GetClientset().AppsV1().Deployments(common.RunaiNamespace).Get(common.RunaiOperatorDeploymentName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, commandUtil.NotInstalled(fmt.Errorf("Run:AI operator does not exist on runai namespace"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the Run:AI operator, error: %w", err)
+	}
+	return deployment, nil
+}
 
-		err = client.GetClientset().AppsV1().StatefulSets("runai").Delete("prometheus-runai-prometheus-operator-prometheus", &metav1.DeleteOptions{})
-		if err == nil {
-			log.Debugf("Deleted Statefulset: prometheus-runai-prometheus-operator-prometheus")
+func setOperatorImage(client *client.Client, image string) error {
+	return updateOperatorDeployment(client, func(deployment *appsv1.Deployment) error {
+		if len(deployment.Spec.Template.Spec.Containers) == 0 {
+			return fmt.Errorf("The Run:AI operator has no containers")
 		}
+		deployment.Spec.Template.Spec.Containers[0].Image = image
+		return nil
+	})
+}
 
-		err = client.GetClientset().CoreV1().PersistentVolumeClaims("runai").Delete("data-runai-db-0", &metav1.DeleteOptions{})
-		if err == nil {
-			log.Debugf("Deleted PVC: data-runai-db-0")
+// updateOperatorDeployment updates the Run:AI operator with mutate, which fails the update when it returns an error
+func updateOperatorDeployment(client *client.Client, mutate func(deployment *appsv1.Deployment) error) error {
+	deployments := client.GetClientset().AppsV1().Deployments(common.RunaiNamespace)
+	err := retry.Mutation("update the Run:AI operator", func() error {
+		deployment, err := deployments.Get(common.RunaiOperatorDeploymentName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return commandUtil.NotInstalled(fmt.Errorf("Run:AI operator does not exist on runai namespace"))
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to get the Run:AI operator, error: %w", err)
+		}
+		if err := mutate(deployment); err != nil {
+			return err
 		}
+		_, err = deployments.Update(deployment)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to update the Run:AI operator, error: %w", err)
+	}
+	return nil
+}
 
-		err = client.GetClientset().CoreV1().PersistentVolumeClaims("runai").Delete("prometheus-runai-prometheus-operator-prometheus-db-prometheus-runai-prometheus-operator-prometheus-0", &metav1.DeleteOptions{})
-		if err == nil {
-			log.Debugf("Deleted PVC: prometheus-runai-prometheus-operator-prometheus-db-prometheus-runai-prometheus-operator-prometheus-0")
+// deleteStatefulResources deletes the stateful resources of the previous version, the ones that do not exist are
+// skipped
+func deleteStatefulResources(client *client.Client) error {
+	failed := 0
+	for _, name := range oldStatefulSets {
+		err := retry.Deletion(fmt.Sprintf("delete statefulset %s", name), func() error {
+			return client.GetClientset().AppsV1().StatefulSets(common.RunaiNamespace).Delete(name, &metav1.DeleteOptions{})
+		})
+		if err != nil {
+			log.Warnf("Failed to delete statefulset: %s, error: %v", name, err)
+			failed++
+			continue
 		}
+		log.Debugf("Deleted Statefulset: %s", name)
+	}
 
-		err = client.GetClientset().CoreV1().PersistentVolumeClaims("runai").Delete("storage-volume-runai-prometheus-pushgateway-0", &metav1.DeleteOptions{})
-		if err == nil {
-			log.Debugf("Deleted PVC: storage-volume-runai-prometheus-pushgateway-0")
+	for _, name := range oldPersistentVolumeClaims {
+		err := retry.Deletion(fmt.Sprintf("delete PVC %s", name), func() error {
+			return client.GetClientset().CoreV1().PersistentVolumeClaims(common.RunaiNamespace).Delete(name, &metav1.DeleteOptions{})
+		})
+		if err != nil {
+			log.Warnf("Failed to delete PVC: %s, error: %v", name, err)
+			failed++
+			continue
 		}
+		log.Debugf("Deleted PVC: %s", name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to delete %d of the stateful resources of the previous version", failed)
 	}
+	return nil
 }
@@ -0,0 +1,96 @@
+package upgrade
+
+import (
+	"testing"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestTargetImage(t *testing.T) {
+	tests := []struct {
+		name                   string
+		previousImage          string
+		flags                  upgradeFlags
+		expectedImage          string
+		expectedDeleteStateful bool
+		expectError            bool
+	}{
+		{name: "version from an old version", previousImage: "gcr.io/run-ai-prod/operator:1.0.45", flags: upgradeFlags{operatorVersion: "1.0.96"}, expectedImage: "gcr.io/run-ai-prod/operator:1.0.96", expectedDeleteStateful: true},
+		{name: "version from the last version with stateful resources", previousImage: "gcr.io/run-ai-prod/operator:1.0.92", flags: upgradeFlags{operatorVersion: "1.0.96"}, expectedImage: "gcr.io/run-ai-prod/operator:1.0.96", expectedDeleteStateful: true},
+		{name: "version from a new version", previousImage: "gcr.io/run-ai-prod/operator:1.0.93", flags: upgradeFlags{operatorVersion: "1.0.96"}, expectedImage: "gcr.io/run-ai-prod/operator:1.0.96"},
+		{name: "version from a newer minor version", previousImage: "gcr.io/run-ai-prod/operator:1.1.5", flags: upgradeFlags{operatorVersion: "1.1.6"}, expectedImage: "gcr.io/run-ai-prod/operator:1.1.6"},
+		{name: "version from a newer major version", previousImage: "gcr.io/run-ai-prod/operator:2.3.0", flags: upgradeFlags{operatorVersion: "2.3.1"}, expectedImage: "gcr.io/run-ai-prod/operator:2.3.1"},
+		{name: "image from a newer major version", previousImage: "gcr.io/run-ai-prod/operator:2.3.0", flags: upgradeFlags{image: "registry:5000/operator:2.4.0"}, expectedImage: "registry:5000/operator:2.4.0"},
+		{name: "version from a registry with a port", previousImage: "registry:5000/operator:1.0.93", flags: upgradeFlags{operatorVersion: "1.0.96"}, expectedImage: "registry:5000/operator:1.0.96"},
+		{name: "version from a digest pinned image", previousImage: "gcr.io/run-ai-prod/operator:1.0.93@" + testDigest, flags: upgradeFlags{operatorVersion: "1.0.96"}, expectedImage: "gcr.io/run-ai-prod/operator:1.0.96"},
+		{name: "version from an image with only a digest", previousImage: "gcr.io/run-ai-prod/operator@" + testDigest, flags: upgradeFlags{operatorVersion: "1.0.96"}, expectError: true},
+		{name: "version from a release candidate", previousImage: "gcr.io/run-ai-prod/operator:1.0.92-rc1", flags: upgradeFlags{operatorVersion: "1.0.96"}, expectError: true},
+		{name: "version from a two part tag", previousImage: "gcr.io/run-ai-prod/operator:1.0", flags: upgradeFlags{operatorVersion: "1.0.96"}, expectError: true},
+		{name: "version from an image without a tag", previousImage: "gcr.io/run-ai-prod/operator", flags: upgradeFlags{operatorVersion: "1.0.96"}, expectError: true},
+		{name: "version from latest", previousImage: "gcr.io/run-ai-prod/operator:latest", flags: upgradeFlags{operatorVersion: "1.0.96"}, expectedImage: "gcr.io/run-ai-prod/operator:latest"},
+		{name: "image from an old version", previousImage: "gcr.io/run-ai-prod/operator:1.0.45", flags: upgradeFlags{image: "registry:5000/operator:1.0.96"}, expectedImage: "registry:5000/operator:1.0.96", expectedDeleteStateful: true},
+		{name: "image from a new version", previousImage: "gcr.io/run-ai-prod/operator:1.0.93", flags: upgradeFlags{image: "registry:5000/operator:1.0.96"}, expectedImage: "registry:5000/operator:1.0.96"},
+		{name: "image from a release candidate keeps the stateful resources", previousImage: "gcr.io/run-ai-prod/operator:1.0.92-rc1", flags: upgradeFlags{image: "registry:5000/operator:1.0.96"}, expectedImage: "registry:5000/operator:1.0.96"},
+		{name: "image from a digest keeps the stateful resources", previousImage: "gcr.io/run-ai-prod/operator@" + testDigest, flags: upgradeFlags{image: "registry:5000/operator:1.0.96"}, expectedImage: "registry:5000/operator:1.0.96"},
+		{name: "image from a two part tag keeps the stateful resources", previousImage: "gcr.io/run-ai-prod/operator:1.0", flags: upgradeFlags{image: "registry:5000/operator:1.0.96"}, expectedImage: "registry:5000/operator:1.0.96"},
+		{name: "image from latest", previousImage: "gcr.io/run-ai-prod/operator:latest", flags: upgradeFlags{image: "registry:5000/operator:1.0.96"}, expectedImage: "gcr.io/run-ai-prod/operator:latest"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image, deleteStateful, err := targetImage(test.previousImage, test.flags)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("Expected an error, got image: %v", image)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image != test.expectedImage {
+				t.Errorf("Expected image: %v, got: %v", test.expectedImage, image)
+			}
+			if deleteStateful != test.expectedDeleteStateful {
+				t.Errorf("Expected deletion of the stateful resources: %v, got: %v", test.expectedDeleteStateful, deleteStateful)
+			}
+		})
+	}
+}
+
+func TestHasStatefulResources(t *testing.T) {
+	tests := []struct {
+		tag         string
+		expected    bool
+		expectError bool
+	}{
+		{tag: "1.0.92", expected: true},
+		{tag: "1.0.45", expected: true},
+		{tag: "0.9.120", expected: true},
+		{tag: "1.0.93"},
+		{tag: "1.1.5"},
+		{tag: "2.3.0"},
+		{tag: "1.0.92-rc1", expectError: true},
+		{tag: "1.0", expectError: true},
+		{tag: "1.0.92.1", expectError: true},
+		{tag: "1.0.-1", expectError: true},
+		{tag: "v1.0.92", expectError: true},
+		{tag: "", expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.tag, func(t *testing.T) {
+			hasStateful, err := hasStatefulResources(test.tag)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("Expected an error, got: %v", hasStateful)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasStateful != test.expected {
+				t.Errorf("Expected stateful resources: %v, got: %v", test.expected, hasStateful)
+			}
+		})
+	}
+}
@@ -3,28 +3,214 @@ package version
 import (
 	"fmt"
 	"os"
-	"strings"
+	"path"
+	"sort"
+	"text/tabwriter"
 
+	"github.com/run-ai/runai-cli/cmd/common"
 	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/util"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+var (
+	// runaiConfigVersionFields are the fields of the RunaiConfig that may declare the Run:AI version, by priority
+	runaiConfigVersionFields = [][]string{{"spec", "version"}, {"status", "version"}}
+)
+
+// component is a container of a Deployment, DaemonSet or StatefulSet of Run:AI
+type component struct {
+	Namespace  string
+	Kind       string
+	Name       string
+	Ready      string
+	Container  string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
 func GetVersion() *cobra.Command {
+	short := false
 	var command = &cobra.Command{
 		Use:   "version",
 		Short: "Get cluster version",
-		Run: func(cmd *cobra.Command, args []string) {
-			client := client.GetClient()
-			deployment, err := client.GetClientset().AppsV1().Deployments("runai").Get("runai-operator", metav1.GetOptions{})
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.GetClient()
 			if err != nil {
-				fmt.Println("Run:AI is not running on the cluster")
-				os.Exit(1)
+				return err
+			}
+			deployment, err := client.GetClientset().AppsV1().Deployments(common.RunaiNamespace).Get(common.RunaiOperatorDeploymentName, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return errNotInstalled
 			}
-			currentImage := strings.Split(deployment.Spec.Template.Spec.Containers[0].Image, ":")
-			fmt.Printf("Run:AI version: %v\n", currentImage[1])
+			if err != nil {
+				return fmt.Errorf("Failed to get the Run:AI operator, error: %w", err)
+			}
+			if len(deployment.Spec.Template.Spec.Containers) == 0 {
+				return fmt.Errorf("The Run:AI operator has no containers")
+			}
+			operatorImage := deployment.Spec.Template.Spec.Containers[0].Image
+			operatorRepository, operatorTag, _ := util.ParseImage(operatorImage)
+			if short {
+				fmt.Printf("Run:AI version: %v\n", util.ValueOrDash(operatorTag))
+				return nil
+			}
+
+			components, err := listComponents(client)
+			if err != nil {
+				return err
+			}
+
+			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(writer, "Run:AI version:\t%s\n", util.ValueOrDash(operatorTag))
+			fmt.Fprintf(writer, "RunaiConfig version:\t%s\n", util.ValueOrDash(runaiConfigVersion(client)))
+			fmt.Fprintf(writer, "Kubernetes version:\t%s\n", util.ValueOrDash(kubernetesVersion(client)))
+			writer.Flush()
+			fmt.Println()
+
+			var mismatched []component
+			writer = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(writer, "NAMESPACE\tKIND\tNAME\tREADY\tCONTAINER\tIMAGE\tTAG\tDIGEST")
+			for _, component := range components {
+				fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", component.Namespace, component.Kind, component.Name, component.Ready,
+					component.Container, component.Repository, util.ValueOrDash(component.Tag), util.ValueOrDash(shortDigest(component.Digest)))
+				if isPartiallyUpgraded(component, operatorRepository, operatorTag) {
+					mismatched = append(mismatched, component)
+				}
+			}
+			writer.Flush()
+
+			if len(mismatched) > 0 {
+				fmt.Printf("\nThe image tags of these components differ from the Run:AI operator tag %s, the last upgrade may be partial:\n", operatorTag)
+				for _, component := range mismatched {
+					fmt.Printf("  - %s/%s %s, container %s: %s\n", component.Namespace, component.Name, component.Kind, component.Container, util.ValueOrDash(component.Tag))
+				}
+			}
+			return nil
 		},
 	}
 
+	command.Flags().BoolVar(&short, "short", false, "Print only the Run:AI version.")
 	return command
 }
+
+// listComponents returns the containers of the Deployments, DaemonSets and StatefulSets of the Run:AI namespaces
+func listComponents(client *client.Client) ([]component, error) {
+	var components []component
+	apps := client.GetClientset().AppsV1()
+	for _, namespace := range []string{common.RunaiNamespace, common.RunaiBackendNamespace} {
+		deployments, err := apps.Deployments(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list the deployments in namespace %s, error: %w", namespace, err)
+		}
+		for _, deployment := range deployments.Items {
+			desired := int32(1)
+			if deployment.Spec.Replicas != nil {
+				desired = *deployment.Spec.Replicas
+			}
+			components = append(components, podComponents(namespace, "Deployment", deployment.Name,
+				fmt.Sprintf("%d/%d", deployment.Status.ReadyReplicas, desired), deployment.Spec.Template.Spec)...)
+		}
+
+		daemonSets, err := apps.DaemonSets(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list the daemonsets in namespace %s, error: %w", namespace, err)
+		}
+		for _, daemonSet := range daemonSets.Items {
+			components = append(components, podComponents(namespace, "DaemonSet", daemonSet.Name,
+				fmt.Sprintf("%d/%d", daemonSet.Status.NumberReady, daemonSet.Status.DesiredNumberScheduled), daemonSet.Spec.Template.Spec)...)
+		}
+
+		statefulSets, err := apps.StatefulSets(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list the statefulsets in namespace %s, error: %w", namespace, err)
+		}
+		for _, statefulSet := range statefulSets.Items {
+			desired := int32(1)
+			if statefulSet.Spec.Replicas != nil {
+				desired = *statefulSet.Spec.Replicas
+			}
+			components = append(components, podComponents(namespace, "StatefulSet", statefulSet.Name,
+				fmt.Sprintf("%d/%d", statefulSet.Status.ReadyReplicas, desired), statefulSet.Spec.Template.Spec)...)
+		}
+	}
+
+	sort.SliceStable(components, func(i, j int) bool {
+		if components[i].Namespace != components[j].Namespace {
+			return components[i].Namespace < components[j].Namespace
+		}
+		if components[i].Kind != components[j].Kind {
+			return components[i].Kind < components[j].Kind
+		}
+		return components[i].Name < components[j].Name
+	})
+	return components, nil
+}
+
+func podComponents(namespace, kind, name, ready string, spec v1.PodSpec) []component {
+	var components []component
+	for _, container := range spec.Containers {
+		repository, tag, digest := util.ParseImage(container.Image)
+		components = append(components, component{
+			Namespace:  namespace,
+			Kind:       kind,
+			Name:       name,
+			Ready:      ready,
+			Container:  container.Name,
+			Repository: repository,
+			Tag:        tag,
+			Digest:     digest,
+		})
+	}
+	return components
+}
+
+// isPartiallyUpgraded returns whether the component is released with the operator, i.e. its image is in the registry
+// folder of the operator image, and its tag differs from the operator tag. Third party images have their own tags
+func isPartiallyUpgraded(component component, operatorRepository string, operatorTag string) bool {
+	if operatorTag == "" || component.Tag == "" {
+		return false
+	}
+	return path.Dir(component.Repository) == path.Dir(operatorRepository) && component.Tag != operatorTag
+}
+
+func runaiConfigVersion(client *client.Client) string {
+	runaiConfig, err := client.GetDynamicClient().Resource(common.RunaiConfigResource).Namespace(common.RunaiNamespace).Get(common.RunaiConfigName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return ""
+	}
+	if err != nil {
+		log.Debugf("Failed to get RunaiConfig, error: %v", err)
+		return ""
+	}
+	for _, fields := range runaiConfigVersionFields {
+		if version, found, _ := unstructured.NestedString(runaiConfig.Object, fields...); found && version != "" {
+			return version
+		}
+	}
+	return ""
+}
+
+func kubernetesVersion(client *client.Client) string {
+	version, err := client.GetClientset().Discovery().ServerVersion()
+	if err != nil {
+		log.Debugf("Failed to get the Kubernetes version, error: %v", err)
+		return ""
+	}
+	return version.GitVersion
+}
+
+// shortDigest shortens an image digest for display, e.g. sha256:3b2a57e3a8f1
+func shortDigest(digest string) string {
+	const shortDigestLength = len("sha256:") + 12
+	if len(digest) > shortDigestLength {
+		return digest[:shortDigestLength]
+	}
+	return digest
+}
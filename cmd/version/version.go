@@ -15,18 +15,63 @@
 package version
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/run-ai/runai-cli/cmd/common"
+	"github.com/run-ai/runai-cli/pkg/client"
+	"github.com/run-ai/runai-cli/pkg/util"
 	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
 	arenaVersion "github.com/run-ai/runai-cli/pkg/version"
 	"github.com/spf13/cobra"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
-	short bool
+	errNotInstalled = commandUtil.NotInstalled(errors.New("Run:AI is not installed on the cluster"))
+
+	short      bool
+	showClient bool
+	showServer bool
 )
 
 func printVersion(cmd *cobra.Command, args []string) error {
+	if showServer {
+		if showClient {
+			fmt.Println("Client:")
+			if err := printClientVersion(); err != nil {
+				return err
+			}
+			fmt.Println("\nServer:")
+		}
+		return printServerVersion()
+	}
+	return printClientVersion()
+}
+
+// printServerVersion prints the Run:AI version of the cluster, which is the tag of the Run:AI operator image
+func printServerVersion() error {
+	client, err := client.GetClient()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Context: %s\n", client.GetCurrentContext())
+	deployment, err := client.GetClientset().AppsV1().Deployments(common.RunaiNamespace).Get(common.RunaiOperatorDeploymentName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return errNotInstalled
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get the Run:AI operator, error: %w", err)
+	}
+	if len(deployment.Spec.Template.Spec.Containers) == 0 {
+		return fmt.Errorf("The Run:AI operator has no containers")
+	}
+	fmt.Printf("Run:AI Version: %s\n", util.ValueOrDash(util.ImageTag(deployment.Spec.Template.Spec.Containers[0].Image)))
+	return nil
+}
+
+func printClientVersion() error {
 	version, err := arenaVersion.GetVersion()
 
 	if err != nil {
@@ -54,8 +99,10 @@ func Command() *cobra.Command {
 	versionCmd := cobra.Command{
 		Use:   "version",
 		Short: fmt.Sprintf("Print version information"),
-		Run:   commandUtil.WrapRunCommand(printVersion),
+		RunE:  printVersion,
 	}
 	versionCmd.Flags().BoolVar(&short, "short", false, "print just the version number")
+	versionCmd.Flags().BoolVar(&showClient, "client", false, "print the version of the CLI, the default unless --server is given")
+	versionCmd.Flags().BoolVar(&showServer, "server", false, "print the Run:AI version of the cluster")
 	return &versionCmd
 }
@@ -23,16 +23,25 @@ import (
 	"strconv"
 
 	"github.com/run-ai/runai-cli/cmd/root"
+	"github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/lock"
 	log "github.com/sirupsen/logrus"
 )
 
 //go:generate go run generator/generator.go
 
 func main() {
+	os.Exit(run())
+}
+
+// run executes the command and returns its exit code. main exits only after run returns, so that the deferred
+// profiling and tracing are always stopped
+func run() int {
 	if isPProfEnabled() {
 		cpuf, err := os.Create("/tmp/cpu_profile")
 		if err != nil {
-			log.Fatal(err)
+			log.Error(err)
+			return command.ExitCodeError
 		}
 		defer cpuf.Close()
 
@@ -46,21 +55,26 @@ func main() {
 	if isTraceEnabled() {
 		tracef, err := os.Create("/tmp/trace.log")
 		if err != nil {
-			log.Fatal(err)
+			log.Error(err)
+			return command.ExitCodeError
 		}
 		defer tracef.Close()
 
 		err = trace.Start(tracef)
 		if err != nil {
-			log.Fatal(err)
+			log.Error(err)
+			return command.ExitCodeError
 		}
 		defer trace.Stop()
 	}
 
+	// the admin lock is taken by the mutating commands, and must be released whether they succeed or fail
+	defer lock.Release()
 	if err := root.NewCommand().Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, err)
+		return command.ExitCode(err)
 	}
+	return command.ExitCodeSuccess
 }
 
 func isPProfEnabled() (enable bool) {
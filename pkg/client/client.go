@@ -2,7 +2,6 @@ package client
 
 import (
 	"fmt"
-	"os"
 
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/dynamic"
@@ -12,6 +11,12 @@ import (
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 )
 
+const (
+	// the defaults of client-go, 5 QPS with bursts of 10, would throttle the concurrent updates of many nodes
+	clientQPS   = 50
+	clientBurst = 100
+)
+
 var (
 	client *Client
 )
@@ -21,48 +26,53 @@ type Client struct {
 	restConfig    *restclient.Config
 	dynamicClient dynamic.Interface
 	namespace     string
+	context       string
 }
 
-func GetClient() *Client {
+// GetClient returns the client of the current kubeconfig context, which is created on the first call
+func GetClient() (*Client, error) {
 	if client != nil {
-		return client
+		return client, nil
 	}
 
 	getter := genericclioptions.NewConfigFlags(true)
 	factory := cmdutil.NewFactory(getter)
 	namespace, _, err := factory.ToRawKubeConfigLoader().Namespace()
-
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Failed to read the kubeconfig, error: %w", err)
 	}
 
 	clientConfig := factory.ToRawKubeConfigLoader()
 	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the kubeconfig, error: %w", err)
+	}
+	restConfig.QPS = clientQPS
+	restConfig.Burst = clientBurst
 
+	rawConfig, err := clientConfig.RawConfig()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Failed to read the kubeconfig, error: %w", err)
 	}
 
 	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Failed to create the Kubernetes client, error: %w", err)
 	}
 
 	dynamicClient, err := dynamic.NewForConfig(restConfig)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Failed to create the Kubernetes client, error: %w", err)
 	}
 
-	return &Client{
+	client = &Client{
 		namespace:     namespace,
 		restConfig:    restConfig,
 		clientset:     clientset,
 		dynamicClient: dynamicClient,
+		context:       rawConfig.CurrentContext,
 	}
+	return client, nil
 }
 
 func (c *Client) GetDynamicClient() dynamic.Interface {
@@ -77,6 +87,11 @@ func (c *Client) GetRestConfig() *restclient.Config {
 	return c.restConfig
 }
 
+// GetCurrentContext returns the name of the kubeconfig context of the client, which is empty inside a cluster
+func (c *Client) GetCurrentContext() string {
+	return c.context
+}
+
 func (c *Client) GetDefaultNamespace() string {
 	return c.namespace
 }
@@ -0,0 +1,222 @@
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// DefaultDepartment is the department of the projects that are not assigned to a department
+	DefaultDepartment = "default"
+)
+
+// QuotaHierarchy is the deserved GPUs of the departments, of their projects and of the cluster
+type QuotaHierarchy struct {
+	Departments []unstructured.Unstructured
+	Projects    []unstructured.Unstructured
+	ClusterGpus float64
+}
+
+// GetQuotaHierarchy reads the departments, the projects and the GPUs of the cluster
+func GetQuotaHierarchy(client *client.Client) (*QuotaHierarchy, error) {
+	departments, err := client.GetDynamicClient().Resource(DepartmentResource).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list departments, error: %w", err)
+	}
+	projects, err := client.GetDynamicClient().Resource(ProjectResource).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list projects, error: %w", err)
+	}
+	clusterGpus, err := ClusterGpus(client)
+	if err != nil {
+		return nil, err
+	}
+	return &QuotaHierarchy{Departments: departments.Items, Projects: projects.Items, ClusterGpus: clusterGpus}, nil
+}
+
+// SetDepartment replaces the department with the same name, or adds it
+func (hierarchy *QuotaHierarchy) SetDepartment(department unstructured.Unstructured) {
+	hierarchy.Departments = setByName(hierarchy.Departments, department)
+}
+
+// SetProject replaces the project with the same name, or adds it
+func (hierarchy *QuotaHierarchy) SetProject(project unstructured.Unstructured) {
+	hierarchy.Projects = setByName(hierarchy.Projects, project)
+}
+
+// ProjectDepartment returns the department of the project, projects without a department belong to the default department
+func ProjectDepartment(project unstructured.Unstructured) string {
+	department, _, _ := unstructured.NestedString(project.Object, "spec", "department")
+	if department == "" {
+		return DefaultDepartment
+	}
+	return department
+}
+
+// DepartmentProjects returns the projects of each department
+func (hierarchy *QuotaHierarchy) DepartmentProjects() map[string][]unstructured.Unstructured {
+	projects := map[string][]unstructured.Unstructured{}
+	for _, project := range hierarchy.Projects {
+		department := ProjectDepartment(project)
+		projects[department] = append(projects[department], project)
+	}
+	return projects
+}
+
+// Validate checks that the projects of each department are not guaranteed more GPUs than the department quota,
+// that the departments are not guaranteed more GPUs than the cluster has, and that the departments of the projects
+// exist. The violations are returned with a breakdown of the quotas
+func (hierarchy *QuotaHierarchy) Validate() error {
+	return hierarchy.validate(nil, true)
+}
+
+// ValidateChange validates only the departments that a change of the hierarchy touched: the departments whose quota
+// changed and the departments that a project was added to or whose projects changed their quota. The total of the
+// departments is validated only when the quota of a department changed. Violations of the other departments, which
+// the change does not make worse, do not refuse it
+func (hierarchy *QuotaHierarchy) ValidateChange(previous *QuotaHierarchy) error {
+	touchedDepartments := map[string]bool{}
+	departmentChanged := false
+	previousDepartments := byName(previous.Departments)
+	for _, department := range hierarchy.Departments {
+		previousDepartment, found := previousDepartments[department.GetName()]
+		if !found || deservedGpus(previousDepartment) != deservedGpus(department) {
+			touchedDepartments[department.GetName()] = true
+			departmentChanged = true
+		}
+	}
+	previousProjects := byName(previous.Projects)
+	for _, project := range hierarchy.Projects {
+		previousProject, found := previousProjects[project.GetName()]
+		if !found || ProjectDepartment(previousProject) != ProjectDepartment(project) || deservedGpus(previousProject) != deservedGpus(project) {
+			touchedDepartments[ProjectDepartment(project)] = true
+		}
+	}
+	return hierarchy.validate(touchedDepartments, departmentChanged)
+}
+
+// validate validates the departments of the given names, or all of them when nil, and the total of the departments
+// when checkTotal is set
+func (hierarchy *QuotaHierarchy) validate(departmentNames map[string]bool, checkTotal bool) error {
+	var violations []string
+	departmentProjects := hierarchy.DepartmentProjects()
+	existingDepartments := byName(hierarchy.Departments)
+
+	totalDeserved := float64(0)
+	var departmentsBreakdown []string
+	for _, department := range sortedByName(hierarchy.Departments) {
+		deserved := deservedGpus(department)
+		totalDeserved += deserved
+		departmentsBreakdown = append(departmentsBreakdown, fmt.Sprintf("%s: %s", department.GetName(), FormatGpus(deserved)))
+		if departmentNames != nil && !departmentNames[department.GetName()] {
+			continue
+		}
+
+		projectsDeserved := float64(0)
+		var projectsBreakdown []string
+		for _, project := range sortedByName(departmentProjects[department.GetName()]) {
+			projectDeserved := deservedGpus(project)
+			projectsDeserved += projectDeserved
+			projectsBreakdown = append(projectsBreakdown, fmt.Sprintf("%s: %s", project.GetName(), FormatGpus(projectDeserved)))
+		}
+		if projectsDeserved > deserved {
+			violations = append(violations, fmt.Sprintf("the projects of department %s are guaranteed %s GPUs but the department quota is %s (%s)",
+				department.GetName(), FormatGpus(projectsDeserved), FormatGpus(deserved), strings.Join(projectsBreakdown, ", ")))
+		}
+	}
+
+	var missingDepartments []string
+	for name := range departmentProjects {
+		if _, found := existingDepartments[name]; !found && (departmentNames == nil || departmentNames[name]) {
+			missingDepartments = append(missingDepartments, name)
+		}
+	}
+	sort.Strings(missingDepartments)
+	for _, name := range missingDepartments {
+		var projectNames []string
+		for _, project := range sortedByName(departmentProjects[name]) {
+			projectNames = append(projectNames, project.GetName())
+		}
+		violations = append(violations, fmt.Sprintf("department %s does not exist, its quota cannot be checked (projects: %s)", name, strings.Join(projectNames, ", ")))
+	}
+
+	if checkTotal && totalDeserved > hierarchy.ClusterGpus {
+		violations = append(violations, fmt.Sprintf("the departments are guaranteed %s GPUs but the cluster has only %s (%s)",
+			FormatGpus(totalDeserved), FormatGpus(hierarchy.ClusterGpus), strings.Join(departmentsBreakdown, ", ")))
+	}
+
+	if len(violations) > 0 {
+		return commandUtil.Validationf("The GPU quotas exceed their limits:\n  - %s", strings.Join(violations, "\n  - "))
+	}
+	return nil
+}
+
+// CheckQuotaHierarchy validates the departments that the change touches as they would be after it, and refuses the
+// change unless forced
+func CheckQuotaHierarchy(client *client.Client, change func(hierarchy *QuotaHierarchy), force bool) error {
+	hierarchy, err := GetQuotaHierarchy(client)
+	if err != nil {
+		return err
+	}
+	previous := hierarchy.deepCopy()
+	change(hierarchy)
+	if err := hierarchy.ValidateChange(previous); err != nil {
+		if force {
+			log.Warnf("Ignoring the GPU quota limits (--force was given): %v", err)
+			return nil
+		}
+		return fmt.Errorf("%w\nUse --force to apply the change anyway", err)
+	}
+	return nil
+}
+
+// deepCopy copies the hierarchy, so that a change that modifies its objects in place does not change the copy
+func (hierarchy *QuotaHierarchy) deepCopy() *QuotaHierarchy {
+	copied := &QuotaHierarchy{ClusterGpus: hierarchy.ClusterGpus}
+	for _, department := range hierarchy.Departments {
+		copied.Departments = append(copied.Departments, *department.DeepCopy())
+	}
+	for _, project := range hierarchy.Projects {
+		copied.Projects = append(copied.Projects, *project.DeepCopy())
+	}
+	return copied
+}
+
+func deservedGpus(object unstructured.Unstructured) float64 {
+	return NestedFloat(object.Object, "spec", "deservedGpus")
+}
+
+func byName(objects []unstructured.Unstructured) map[string]unstructured.Unstructured {
+	result := map[string]unstructured.Unstructured{}
+	for _, object := range objects {
+		result[object.GetName()] = object
+	}
+	return result
+}
+
+func setByName(objects []unstructured.Unstructured, object unstructured.Unstructured) []unstructured.Unstructured {
+	return append(removeByName(objects, object.GetName()), object)
+}
+
+func removeByName(objects []unstructured.Unstructured, name string) []unstructured.Unstructured {
+	var result []unstructured.Unstructured
+	for _, object := range objects {
+		if object.GetName() != name {
+			result = append(result, object)
+		}
+	}
+	return result
+}
+
+func sortedByName(objects []unstructured.Unstructured) []unstructured.Unstructured {
+	sorted := append([]unstructured.Unstructured{}, objects...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetName() < sorted[j].GetName() })
+	return sorted
+}
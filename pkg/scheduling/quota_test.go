@@ -0,0 +1,150 @@
+package scheduling
+
+import (
+	"testing"
+
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func testDepartment(name string, deservedGpus int64) unstructured.Unstructured {
+	return unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name},
+		"spec":     map[string]interface{}{"deservedGpus": deservedGpus},
+	}}
+}
+
+func testProject(name, department string, deservedGpus float64) unstructured.Unstructured {
+	spec := map[string]interface{}{"deservedGpus": deservedGpus}
+	if department != "" {
+		spec["department"] = department
+	}
+	return unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name},
+		"spec":     spec,
+	}}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		hierarchy   QuotaHierarchy
+		expectError bool
+	}{
+		{
+			name: "within the limits",
+			hierarchy: QuotaHierarchy{
+				Departments: []unstructured.Unstructured{testDepartment("default", 4), testDepartment("research", 4)},
+				Projects:    []unstructured.Unstructured{testProject("team-a", "", 2), testProject("team-b", "research", 4)},
+				ClusterGpus: 8,
+			},
+		},
+		{
+			name: "projects over the department quota",
+			hierarchy: QuotaHierarchy{
+				Departments: []unstructured.Unstructured{testDepartment("research", 4)},
+				Projects:    []unstructured.Unstructured{testProject("team-a", "research", 2.5), testProject("team-b", "research", 2)},
+				ClusterGpus: 8,
+			},
+			expectError: true,
+		},
+		{
+			name: "departments over the cluster",
+			hierarchy: QuotaHierarchy{
+				Departments: []unstructured.Unstructured{testDepartment("default", 4), testDepartment("research", 5)},
+				ClusterGpus: 8,
+			},
+			expectError: true,
+		},
+		{
+			name: "missing department",
+			hierarchy: QuotaHierarchy{
+				Departments: []unstructured.Unstructured{testDepartment("research", 4)},
+				Projects:    []unstructured.Unstructured{testProject("team-a", "vision", 1)},
+				ClusterGpus: 8,
+			},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.hierarchy.Validate()
+			if !test.expectError {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if exitCode := commandUtil.ExitCode(err); exitCode != commandUtil.ExitCodeValidation {
+				t.Errorf("Expected a validation error, got exit code: %d", exitCode)
+			}
+		})
+	}
+}
+
+func TestValidateChange(t *testing.T) {
+	// the vision department is already over its quota, and team-x is assigned to a department that does not exist
+	previous := QuotaHierarchy{
+		Departments: []unstructured.Unstructured{testDepartment("research", 4), testDepartment("vision", 2)},
+		Projects: []unstructured.Unstructured{
+			testProject("team-a", "research", 2),
+			testProject("team-b", "vision", 3),
+			testProject("team-x", "missing", 1),
+		},
+		ClusterGpus: 8,
+	}
+
+	tests := []struct {
+		name        string
+		change      func(hierarchy *QuotaHierarchy)
+		expectError bool
+	}{
+		{
+			name:   "project of another department within its quota",
+			change: func(hierarchy *QuotaHierarchy) { hierarchy.SetProject(testProject("team-a", "research", 4)) },
+		},
+		{
+			name:        "project over the quota of its department",
+			change:      func(hierarchy *QuotaHierarchy) { hierarchy.SetProject(testProject("team-a", "research", 5)) },
+			expectError: true,
+		},
+		{
+			name:        "project moved to a department over its quota",
+			change:      func(hierarchy *QuotaHierarchy) { hierarchy.SetProject(testProject("team-a", "vision", 2)) },
+			expectError: true,
+		},
+		{
+			name:   "project moved out of a department over its quota",
+			change: func(hierarchy *QuotaHierarchy) { hierarchy.SetProject(testProject("team-b", "research", 2)) },
+		},
+		{
+			name:        "project created in a missing department",
+			change:      func(hierarchy *QuotaHierarchy) { hierarchy.SetProject(testProject("team-c", "nlp", 1)) },
+			expectError: true,
+		},
+		{
+			name:        "department over the cluster",
+			change:      func(hierarchy *QuotaHierarchy) { hierarchy.SetDepartment(testDepartment("research", 7)) },
+			expectError: true,
+		},
+		{
+			name:   "department created within the cluster",
+			change: func(hierarchy *QuotaHierarchy) { hierarchy.SetDepartment(testDepartment("nlp", 2)) },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hierarchy := previous.deepCopy()
+			test.change(hierarchy)
+			err := hierarchy.ValidateChange(&previous)
+			if test.expectError && err == nil {
+				t.Error("Expected an error")
+			} else if !test.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package scheduling
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/run-ai/runai-cli/pkg/client"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	GpuResourceName v1.ResourceName = "nvidia.com/gpu"
+
+	// gpuFractionAnnotation is the fraction of a GPU requested by a pod that shares a GPU
+	gpuFractionAnnotation = "gpu-fraction"
+	// the annotations that assign a pod to its PodGroup, of Run:AI and of kube-batch
+	podGroupNameAnnotation       = "pod-group-name"
+	kubeBatchGroupNameAnnotation = "scheduling.k8s.io/group-name"
+)
+
+var (
+	ProjectResource    = schema.GroupVersionResource{Group: "run.ai", Version: "v1", Resource: "projects"}
+	DepartmentResource = schema.GroupVersionResource{Group: "scheduling.incubator.k8s.io", Version: "v1alpha1", Resource: "departments"}
+	QueueResource      = schema.GroupVersionResource{Group: "scheduling.incubator.k8s.io", Version: "v1alpha1", Resource: "queues"}
+	PodGroupResource   = schema.GroupVersionResource{Group: "scheduling.incubator.k8s.io", Version: "v1alpha1", Resource: "podgroups"}
+)
+
+// NestedFloat returns a number field of an unstructured object, which is decoded either as int64 or as float64
+func NestedFloat(obj map[string]interface{}, fields ...string) float64 {
+	value, found, err := unstructured.NestedFieldNoCopy(obj, fields...)
+	if !found || err != nil {
+		return 0
+	}
+	switch number := value.(type) {
+	case int64:
+		return float64(number)
+	case float64:
+		return number
+	}
+	return 0
+}
+
+// PodGpus returns the GPUs requested by a pod, either whole GPUs or a fraction of a shared GPU
+func PodGpus(pod v1.Pod) float64 {
+	gpus := float64(0)
+	for _, container := range pod.Spec.Containers {
+		quantity, found := container.Resources.Limits[GpuResourceName]
+		if !found {
+			quantity = container.Resources.Requests[GpuResourceName]
+		}
+		gpus += float64(quantity.Value())
+	}
+	if fraction, found := pod.Annotations[gpuFractionAnnotation]; found {
+		parsedFraction, err := strconv.ParseFloat(fraction, 64)
+		if err != nil {
+			log.Debugf("Ignoring invalid %s annotation of pod: %v/%v", gpuFractionAnnotation, pod.Namespace, pod.Name)
+		} else {
+			gpus += parsedFraction
+		}
+	}
+	return gpus
+}
+
+// PodGroupKey is the key of a PodGroup in the maps returned by this package
+func PodGroupKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// AllocatedGpusByPodGroup returns the GPUs allocated to the pods of each PodGroup that are bound to a node
+// and did not terminate, by PodGroupKey
+func AllocatedGpusByPodGroup(client *client.Client) (map[string]float64, error) {
+	pods, err := client.GetClientset().CoreV1().Pods("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list pods, error: %w", err)
+	}
+
+	allocated := map[string]float64{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		podGroupName := PodGroupName(pod)
+		if podGroupName == "" {
+			continue
+		}
+		allocated[PodGroupKey(pod.Namespace, podGroupName)] += PodGpus(pod)
+	}
+	return allocated, nil
+}
+
+// PodGroupName returns the name of the PodGroup of a pod, or an empty string if the pod has no PodGroup
+func PodGroupName(pod v1.Pod) string {
+	if podGroupName := pod.Annotations[podGroupNameAnnotation]; podGroupName != "" {
+		return podGroupName
+	}
+	return pod.Annotations[kubeBatchGroupNameAnnotation]
+}
+
+// ListPodGroups returns the PodGroups of all namespaces
+func ListPodGroups(client *client.Client) ([]unstructured.Unstructured, error) {
+	podGroups, err := client.GetDynamicClient().Resource(PodGroupResource).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list PodGroups, error: %w", err)
+	}
+	return podGroups.Items, nil
+}
+
+// PodGroupQueue returns the queue of a PodGroup, which is named after the Run:AI project
+func PodGroupQueue(podGroup unstructured.Unstructured) string {
+	queue, _, _ := unstructured.NestedString(podGroup.Object, "spec", "queue")
+	return queue
+}
+
+// AllocatedGpusByQueue returns the GPUs allocated to the PodGroups of each queue
+func AllocatedGpusByQueue(client *client.Client) (map[string]float64, error) {
+	podGroups, err := ListPodGroups(client)
+	if err != nil {
+		return nil, err
+	}
+	allocatedByPodGroup, err := AllocatedGpusByPodGroup(client)
+	if err != nil {
+		return nil, err
+	}
+
+	allocated := map[string]float64{}
+	for _, podGroup := range podGroups {
+		allocated[PodGroupQueue(podGroup)] += allocatedByPodGroup[PodGroupKey(podGroup.GetNamespace(), podGroup.GetName())]
+	}
+	return allocated, nil
+}
+
+// ClusterGpus returns the allocatable GPUs of all the nodes in the cluster
+func ClusterGpus(client *client.Client) (float64, error) {
+	nodes, err := client.GetClientset().CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to list nodes in cluster, error: %w", err)
+	}
+	gpus := float64(0)
+	for _, node := range nodes.Items {
+		quantity := node.Status.Allocatable[GpuResourceName]
+		gpus += float64(quantity.Value())
+	}
+	return gpus, nil
+}
+
+// FormatGpus prints GPUs without trailing zeros, e.g. 2 or 0.5
+func FormatGpus(gpus float64) string {
+	return strconv.FormatFloat(gpus, 'f', -1, 64)
+}
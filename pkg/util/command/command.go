@@ -1,22 +1,153 @@
+// Package command classifies the errors of the commands into exit codes, so automation can tell the failures apart.
+//
+// Exit codes:
+//
+//	0  success
+//	1  any error that is not classified below
+//	2  validation: invalid arguments, flags or input files
+//	3  not installed: Run:AI is not installed on the cluster
+//	4  permission denied: the API server or the file system denied the operation
+//	5  conflict: the object was modified concurrently, or already exists
+//	6  timeout: an operation did not complete in time
+//	7  unreachable: the API server or a download server cannot be reached
 package command
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 
 	"github.com/spf13/cobra"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
-type CommandWrapper struct {
-	runFunc (func(cmd *cobra.Command, args []string) error)
+const (
+	ExitCodeSuccess          = 0
+	ExitCodeError            = 1
+	ExitCodeValidation       = 2
+	ExitCodeNotInstalled     = 3
+	ExitCodePermissionDenied = 4
+	ExitCodeConflict         = 5
+	ExitCodeTimeout          = 6
+	ExitCodeUnreachable      = 7
+)
+
+// ExitCodesHelp documents the exit codes in the help of the CLI
+const ExitCodesHelp = `Exit codes:
+  0  success
+  1  error
+  2  invalid arguments, flags or input files
+  3  Run:AI is not installed on the cluster
+  4  permission denied
+  5  conflict, the object was modified concurrently or already exists
+  6  timeout
+  7  the API server cannot be reached`
+
+// Error is an error with the exit code of its kind
+type Error struct {
+	ExitCode int
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NotInstalled(err error) error {
+	return &Error{ExitCode: ExitCodeNotInstalled, Err: err}
 }
 
-func WrapRunCommand(runFunc func(cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) {
-	return func(cmd *cobra.Command, args []string) {
-		err := runFunc(cmd, args)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+func PermissionDenied(err error) error {
+	return &Error{ExitCode: ExitCodePermissionDenied, Err: err}
+}
+
+func Conflict(err error) error {
+	return &Error{ExitCode: ExitCodeConflict, Err: err}
+}
+
+func Timeout(err error) error {
+	return &Error{ExitCode: ExitCodeTimeout, Err: err}
+}
+
+func Validation(err error) error {
+	return &Error{ExitCode: ExitCodeValidation, Err: err}
+}
+
+// Validationf returns a validation error, e.g. of an invalid flag
+func Validationf(format string, args ...interface{}) error {
+	return Validation(fmt.Errorf(format, args...))
+}
+
+// ExitCode returns the exit code of an error. An error that is not a command Error is classified by the errors it
+// wraps, i.e. the errors of the API server, of the network and of the file system
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitCodeSuccess
+	}
+
+	var commandError *Error
+	if errors.As(err, &commandError) {
+		return commandError.ExitCode
+	}
+
+	var apiStatus k8serrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		switch apiStatus.Status().Reason {
+		case metav1.StatusReasonUnauthorized, metav1.StatusReasonForbidden:
+			return ExitCodePermissionDenied
+		case metav1.StatusReasonConflict, metav1.StatusReasonAlreadyExists:
+			return ExitCodeConflict
+		case metav1.StatusReasonTimeout, metav1.StatusReasonServerTimeout:
+			return ExitCodeTimeout
+		case metav1.StatusReasonInvalid, metav1.StatusReasonBadRequest:
+			return ExitCodeValidation
+		}
+		return ExitCodeError
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, wait.ErrWaitTimeout) {
+		return ExitCodeTimeout
+	}
+	var netError net.Error
+	if errors.As(err, &netError) {
+		if netError.Timeout() {
+			return ExitCodeTimeout
 		}
+		return ExitCodeUnreachable
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return ExitCodePermissionDenied
+	}
+	return ExitCodeError
+}
+
+// ClassifyUsageErrors classifies the errors of invalid flags and arguments of a command and of its subcommands as
+// validation errors
+func ClassifyUsageErrors(cmd *cobra.Command) {
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return Validation(err)
+	})
+	classifyArgsErrors(cmd)
+}
+
+func classifyArgsErrors(cmd *cobra.Command) {
+	if validateArgs := cmd.Args; validateArgs != nil {
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			if err := validateArgs(cmd, args); err != nil {
+				return Validation(err)
+			}
+			return nil
+		}
+	}
+	for _, subcommand := range cmd.Commands() {
+		classifyArgsErrors(subcommand)
 	}
 }
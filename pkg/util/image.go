@@ -0,0 +1,24 @@
+package util
+
+import "strings"
+
+// ParseImage splits a container image into its repository, its tag and its digest. The tag and the digest are
+// empty when the image has none, e.g. registry:5000/operator, gcr.io/run-ai-prod/operator:1.0.45 or
+// gcr.io/run-ai-prod/operator@sha256:...
+func ParseImage(image string) (string, string, string) {
+	repository, digest := image, ""
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, digest = repository[:i], repository[i+1:]
+	}
+	tag := ""
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+	return repository, tag, digest
+}
+
+// ImageTag returns the tag of a container image, or an empty string if the image has no tag
+func ImageTag(image string) string {
+	_, tag, _ := ParseImage(image)
+	return tag
+}
@@ -0,0 +1,33 @@
+package util
+
+import "testing"
+
+func TestParseImage(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		image              string
+		expectedRepository string
+		expectedTag        string
+		expectedDigest     string
+	}{
+		{image: "gcr.io/run-ai-prod/operator:1.0.45", expectedRepository: "gcr.io/run-ai-prod/operator", expectedTag: "1.0.45"},
+		{image: "gcr.io/run-ai-prod/operator", expectedRepository: "gcr.io/run-ai-prod/operator"},
+		{image: "operator:latest", expectedRepository: "operator", expectedTag: "latest"},
+		{image: "registry:5000/operator", expectedRepository: "registry:5000/operator"},
+		{image: "registry:5000/operator:1.0.45", expectedRepository: "registry:5000/operator", expectedTag: "1.0.45"},
+		{image: "gcr.io/run-ai-prod/operator@" + digest, expectedRepository: "gcr.io/run-ai-prod/operator", expectedDigest: digest},
+		{image: "registry:5000/operator:1.0.45@" + digest, expectedRepository: "registry:5000/operator", expectedTag: "1.0.45", expectedDigest: digest},
+		{image: "gcr.io/run-ai-prod/operator:1.0.92-rc1", expectedRepository: "gcr.io/run-ai-prod/operator", expectedTag: "1.0.92-rc1"},
+	}
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			repository, tag, digest := ParseImage(test.image)
+			if repository != test.expectedRepository || tag != test.expectedTag || digest != test.expectedDigest {
+				t.Errorf("Expected: (%q, %q, %q), got: (%q, %q, %q)", test.expectedRepository, test.expectedTag, test.expectedDigest, repository, tag, digest)
+			}
+			if imageTag := ImageTag(test.image); imageTag != test.expectedTag {
+				t.Errorf("Expected tag: %q, got: %q", test.expectedTag, imageTag)
+			}
+		})
+	}
+}
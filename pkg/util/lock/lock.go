@@ -0,0 +1,246 @@
+// Package lock is the admin lock of a cluster, a coordination.k8s.io Lease that a mutating command holds while it runs,
+// so that two admins do not scale the operators or change the RunaiConfig at the same time.
+package lock
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/run-ai/runai-cli/pkg/client"
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	"github.com/run-ai/runai-cli/pkg/util/retry"
+	log "github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationclientv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+)
+
+const (
+	LeaseName = "runai-adm-lock"
+
+	// CommandAnnotation is the command line of the holder of the lock
+	CommandAnnotation = "runai/lock-command"
+
+	// the lease expires when its holder stops renewing it, e.g. when the command was killed
+	leaseDuration = 60 * time.Second
+	renewInterval = 20 * time.Second
+)
+
+var (
+	held *heldLock
+
+	// errStolen stops the renewal of a lock that another command took, it is not retried
+	errStolen = fmt.Errorf("the admin lock was taken by another command")
+
+	// errNoNamespace is returned by acquire when the namespace of the lock does not exist, it is not retried
+	errNoNamespace = fmt.Errorf("the namespace of the admin lock does not exist")
+)
+
+// heldLock is the admin lock that this process holds, which is renewed until it is released
+type heldLock struct {
+	leases   coordinationclientv1.LeaseInterface
+	identity string
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// Acquire takes the admin lock in the namespace for the command, and renews it until Release is called. It fails
+// fast when another command holds the lock, unless steal is set. An expired lock, whose holder stopped renewing it,
+// is taken over, and a command whose lock is stolen is stopped. When the namespace does not exist, e.g. before Run:AI
+// is installed, the command runs without a lock
+func Acquire(client *client.Client, namespace, command string, steal bool) error {
+	leases := client.GetClientset().CoordinationV1().Leases(namespace)
+	identity := holderIdentity()
+	err := acquire(leases, namespace, identity, command, steal)
+	if err == errNoNamespace {
+		log.Warnf("The %s namespace does not exist, running without the admin lock. Another admin command may run on the cluster at the same time", namespace)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Debugf("Acquired the admin lock as %s", identity)
+
+	held = &heldLock{leases: leases, identity: identity, stop: make(chan struct{})}
+	held.stopped.Add(1)
+	go held.renew()
+	return nil
+}
+
+func acquire(leases coordinationclientv1.LeaseInterface, namespace, identity, command string, steal bool) error {
+	err := retry.Mutation("acquire the admin lock", func() error {
+		lease, err := leases.Get(LeaseName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: LeaseName, Namespace: namespace}}
+			setHolder(lease, identity, command)
+			_, err = leases.Create(lease)
+			if errors.IsAlreadyExists(err) {
+				// taken concurrently, the next attempt reads its holder
+				return errors.NewConflict(coordinationv1.Resource("leases"), LeaseName, err)
+			}
+			if errors.IsNotFound(err) {
+				return errNoNamespace
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if currentHolder := stringValue(lease.Spec.HolderIdentity); currentHolder != "" && currentHolder != identity {
+			if !isExpired(lease) && !steal {
+				return commandUtil.Conflict(fmt.Errorf("Another command is running on the cluster, %s\nUse --steal-lock to take the lock if that command is no longer running", describe(lease)))
+			}
+			if isExpired(lease) {
+				log.Warnf("Taking over the expired admin lock, %s", describe(lease))
+			} else {
+				log.Warnf("Stealing the admin lock (--steal-lock was given), %s", describe(lease))
+			}
+			transitions := int32(0)
+			if lease.Spec.LeaseTransitions != nil {
+				transitions = *lease.Spec.LeaseTransitions
+			}
+			transitions++
+			lease.Spec.LeaseTransitions = &transitions
+		}
+		setHolder(lease, identity, command)
+		_, err = leases.Update(lease)
+		return err
+	})
+	if err != nil {
+		if _, isHeld := err.(*commandUtil.Error); isHeld || err == errNoNamespace {
+			return err
+		}
+		return fmt.Errorf("Failed to acquire the admin lock, error: %w", err)
+	}
+	return nil
+}
+
+// Release releases the admin lock if it is held by this process
+func Release() {
+	if held == nil {
+		return
+	}
+	close(held.stop)
+	held.stopped.Wait()
+
+	lease, err := held.leases.Get(LeaseName, metav1.GetOptions{})
+	if err == nil && stringValue(lease.Spec.HolderIdentity) == held.identity {
+		resourceVersion := lease.ResourceVersion
+		err = held.leases.Delete(LeaseName, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &resourceVersion}})
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		log.Warnf("Failed to release the admin lock, it expires in %v, error: %v", leaseDuration, err)
+	}
+	held = nil
+}
+
+func (lock *heldLock) renew() {
+	defer lock.stopped.Done()
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+		}
+		var holder *coordinationv1.Lease
+		err := retry.Mutation("renew the admin lock", func() error {
+			lease, err := lock.leases.Get(LeaseName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if stringValue(lease.Spec.HolderIdentity) != lock.identity {
+				holder = lease
+				return errStolen
+			}
+			now := metav1.NewMicroTime(time.Now())
+			lease.Spec.RenewTime = &now
+			_, err = lock.leases.Update(lease)
+			return err
+		})
+		if err == errStolen {
+			log.Errorf("The admin lock was taken by another command, stopping this command. %s", describe(holder))
+			stop()
+			return
+		}
+		if err != nil {
+			log.Warnf("Failed to renew the admin lock, error: %v", err)
+		}
+	}
+}
+
+// stop stops this process after its lock was stolen. It is terminated as if by the user, so that a command that is
+// in the middle of a multi-step flow rolls back its completed steps. When it cannot be signaled, e.g. on Windows, it
+// exits at once
+func stop() {
+	process, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = process.Signal(syscall.SIGTERM)
+	}
+	if err != nil {
+		log.Debugf("Failed to terminate the command, error: %v", err)
+		os.Exit(commandUtil.ExitCodeConflict)
+	}
+}
+
+func setHolder(lease *coordinationv1.Lease, identity, command string) {
+	now := metav1.NewMicroTime(time.Now())
+	durationSeconds := int32(leaseDuration.Seconds())
+	lease.Spec.HolderIdentity = &identity
+	lease.Spec.LeaseDurationSeconds = &durationSeconds
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Annotations[CommandAnnotation] = command
+}
+
+func isExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return time.Since(lease.Spec.RenewTime.Time) > time.Duration(*lease.Spec.LeaseDurationSeconds)*time.Second
+}
+
+// describe describes the holder of the lock, e.g. "the admin lock is held by: alice@laptop (pid 4242), command: runai-adm
+// upgrade, started: ..."
+func describe(lease *coordinationv1.Lease) string {
+	started := "-"
+	if lease.Spec.AcquireTime != nil {
+		started = fmt.Sprintf("%s (%v ago)", lease.Spec.AcquireTime.Format(time.RFC3339), time.Since(lease.Spec.AcquireTime.Time).Round(time.Second))
+	}
+	renewed := "-"
+	if lease.Spec.RenewTime != nil {
+		renewed = fmt.Sprintf("%v ago", time.Since(lease.Spec.RenewTime.Time).Round(time.Second))
+	}
+	return fmt.Sprintf("the admin lock is held by: %s, command: %s, started: %s, last renewed: %s",
+		stringValue(lease.Spec.HolderIdentity), lease.Annotations[CommandAnnotation], started, renewed)
+}
+
+// holderIdentity identifies this process, e.g. alice@laptop (pid 4242)
+func holderIdentity() string {
+	username := "unknown"
+	if currentUser, err := user.Current(); err == nil {
+		username = currentUser.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s@%s (pid %d)", username, hostname, os.Getpid())
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,84 @@
+package lock
+
+import (
+	"testing"
+	"time"
+
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const (
+	testNamespace = "runai"
+	testIdentity  = "alice@laptop (pid 4242)"
+)
+
+func testLease(holder string, renewed time.Time) *coordinationv1.Lease {
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: LeaseName, Namespace: testNamespace}}
+	setHolder(lease, holder, "runai-adm upgrade")
+	renewTime := metav1.NewMicroTime(renewed)
+	lease.Spec.RenewTime = &renewTime
+	return lease
+}
+
+func TestAcquireWithoutNamespace(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	// the fake clientset does not check that the namespace of a created object exists
+	clientset.PrependReactor("create", "leases", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewNotFound(corev1.Resource("namespaces"), testNamespace)
+	})
+
+	err := acquire(clientset.CoordinationV1().Leases(testNamespace), testNamespace, testIdentity, "runai-adm upgrade", false)
+	if err != errNoNamespace {
+		t.Errorf("Expected the missing namespace error, got: %v", err)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	tests := []struct {
+		name           string
+		existing       *coordinationv1.Lease
+		steal          bool
+		expectHolder   string
+		expectConflict bool
+	}{
+		{name: "free lock", expectHolder: testIdentity},
+		{name: "lock held by this process", existing: testLease(testIdentity, time.Now()), expectHolder: testIdentity},
+		{name: "lock held by another command", existing: testLease("bob@desktop (pid 7)", time.Now()), expectConflict: true},
+		{name: "stolen lock", existing: testLease("bob@desktop (pid 7)", time.Now()), steal: true, expectHolder: testIdentity},
+		{name: "expired lock", existing: testLease("bob@desktop (pid 7)", time.Now().Add(-2*leaseDuration)), expectHolder: testIdentity},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			if test.existing != nil {
+				clientset = fake.NewSimpleClientset(test.existing)
+			}
+			leases := clientset.CoordinationV1().Leases(testNamespace)
+
+			err := acquire(leases, testNamespace, testIdentity, "runai-adm upgrade", test.steal)
+			if test.expectConflict {
+				if exitCode := commandUtil.ExitCode(err); exitCode != commandUtil.ExitCodeConflict {
+					t.Errorf("Expected a conflict error, got exit code: %d, error: %v", exitCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			lease, err := leases.Get(LeaseName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if holder := stringValue(lease.Spec.HolderIdentity); holder != test.expectHolder {
+				t.Errorf("Expected the lock to be held by: %s, got: %s", test.expectHolder, holder)
+			}
+		})
+	}
+}
@@ -17,11 +17,12 @@ package util
 import (
 	"strings"
 
+	"github.com/run-ai/runai-cli/pkg/util/command"
 	log "github.com/sirupsen/logrus"
 )
 
 // SetLogLevel sets the logrus logging level
-func SetLogLevel(level string) {
+func SetLogLevel(level string) error {
 	switch strings.ToLower(level) {
 	case "debug":
 		log.SetLevel(log.DebugLevel)
@@ -32,6 +33,7 @@ func SetLogLevel(level string) {
 	case "error":
 		log.SetLevel(log.ErrorLevel)
 	default:
-		log.Fatalf("Unknown level: %s", level)
+		return command.Validationf("Unknown level: %s", level)
 	}
+	return nil
 }
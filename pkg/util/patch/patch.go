@@ -0,0 +1,196 @@
+// Package patch changes only the fields of API objects that runai-adm owns, e.g. a role label of a node or the
+// replicas of an operator, so that the changes of kubelet and of other controllers to the same objects are kept.
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	commandUtil "github.com/run-ai/runai-cli/pkg/util/command"
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// FieldManager is the field manager of the changes of runai-adm
+const FieldManager = "runai-adm"
+
+// ServerSideApply applies the owned fields with a server-side apply instead of a strategic merge patch, see Update
+var ServerSideApply = false
+
+// Update changes the fields of an object at the given paths from their values in original to their values in
+// modified, e.g. {"metadata", "labels", "runai/cluster-wide"} or {"spec", "replicas"}, and sets modified to the
+// object that the API server returned.
+//
+// By default the object is patched with a strategic merge patch of the fields that differ, which does not conflict
+// with concurrent changes to the other fields. With ServerSideApply, the fields that are set in modified are applied
+// with the runai-adm field manager: the API server fails with a conflict instead of changing a field that another
+// field manager owns, and a field is removed only when runai-adm was the one that applied it. A field that was not
+// removed for that reason fails the update with a conflict
+func Update(resources dynamic.ResourceInterface, original, modified runtime.Object, paths ...[]string) error {
+	return update(resources, original, modified, false, paths)
+}
+
+// UpdateIfUnchanged changes the fields as Update does, but fails with a conflict when the object was changed since
+// original was read. It is used for the fields that a patch replaces as a whole, e.g. the taints of a node, which
+// have no merge key, so that a concurrent change to them is retried rather than overwritten
+func UpdateIfUnchanged(resources dynamic.ResourceInterface, original, modified runtime.Object, paths ...[]string) error {
+	return update(resources, original, modified, true, paths)
+}
+
+func update(resources dynamic.ResourceInterface, original, modified runtime.Object, precondition bool, paths [][]string) error {
+	accessor, err := meta.Accessor(modified)
+	if err != nil {
+		return err
+	}
+	originalAccessor, err := meta.Accessor(original)
+	if err != nil {
+		return err
+	}
+	resourceVersion := ""
+	if precondition {
+		resourceVersion = originalAccessor.GetResourceVersion()
+	}
+	originalObject, err := runtime.DefaultUnstructuredConverter.ToUnstructured(original)
+	if err != nil {
+		return err
+	}
+	modifiedObject, err := runtime.DefaultUnstructuredConverter.ToUnstructured(modified)
+	if err != nil {
+		return err
+	}
+
+	var result *unstructured.Unstructured
+	if ServerSideApply {
+		result, err = apply(resources, accessor, modified, resourceVersion, ownedFields(modifiedObject, paths, false))
+		if err == nil {
+			if fields := unappliedFields(modifiedObject, result.Object, paths); len(fields) > 0 {
+				return commandUtil.Conflict(fmt.Errorf("Failed to apply %s, the fields: %s are managed by another field manager and were not changed", accessor.GetName(), strings.Join(fields, ", ")))
+			}
+		}
+	} else {
+		result, err = strategicMergePatch(resources, accessor.GetName(), original, resourceVersion, ownedFields(originalObject, paths, true), ownedFields(modifiedObject, paths, true))
+	}
+	if err != nil || result == nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(result.Object, modified)
+}
+
+// strategicMergePatch patches the difference between the fields. A resourceVersion, when not empty, is added to the
+// patch, so that the API server fails it with a conflict when the object was changed since it was read
+func strategicMergePatch(resources dynamic.ResourceInterface, name string, dataStruct runtime.Object, resourceVersion string, original, modified map[string]interface{}) (*unstructured.Unstructured, error) {
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return nil, err
+	}
+	modifiedJSON, err := json.Marshal(modified)
+	if err != nil {
+		return nil, err
+	}
+	data, err := strategicpatch.CreateTwoWayMergePatch(originalJSON, modifiedJSON, dataStruct)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create a patch of %s, error: %w", name, err)
+	}
+	if string(data) == "{}" {
+		log.Debugf("%s is up to date, not patching it", name)
+		return nil, nil
+	}
+	if resourceVersion != "" {
+		patchObject := map[string]interface{}{}
+		if err := json.Unmarshal(data, &patchObject); err != nil {
+			return nil, err
+		}
+		unstructured.SetNestedField(patchObject, resourceVersion, "metadata", "resourceVersion")
+		if data, err = json.Marshal(patchObject); err != nil {
+			return nil, err
+		}
+	}
+	log.Debugf("Patching %s: %s", name, data)
+	return resources.Patch(name, types.StrategicMergePatchType, data, metav1.PatchOptions{FieldManager: FieldManager})
+}
+
+func apply(resources dynamic.ResourceInterface, accessor metav1.Object, object runtime.Object, resourceVersion string, fields map[string]interface{}) (*unstructured.Unstructured, error) {
+	kinds, _, err := scheme.Scheme.ObjectKinds(object)
+	if err != nil {
+		return nil, err
+	}
+	applyConfiguration := &unstructured.Unstructured{Object: fields}
+	applyConfiguration.SetGroupVersionKind(kinds[0])
+	applyConfiguration.SetName(accessor.GetName())
+	if accessor.GetNamespace() != "" {
+		applyConfiguration.SetNamespace(accessor.GetNamespace())
+	}
+	if resourceVersion != "" {
+		applyConfiguration.SetResourceVersion(resourceVersion)
+	}
+	data, err := json.Marshal(applyConfiguration)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Applying %s: %s", accessor.GetName(), data)
+	result, err := resources.Patch(accessor.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: FieldManager})
+	if isFieldManagerConflict(err) {
+		// another field manager owns the fields, which a retry would not change
+		return nil, commandUtil.Conflict(fmt.Errorf("Failed to apply %s, the fields are managed by another field manager, error: %v", accessor.GetName(), err))
+	}
+	return result, err
+}
+
+// isFieldManagerConflict returns whether an apply failed because another field manager owns the fields, rather than
+// because the object was changed since it was read
+func isFieldManagerConflict(err error) bool {
+	if !k8serrors.IsConflict(err) {
+		return false
+	}
+	if status, isStatus := err.(k8serrors.APIStatus); isStatus && status.Status().Details != nil {
+		for _, cause := range status.Status().Details.Causes {
+			if cause.Type == metav1.CauseTypeFieldManagerConflict {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unappliedFields returns the fields at the paths whose values in the result of an apply differ from the ones that
+// were applied, e.g. a label that another field manager owns, which the apply does not remove
+func unappliedFields(applied, result map[string]interface{}, paths [][]string) []string {
+	var fields []string
+	for _, path := range paths {
+		appliedValue, _, _ := unstructured.NestedFieldNoCopy(applied, path...)
+		resultValue, _, _ := unstructured.NestedFieldNoCopy(result, path...)
+		if !reflect.DeepEqual(appliedValue, resultValue) {
+			fields = append(fields, strings.Join(path, "."))
+		}
+	}
+	return fields
+}
+
+// ownedFields returns the fields of the object at the paths. withParents keeps the parents of missing fields, so that
+// a merge patch removes only the missing field rather than its parent, e.g. a label rather than all the labels
+func ownedFields(object map[string]interface{}, paths [][]string, withParents bool) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for _, path := range paths {
+		value, found, err := unstructured.NestedFieldNoCopy(object, path...)
+		if err != nil || !found {
+			if withParents && len(path) > 1 {
+				if _, found, _ := unstructured.NestedMap(fields, path[:len(path)-1]...); !found {
+					unstructured.SetNestedMap(fields, map[string]interface{}{}, path[:len(path)-1]...)
+				}
+			}
+			continue
+		}
+		unstructured.SetNestedField(fields, value, path...)
+	}
+	return fields
+}
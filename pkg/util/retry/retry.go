@@ -0,0 +1,73 @@
+// Package retry retries the mutations of API objects on the errors of the API server that are transient, with an
+// exponential backoff with jitter so that concurrent clients do not retry in lockstep.
+package retry
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientretry "k8s.io/client-go/util/retry"
+)
+
+// DefaultBackoff waits about 0.2s, 0.4s, 0.8s and 1.6s between 5 attempts, plus up to half of each wait as jitter
+var DefaultBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: 200 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.5,
+	Cap:      5 * time.Second,
+}
+
+// IsRetriable returns whether an error of the API server is transient: a conflict with a concurrent update of the
+// object, a timeout of the API server, or a throttled request. The error may be wrapped
+func IsRetriable(err error) bool {
+	var apiStatus k8serrors.APIStatus
+	if !errors.As(err, &apiStatus) {
+		return false
+	}
+	statusError := &k8serrors.StatusError{ErrStatus: apiStatus.Status()}
+	return k8serrors.IsConflict(statusError) || k8serrors.IsServerTimeout(statusError) || k8serrors.IsTooManyRequests(statusError)
+}
+
+// Mutation runs a mutation of an API object until it succeeds, fails with an error that is not retriable, or the
+// backoff is exhausted, and returns the last error. The mutation must read the object again on every attempt, so that
+// a retry after a conflict changes the current version of the object rather than overriding it:
+//
+//	err := retry.Mutation("update deployment runai-operator", func() error {
+//		deployment, err := deployments.Get("runai-operator", metav1.GetOptions{})
+//		if err != nil {
+//			return err
+//		}
+//		deployment.Spec.Replicas = &replicas
+//		_, err = deployments.Update(deployment)
+//		return err
+//	})
+func Mutation(description string, mutation func() error) error {
+	return MutationWithBackoff(DefaultBackoff, description, mutation)
+}
+
+// MutationWithBackoff is Mutation with a custom backoff
+func MutationWithBackoff(backoff wait.Backoff, description string, mutation func() error) error {
+	attempt := 0
+	return clientretry.OnError(backoff, IsRetriable, func() error {
+		attempt++
+		err := mutation()
+		if err != nil && IsRetriable(err) {
+			log.Debugf("Failed to %s, attempt: %v, error: %v", description, attempt, err)
+		}
+		return err
+	})
+}
+
+// Deletion runs a deletion of an API object as Mutation does, and succeeds when the object does not exist, e.g. when
+// it was already deleted
+func Deletion(description string, deletion func() error) error {
+	err := Mutation(description, deletion)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Step is a single mutation of a multi-step flow together with the action that compensates for it.
+// Steps that cannot be undone (e.g. deleting resources that are recreated by the operator) leave Rollback nil
+type Step struct {
+	Name     string
+	Do       func() error
+	Rollback func() error
+}
+
+// Observer is notified of the progress of a flow, e.g. to record it in a journal
+type Observer interface {
+	StepStarted(step Step)
+	StepDone(step Step, err error)
+	StepRolledBack(step Step, err error)
+}
+
+// InterruptedError is returned when the user interrupts a flow. A flow is only interrupted between its steps
+type InterruptedError struct {
+	Signal os.Signal
+	Step   string
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("Interrupted by %v before step: %s", e.Signal, e.Step)
+}
+
+// IsInterrupted returns whether a flow stopped because the user interrupted it, rather than because a step failed
+func IsInterrupted(err error) bool {
+	var interruptedError *InterruptedError
+	return errors.As(err, &interruptedError)
+}
+
+// Run executes the steps in order. When a step fails or the user interrupts the command, the steps that
+// were already done are rolled back in reverse order and a report of the rollback is printed.
+// The failed step is rolled back as well, so a Rollback must tolerate a step that was only partially done
+func Run(steps []Step) error {
+	return Resume(steps, 0, nil)
+}
+
+// Resume executes the steps from the step at index first as Run does. The steps before it were done by a previous
+// run of the flow that did not complete, e.g. when its process was killed, and are rolled back with the other steps.
+// The observer, when not nil, is notified of every step that starts, completes or is rolled back
+func Resume(steps []Step, first int, observer Observer) error {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+
+	for i := first; i < len(steps); i++ {
+		step := steps[i]
+		select {
+		case sig := <-interrupts:
+			rollback(steps[:i], observer)
+			return &InterruptedError{Signal: sig, Step: step.Name}
+		default:
+		}
+
+		log.Debugf("Running step: %s", step.Name)
+		if observer != nil {
+			observer.StepStarted(step)
+		}
+		err := step.Do()
+		if observer != nil {
+			observer.StepDone(step, err)
+		}
+		if err != nil {
+			rollback(steps[:i+1], observer)
+			return fmt.Errorf("Failed at step: %s, error: %w", step.Name, err)
+		}
+	}
+	return nil
+}
+
+func rollback(doneSteps []Step, observer Observer) {
+	if len(doneSteps) == 0 {
+		return
+	}
+
+	fmt.Println("Rolling back the completed steps:")
+	for i := len(doneSteps) - 1; i >= 0; i-- {
+		step := doneSteps[i]
+		if step.Rollback == nil {
+			fmt.Printf("  - %s: cannot be rolled back\n", step.Name)
+			continue
+		}
+		err := step.Rollback()
+		if observer != nil {
+			observer.StepRolledBack(step, err)
+		}
+		if err != nil {
+			fmt.Printf("  - %s: failed to roll back, error: %v\n", step.Name, err)
+			continue
+		}
+		fmt.Printf("  - %s: rolled back\n", step.Name)
+	}
+}
@@ -35,3 +35,11 @@ func GetRunaiConfigDir() (string, error) {
 
 	return path.Dir(realPath), nil
 }
+
+// ValueOrDash returns the value, or a dash for an empty value, for the columns of the tables that the commands print
+func ValueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}